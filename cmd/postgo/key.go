@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"os"
+)
+
+const (
+	// passphraseEnv holds the passphrase of the stored key, newPassphraseEnv the one keys are written with.
+	passphraseEnv    = "POSTGO_PASSPHRASE"
+	newPassphraseEnv = "POSTGO_NEW_PASSPHRASE"
+)
+
+func runKey(args []string) error {
+	fs := flag.NewFlagSet("key", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "post data dir holding the key")
+	generate := fs.Bool("generate", false, "generate a new key")
+	from := fs.String("import", "", "import the key of this file, key.bin of go-spacemesh or an exported key")
+	to := fs.String("export", "", "export the key to this file")
+	rotate := fs.Bool("rotate", false, "re-encrypt the key with the new passphrase")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: postgo key -datadir DIR [-generate | -import FILE | -export FILE | -rotate]")
+		fmt.Fprintf(fs.Output(), "the passphrase of the stored key is read from %s, the one keys are written with from %s;\n", passphraseEnv, newPassphraseEnv)
+		fmt.Fprintln(fs.Output(), "an empty passphrase stores the key unencrypted as key.bin")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" {
+		fs.Usage()
+		return errors.New("-datadir is required")
+	}
+	passphrase, newPassphrase := os.Getenv(passphraseEnv), os.Getenv(newPassphraseEnv)
+
+	switch {
+	case *generate:
+		if _, err := shared.KeyNodeId(*dataDir); err == nil {
+			return errors.New("the data dir already has a key")
+		}
+		id, err := shared.GenerateIdentity()
+		if err != nil {
+			return err
+		}
+		if err := shared.SaveIdentity(*dataDir, id, newPassphrase); err != nil {
+			return err
+		}
+	case *from != "":
+		data, err := os.ReadFile(*from)
+		if err != nil {
+			return err
+		}
+		id, err := shared.ImportKey(data, passphrase)
+		if err != nil {
+			return err
+		}
+		if err := shared.SaveIdentity(*dataDir, id, newPassphrase); err != nil {
+			return err
+		}
+	case *to != "":
+		id, err := shared.LoadIdentity(*dataDir, passphrase)
+		if err != nil {
+			return err
+		}
+		data, err := shared.ExportKey(id, newPassphrase)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*to, data, shared.OwnerReadWrite); err != nil {
+			return err
+		}
+	case *rotate:
+		if err := shared.RotateKey(*dataDir, passphrase, newPassphrase); err != nil {
+			return err
+		}
+	}
+	nodeId, err := shared.KeyNodeId(*dataDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("node id: %x\n", nodeId)
+	return nil
+}
@@ -0,0 +1,53 @@
+// Command postgo bundles tools around post-go data dirs.
+//
+//	postgo sim [flags]          predict proving success and scan times for PoST parameters
+//	postgo move [flags]         move a post data file to another directory
+//	postgo rebalance [flags]    spread the post data files evenly over directories
+//	postgo metadata [flags]     show, upgrade or import the metadata of a data dir
+//	postgo key [flags]          generate, import, export or re-encrypt the key of a data dir
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "sim", usage: "predict proving success and scan times for PoST parameters", run: runSim},
+	{name: "move", usage: "move a post data file to another directory", run: runMove},
+	{name: "rebalance", usage: "spread the post data files evenly over directories", run: runRebalance},
+	{name: "metadata", usage: "show, upgrade or import the metadata of a data dir", run: runMetadata},
+	{name: "key", usage: "generate, import, export or re-encrypt the key of a data dir", run: runKey},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: postgo <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+)
+
+func runMetadata(args []string) error {
+	fs := flag.NewFlagSet("metadata", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "post data dir holding the metadata")
+	from := fs.String("import", "", "metadata file or data dir written by postcli to import into -datadir")
+	n := fs.Uint("scrypt-n", 0, "scrypt N the imported data was initialized with, 0 for the default")
+	upgrade := fs.Bool("upgrade", false, "rewrite the metadata of -datadir in the current format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" {
+		return errors.New("-datadir is required")
+	}
+	var m *shared.PostMetadata
+	var err error
+	switch {
+	case *from != "":
+		var scrypt *shared.ScryptParams
+		if *n != 0 {
+			params := shared.DefaultLabelParams()
+			params.N = *n
+			scrypt = &params
+		}
+		m, err = shared.ImportMetadata(*from, *dataDir, scrypt)
+	case *upgrade:
+		if m, err = shared.ReadMetadata(*dataDir); err == nil {
+			err = shared.WriteMetadata(*dataDir, m)
+		}
+	default:
+		m, err = shared.ReadMetadata(*dataDir)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"path/filepath"
+)
+
+func runMove(args []string) error {
+	fs := flag.NewFlagSet("move", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "post data dir holding the metadata")
+	index := fs.Int("file", -1, "N of the postdata_N.bin file to move")
+	to := fs.String("to", "", "directory to move the file to, the data dir moves it back")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" || *index < 0 || *to == "" {
+		return errors.New("-datadir, -file and -to are required")
+	}
+	dir, err := filepath.Abs(*to)
+	if err != nil {
+		return err
+	}
+	if err := shared.MoveInitFile(*dataDir, *index, dir); err != nil {
+		return err
+	}
+	fmt.Printf("moved %s to %s\n", shared.InitFileName(*index), dir)
+	return nil
+}
+
+func runRebalance(args []string) error {
+	fs := flag.NewFlagSet("rebalance", flag.ContinueOnError)
+	dataDir := fs.String("datadir", "", "post data dir holding the metadata")
+	dryRun := fs.Bool("dry-run", false, "only print the moves")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: postgo rebalance -datadir DIR [-dry-run] TARGET_DIR...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dataDir == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("-datadir and at least one target dir are required")
+	}
+	dirs := make([]string, fs.NArg())
+	for i, dir := range fs.Args() {
+		var err error
+		if dirs[i], err = filepath.Abs(dir); err != nil {
+			return err
+		}
+	}
+	layout, err := shared.ReadLayout(*dataDir)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		moves, err := shared.PlanRebalance(layout, dirs)
+		if err != nil {
+			return err
+		}
+		for _, move := range moves {
+			fmt.Println(move)
+		}
+		return nil
+	}
+	moves, err := shared.Rebalance(*dataDir, dirs, func(move shared.Move) {
+		fmt.Println(move)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d files moved\n", len(moves))
+	return nil
+}
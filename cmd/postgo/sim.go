@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"github.com/trying2016/post-go/sim"
+	"os"
+)
+
+func runSim(args []string) error {
+	cfg := shared.MainnetConfig()
+	fs := flag.NewFlagSet("sim", flag.ContinueOnError)
+	numUnits := fs.Uint("units", 4, "number of space units")
+	labelsPerUnit := fs.Uint64("labels-per-unit", cfg.LabelsPerUnit, "labels per unit")
+	nonces := fs.Uint("nonces", 128, "nonces per pass, a multiple of 16")
+	k1 := fs.Uint("k1", uint(cfg.K1), "K1")
+	k2 := fs.Uint("k2", uint(cfg.K2), "K2")
+	k3 := fs.Uint("k3", uint(cfg.K3), "K3")
+	powDifficulty := fs.String("pow-difficulty", hex.EncodeToString(cfg.PowDifficulty[:]), "k2pow difficulty in hex")
+	disk := fs.Float64("disk", 0, "disk throughput in MiB/s, 0 skips the times")
+	aes := fs.Float64("aes", 0, "AES throughput of one thread and one nonce group in MiB/s")
+	threads := fs.Int("threads", 1, "prover threads")
+	trials := fs.Int("trials", 0, "number of Monte Carlo trials, 0 only computes the analytic result")
+	seed := fs.Int64("seed", 1, "seed of the simulation")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	difficulty, err := hex.DecodeString(*powDifficulty)
+	if err != nil {
+		return fmt.Errorf("decoding pow difficulty: %w", err)
+	}
+
+	params := sim.Params{
+		NumUnits:        uint32(*numUnits),
+		LabelsPerUnit:   *labelsPerUnit,
+		Nonces:          uint32(*nonces),
+		K1:              uint32(*k1),
+		K2:              uint32(*k2),
+		K3:              uint32(*k3),
+		PowDifficulty:   difficulty,
+		DiskBytesPerSec: *disk * shared.MiB,
+		AESBytesPerSec:  *aes * shared.MiB,
+		Threads:         *threads,
+	}
+	result, err := sim.Analyze(params)
+	if err != nil {
+		return err
+	}
+	var simulation *sim.Simulation
+	if *trials > 0 {
+		if simulation, err = sim.Simulate(params, *trials, *seed); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Params     sim.Params
+			Analytic   *sim.Result
+			Simulation *sim.Simulation `json:",omitempty"`
+		}{params, result, simulation})
+	}
+
+	fmt.Printf("labels:              %d (%d bytes)\n", result.NumLabels, result.NumLabels*16)
+	fmt.Printf("label difficulty:    %#016x\n", result.Difficulty)
+	fmt.Printf("k2pow difficulty:    %x (%.3g hashes per group)\n", result.PoWDifficulty, result.PowHashes)
+	fmt.Printf("candidates/nonce:    %.3f\n", result.Lambda)
+	fmt.Printf("nonce probability:   %.6f\n", result.NonceProbability)
+	fmt.Printf("pass probability:    %.6f\n", result.PassProbability)
+	fmt.Printf("expected passes:     %.3f\n", result.ExpectedPasses)
+	if result.PassTime > 0 {
+		fmt.Printf("pass time:           %v\n", result.PassTime)
+		fmt.Printf("expected time:       %v\n", result.ExpectedTime)
+	}
+	fmt.Printf("proof size:          %d bytes (%d bits per index, %d labels verified)\n", result.ProofSize, result.IndexBits, result.VerifiedLabels)
+	if simulation != nil {
+		fmt.Printf("simulated trials:    %d\n", simulation.Trials)
+		fmt.Printf("  pass probability:  %.6f\n", simulation.PassProbability)
+		fmt.Printf("  mean passes:       %.3f (max %d)\n", simulation.MeanPasses, simulation.MaxPasses)
+		fmt.Printf("  mean last pass:    %.3f read\n", simulation.MeanFraction)
+		if simulation.MeanTime > 0 {
+			fmt.Printf("  mean time:         %v\n", simulation.MeanTime)
+		}
+	}
+	return nil
+}
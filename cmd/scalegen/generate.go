@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// kind is how a field is encoded.
+type kind int
+
+const (
+	kindCompact kind = iota
+	kindBool
+	kindString
+	kindBytes
+	kindStringSlice
+	kindFixedBytes
+	kindArray
+	kindStruct
+	kindOption
+	kindStructSlice
+	kindStructArray
+	kindUint
+	kindInt
+	kindU256
+)
+
+// field is a struct field and its encoding.
+type field struct {
+	name string
+	kind kind
+	// bits of an integer
+	bits int
+	// max is the limit of length prefixed fields, size the length of fixed ones.
+	max  uint32
+	size int
+	// conv is the named type of the field, set when it differs from the type the codec works with.
+	conv string
+	// elem is the type of the struct of an option, struct slice or struct array.
+	elem string
+}
+
+// pkgInfo are the declarations of the package of the file.
+type pkgInfo struct {
+	name string
+	// decls maps type names to their type expressions.
+	decls map[string]ast.Expr
+	// scale are the types with hand-written or generated EncodeScale methods.
+	scale map[string]bool
+}
+
+// Generate returns the source of the EncodeScale and DecodeScale methods of the structs names of file,
+// or of all its structs if names is empty.
+func Generate(file string, names []string) ([]byte, error) {
+	pkg, err := parsePackage(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var structs []*ast.TypeSpec
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if _, ok := ts.Type.(*ast.StructType); ok && (len(names) == 0 || contains(names, ts.Name.Name)) {
+				structs = append(structs, ts)
+			}
+		}
+	}
+	for _, name := range names {
+		if !containsSpec(structs, name) {
+			return nil, fmt.Errorf("no struct %s in %s", name, file)
+		}
+	}
+	if len(structs) == 0 {
+		return nil, fmt.Errorf("no structs in %s", file)
+	}
+
+	var body bytes.Buffer
+	usesFmt := false
+	for _, ts := range structs {
+		fields, err := pkg.fields(ts.Name.Name, ts.Type.(*ast.StructType))
+		if err != nil {
+			return nil, err
+		}
+		for _, fd := range fields {
+			usesFmt = usesFmt || fd.kind == kindFixedBytes
+		}
+		writeEncode(&body, ts.Name.Name, fields)
+		writeDecode(&body, ts.Name.Name, fields)
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by scalegen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg.name)
+	if usesFmt {
+		src.WriteString("\t\"fmt\"\n")
+	}
+	src.WriteString("\t\"github.com/trying2016/post-go/codec\"\n)\n")
+	src.Write(body.Bytes())
+	code, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+	return code, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSpec(specs []*ast.TypeSpec, name string) bool {
+	for _, ts := range specs {
+		if ts.Name.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePackage collects the type declarations and scale methods of the package in dir. Generated
+// files of target are skipped, they are about to be replaced.
+func parsePackage(dir, target string) (*pkgInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &pkgInfo{decls: make(map[string]ast.Expr), scale: make(map[string]bool)}
+	generated := strings.TrimSuffix(target, ".go") + "_scale.go"
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || name == generated {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		pkg.name = f.Name.Name
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				if decl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					ts := spec.(*ast.TypeSpec)
+					pkg.decls[ts.Name.Name] = ts.Type
+				}
+			case *ast.FuncDecl:
+				if decl.Recv != nil && decl.Name.Name == "EncodeScale" {
+					pkg.scale[receiverName(decl.Recv.List[0].Type)] = true
+				}
+			}
+		}
+	}
+	return pkg, nil
+}
+
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// fields classifies the fields of struct name.
+func (pkg *pkgInfo) fields(name string, st *ast.StructType) ([]field, error) {
+	var fields []field
+	for _, f := range st.Fields.List {
+		tag := ""
+		if f.Tag != nil {
+			unquoted, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(unquoted).Get("scale")
+		}
+		if tag == "-" {
+			continue
+		}
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		opts, err := parseTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", name, f.Names[0].Name, err)
+		}
+		for _, ident := range f.Names {
+			fd, err := pkg.classify(f.Type, opts, "")
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", name, ident.Name, err)
+			}
+			fd.name = ident.Name
+			fields = append(fields, fd)
+		}
+	}
+	return fields, nil
+}
+
+// tagOptions are the options of a scale struct tag.
+type tagOptions struct {
+	max     uint32
+	hasMax  bool
+	fixed   int
+	compact bool
+	// fixedInt encodes unsigned integers with all their bytes instead of compact.
+	fixedInt bool
+	u256     bool
+}
+
+func parseTag(tag string) (tagOptions, error) {
+	var opts tagOptions
+	if tag == "" {
+		return opts, nil
+	}
+	for _, part := range strings.Split(tag, ",") {
+		key, value := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			key, value = part[:i], part[i+1:]
+		}
+		switch key {
+		case "max":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return opts, fmt.Errorf("invalid scale tag %q: %w", part, err)
+			}
+			opts.max, opts.hasMax = uint32(n), true
+		case "fixed":
+			if value == "" {
+				opts.fixedInt = true
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return opts, fmt.Errorf("invalid scale tag %q; expected: fixed=N with N > 0", part)
+			}
+			opts.fixed = n
+		case "compact":
+			opts.compact = true
+		case "u256":
+			opts.u256 = true
+		default:
+			return opts, fmt.Errorf("unknown scale tag %q", part)
+		}
+	}
+	if opts.compact && opts.fixedInt {
+		return opts, fmt.Errorf("invalid scale tag %q; compact and fixed exclude each other", tag)
+	}
+	return opts, nil
+}
+
+var intBits = map[string]int{"int8": 8, "int16": 16, "int32": 32, "int64": 64}
+
+var compactBits = map[string]int{"uint8": 8, "byte": 8, "uint16": 16, "uint32": 32, "uint64": 64}
+
+// classify returns the encoding of a field of type expr. conv is the named type expr resolves from.
+func (pkg *pkgInfo) classify(expr ast.Expr, opts tagOptions, conv string) (field, error) {
+	typeName := types.ExprString(expr)
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if bits, ok := compactBits[t.Name]; ok {
+			if opts.fixedInt {
+				return field{kind: kindUint, bits: bits, conv: conv}, nil
+			}
+			return field{kind: kindCompact, bits: bits, conv: conv}, nil
+		}
+		if bits, ok := intBits[t.Name]; ok {
+			return field{kind: kindInt, bits: bits, conv: conv}, nil
+		}
+		switch t.Name {
+		case "bool":
+			return field{kind: kindBool, conv: conv}, nil
+		case "string":
+			if !opts.hasMax {
+				return field{}, fmt.Errorf("string requires a `scale:\"max=N\"` tag")
+			}
+			return field{kind: kindString, max: opts.max, conv: conv}, nil
+		}
+		decl, ok := pkg.decls[t.Name]
+		if !ok {
+			return field{}, fmt.Errorf("unsupported type %s", t.Name)
+		}
+		if _, ok := decl.(*ast.StructType); ok || pkg.scale[t.Name] {
+			return field{kind: kindStruct}, nil
+		}
+		if conv == "" {
+			conv = t.Name
+		}
+		return pkg.classify(decl, opts, conv)
+	case *ast.SelectorExpr:
+		// 其他包的类型，要求实现了codec.Encodable和codec.Decodable
+		return field{kind: kindStruct}, nil
+	case *ast.StarExpr:
+		if typeName == "*big.Int" {
+			if !opts.u256 {
+				return field{}, fmt.Errorf("*big.Int requires a `scale:\"u256\"` tag")
+			}
+			return field{kind: kindU256}, nil
+		}
+		if !pkg.isStruct(t.X) {
+			return field{}, fmt.Errorf("options are only supported for structs, given: %s", typeName)
+		}
+		return field{kind: kindOption, elem: types.ExprString(t.X)}, nil
+	case *ast.ArrayType:
+		elem := types.ExprString(t.Elt)
+		if t.Len != nil && pkg.isStruct(t.Elt) {
+			return field{kind: kindStructArray, elem: elem}, nil
+		}
+		if t.Len != nil {
+			lit, ok := t.Len.(*ast.BasicLit)
+			if !ok || (elem != "byte" && elem != "uint8") {
+				return field{}, fmt.Errorf("only byte arrays of literal length and struct arrays are supported, given: %s", typeName)
+			}
+			size, err := strconv.Atoi(lit.Value)
+			if err != nil {
+				return field{}, err
+			}
+			return field{kind: kindArray, size: size}, nil
+		}
+		switch {
+		case elem == "byte" || elem == "uint8":
+			if opts.fixed > 0 {
+				return field{kind: kindFixedBytes, size: opts.fixed, conv: conv}, nil
+			}
+			if !opts.hasMax {
+				return field{}, fmt.Errorf("%s requires a `scale:\"max=N\"` or `scale:\"fixed=N\"` tag", typeName)
+			}
+			return field{kind: kindBytes, max: opts.max, conv: conv}, nil
+		case !opts.hasMax:
+			return field{}, fmt.Errorf("%s requires a `scale:\"max=N\"` tag", typeName)
+		case elem == "string":
+			return field{kind: kindStringSlice, max: opts.max, conv: conv}, nil
+		case pkg.isStruct(t.Elt):
+			return field{kind: kindStructSlice, max: opts.max, elem: elem}, nil
+		}
+	case *ast.MapType:
+		return field{}, fmt.Errorf("maps are not supported, encode %s with codec.EncodeMap by hand", typeName)
+	}
+	return field{}, fmt.Errorf("unsupported type %s", typeName)
+}
+
+// isStruct reports whether expr is a struct of the package or a type of another package.
+func (pkg *pkgInfo) isStruct(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		_, ok := pkg.decls[t.Name].(*ast.StructType)
+		return ok || pkg.scale[t.Name]
+	case *ast.SelectorExpr:
+		return true
+	}
+	return false
+}
+
+func receiver(typeName string) string {
+	return strings.ToLower(typeName[:1])
+}
+
+// value returns the expression passed to the codec for field f of receiver r.
+func (f field) value(r, base string) string {
+	v := r + "." + f.name
+	if f.conv != "" {
+		return base + "(" + v + ")"
+	}
+	return v
+}
+
+// assign returns the assignment of the decoded value to field f of receiver r.
+func (f field) assign(r string) string {
+	if f.conv != "" {
+		return fmt.Sprintf("%s.%s = %s(field)", r, f.name, f.conv)
+	}
+	return fmt.Sprintf("%s.%s = field", r, f.name)
+}
+
+const checkErr = "\t\tif err != nil {\n\t\t\treturn total, err\n\t\t}\n\t\ttotal += n\n"
+
+func writeEncode(w *bytes.Buffer, typeName string, fields []field) {
+	r := receiver(typeName)
+	fmt.Fprintf(w, "\n// EncodeScale implements scale codec interface.\nfunc (%s *%s) EncodeScale(enc *codec.Encoder) (total int, err error) {\n", r, typeName)
+	for _, f := range fields {
+		w.WriteString("\t{\n")
+		switch f.kind {
+		case kindCompact:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeCompact%d(enc, uint%d(%s.%s))\n", f.bits, f.bits, r, f.name)
+		case kindUint:
+			if f.bits == 8 {
+				fmt.Fprintf(w, "\t\tn, err := codec.EncodeByte(enc, byte(%s.%s))\n", r, f.name)
+			} else {
+				fmt.Fprintf(w, "\t\tn, err := codec.EncodeUint%d(enc, uint%d(%s.%s))\n", f.bits, f.bits, r, f.name)
+			}
+		case kindInt:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeInt%d(enc, int%d(%s.%s))\n", f.bits, f.bits, r, f.name)
+		case kindU256:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeU256(enc, %s.%s)\n", r, f.name)
+		case kindBool:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeBool(enc, %s)\n", f.value(r, "bool"))
+		case kindString:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeStringWithLimit(enc, %s, %d)\n", f.value(r, "string"), f.max)
+		case kindBytes:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeByteSliceWithLimit(enc, %s, %d)\n", f.value(r, "[]byte"), f.max)
+		case kindStringSlice:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeStringSliceWithLimit(enc, %s, %d)\n", f.value(r, "[]string"), f.max)
+		case kindFixedBytes:
+			fmt.Fprintf(w, "\t\tif len(%s.%s) != %d {\n", r, f.name, f.size)
+			fmt.Fprintf(w, "\t\t\treturn total, fmt.Errorf(\"invalid %s length; expected: %d, given: %%d\", len(%s.%s))\n\t\t}\n", f.name, f.size, r, f.name)
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeByteArray(enc, %s)\n", f.value(r, "[]byte"))
+		case kindArray:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeByteArray(enc, %s.%s[:])\n", r, f.name)
+		case kindStruct:
+			fmt.Fprintf(w, "\t\tn, err := %s.%s.EncodeScale(enc)\n", r, f.name)
+		case kindOption:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeOption(enc, %s.%s)\n", r, f.name)
+		case kindStructSlice:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeStructSliceWithLimit(enc, len(%s.%s), func(i int) codec.Encodable { return &%s.%s[i] }, %d)\n", r, f.name, r, f.name, f.max)
+		case kindStructArray:
+			fmt.Fprintf(w, "\t\tn, err := codec.EncodeStructArray(enc, len(%s.%s), func(i int) codec.Encodable { return &%s.%s[i] })\n", r, f.name, r, f.name)
+		}
+		w.WriteString(checkErr)
+		w.WriteString("\t}\n")
+	}
+	w.WriteString("\treturn total, nil\n}\n")
+}
+
+func writeDecode(w *bytes.Buffer, typeName string, fields []field) {
+	r := receiver(typeName)
+	fmt.Fprintf(w, "\n// DecodeScale implements scale codec interface.\nfunc (%s *%s) DecodeScale(dec *codec.Decoder) (total int, err error) {\n", r, typeName)
+	for _, f := range fields {
+		w.WriteString("\t{\n")
+		switch f.kind {
+		case kindCompact:
+			fmt.Fprintf(w, "\t\tfield, n, err := codec.DecodeCompact%d(dec)\n", f.bits)
+		case kindUint:
+			if f.bits == 8 {
+				w.WriteString("\t\tfield, n, err := codec.DecodeByte(dec)\n")
+			} else {
+				fmt.Fprintf(w, "\t\tfield, n, err := codec.DecodeUint%d(dec)\n", f.bits)
+			}
+		case kindInt:
+			fmt.Fprintf(w, "\t\tfield, n, err := codec.DecodeInt%d(dec)\n", f.bits)
+		case kindU256:
+			w.WriteString("\t\tfield, n, err := codec.DecodeU256(dec)\n")
+		case kindBool:
+			w.WriteString("\t\tfield, n, err := codec.DecodeBool(dec)\n")
+		case kindString:
+			fmt.Fprintf(w, "\t\tfield, n, err := codec.DecodeStringWithLimit(dec, %d)\n", f.max)
+		case kindBytes:
+			fmt.Fprintf(w, "\t\tfield, n, err := codec.DecodeByteSliceWithLimit(dec, %d)\n", f.max)
+		case kindStringSlice:
+			fmt.Fprintf(w, "\t\tfield, n, err := codec.DecodeStringSliceWithLimit(dec, %d)\n", f.max)
+		case kindFixedBytes:
+			fmt.Fprintf(w, "\t\tfield := make([]byte, %d)\n", f.size)
+			w.WriteString("\t\tn, err := codec.DecodeByteArray(dec, field)\n")
+		case kindArray:
+			fmt.Fprintf(w, "\t\tn, err := codec.DecodeByteArray(dec, %s.%s[:])\n", r, f.name)
+			w.WriteString(checkErr + "\t}\n")
+			continue
+		case kindStruct:
+			fmt.Fprintf(w, "\t\tn, err := %s.%s.DecodeScale(dec)\n", r, f.name)
+			w.WriteString(checkErr + "\t}\n")
+			continue
+		case kindOption:
+			fmt.Fprintf(w, "\t\tvar field %s\n", f.elem)
+			w.WriteString("\t\texists, n, err := codec.DecodeOption(dec, &field)\n")
+			w.WriteString(checkErr)
+			fmt.Fprintf(w, "\t\tif exists {\n\t\t\t%s.%s = &field\n\t\t}\n\t}\n", r, f.name)
+			continue
+		case kindStructSlice:
+			fmt.Fprintf(w, "\t\tn, err := codec.DecodeStructSliceWithLimit(dec, %d,\n", f.max)
+			fmt.Fprintf(w, "\t\t\tfunc(length int) { %s.%s = make([]%s, length) },\n", r, f.name, f.elem)
+			fmt.Fprintf(w, "\t\t\tfunc(i int) codec.Decodable { return &%s.%s[i] })\n", r, f.name)
+			w.WriteString(checkErr + "\t}\n")
+			continue
+		case kindStructArray:
+			fmt.Fprintf(w, "\t\tn, err := codec.DecodeStructArray(dec, len(%s.%s), func(i int) codec.Decodable { return &%s.%s[i] })\n", r, f.name, r, f.name)
+			w.WriteString(checkErr + "\t}\n")
+			continue
+		}
+		w.WriteString(checkErr)
+		fmt.Fprintf(w, "\t\t%s\n\t}\n", f.assign(r))
+	}
+	w.WriteString("\treturn total, nil\n}\n")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedUpToDate(t *testing.T) {
+	code, err := Generate("../../shared/proof.go", []string{"Proof", "ProofMetadata", "VRFNonceMetadata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := os.ReadFile("../../shared/proof_scale.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, current) {
+		t.Fatal("shared/proof_scale.go is outdated, run go generate ./shared")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	code, err := Generate("testdata/types.go", []string{"Everything"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"codec.EncodeCompact8(enc, uint8(e.Small))",
+		"codec.EncodeCompact16(enc, uint16(e.Count))",
+		"e.Count = Count(field)",
+		"codec.EncodeStringWithLimit(enc, e.Name, 32)",
+		"codec.EncodeByteSliceWithLimit(enc, e.Data, 1024)",
+		"codec.EncodeStringSliceWithLimit(enc, e.Tags, 8)",
+		"if len(e.Id) != 32 {",
+		"e.Id = Hash(field)",
+		"codec.EncodeByteArray(enc, e.Array[:])",
+		"e.Proof.EncodeScale(enc)",
+		"exists, n, err := codec.DecodeOption(dec, &field)",
+		"codec.EncodeStructSliceWithLimit(enc, len(e.Items), func(i int) codec.Encodable { return &e.Items[i] }, 16)",
+		"func(length int) { e.Items = make([]Inner, length) },",
+		"codec.EncodeStructArray(enc, len(e.Pair), func(i int) codec.Encodable { return &e.Pair[i] })",
+		"codec.DecodeStructArray(dec, len(e.Pair), func(i int) codec.Decodable { return &e.Pair[i] })",
+		"codec.EncodeUint32(enc, uint32(e.Fixed))",
+		"codec.EncodeByte(enc, byte(e.Flags))",
+		"codec.DecodeInt64(dec)",
+		"codec.EncodeInt32(enc, int32(e.Offset))",
+		"e.Offset = Offset(field)",
+		"codec.EncodeU256(enc, e.Target)",
+	} {
+		if !strings.Contains(string(code), expected) {
+			t.Errorf("generated code lacks %q", expected)
+		}
+	}
+	if strings.Contains(string(code), "Ignored") {
+		t.Error("skipped field encoded")
+	}
+
+	if _, err := Generate("testdata/types.go", []string{"MissingLimit"}); err == nil || !strings.Contains(err.Error(), "max=N") {
+		t.Fatalf("expected an error about the missing limit, got %v", err)
+	}
+	if _, err := Generate("testdata/types.go", []string{"WithMap"}); err == nil || !strings.Contains(err.Error(), "codec.EncodeMap") {
+		t.Fatalf("expected an error about the map, got %v", err)
+	}
+	if _, err := Generate("testdata/types.go", []string{"Missing"}); err == nil {
+		t.Fatal("expected an error for a missing struct")
+	}
+}
@@ -0,0 +1,65 @@
+// Command scalegen generates EncodeScale and DecodeScale methods, the codec.Encodable and
+// codec.Decodable interfaces, for structs. It is meant to be run by go generate:
+//
+//	//go:generate go run github.com/trying2016/post-go/cmd/scalegen -types Proof,ProofMetadata
+//
+// reads $GOFILE and writes the methods of the listed structs, or of all structs of the file, to
+// <file>_scale.go. Fields are encoded in order, by type and `scale` struct tag:
+//
+//	uint8, byte, uint16, uint32, uint64    compact integer, `scale:"compact"` may be given,
+//	                                       `scale:"fixed"` encodes all bytes little endian
+//	int8, int16, int32, int64              all bytes little endian
+//	bool                                   one byte
+//	string, []byte, []string               length prefixed, require `scale:"max=N"`
+//	[]byte `scale:"fixed=N"`               exactly N bytes, the length is checked when encoding
+//	[N]byte                                N bytes
+//	struct, types with EncodeScale         nested
+//	*T                                     option, T must be a struct
+//	[]T of structs                         length prefixed, requires `scale:"max=N"`
+//	[N]T of structs                        N structs
+//	*big.Int `scale:"u256"`                 32 bytes big endian
+//
+// Maps are not supported, their methods are written by hand with codec.EncodeMap.
+// Named types are encoded as their underlying type. Fields tagged `scale:"-"` are skipped.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	file := flag.String("file", os.Getenv("GOFILE"), "go file with the structs, $GOFILE when run by go generate")
+	typeList := flag.String("types", "", "comma separated structs to generate for, all structs of the file if empty")
+	out := flag.String("out", "", "output file, <file>_scale.go if empty")
+	flag.Parse()
+	if err := run(*file, *typeList, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "scalegen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(file, typeList, out string) error {
+	if file == "" {
+		return errors.New("-file is required outside of go generate")
+	}
+	var types []string
+	if typeList != "" {
+		types = strings.Split(typeList, ",")
+	}
+	code, err := Generate(file, types)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		out = strings.TrimSuffix(file, ".go") + "_scale.go"
+	}
+	if !filepath.IsAbs(out) && filepath.Dir(out) == "." {
+		out = filepath.Join(filepath.Dir(file), out)
+	}
+	return os.WriteFile(out, code, 0o644)
+}
@@ -0,0 +1,47 @@
+package testdata
+
+import (
+	"github.com/trying2016/post-go/shared"
+	"math/big"
+)
+
+type Hash []byte
+
+type Count uint16
+
+type Offset int32
+
+type Inner struct {
+	Value uint8
+}
+
+type Everything struct {
+	Small   byte
+	Count   Count
+	Big     uint64 `scale:"compact"`
+	Flag    bool
+	Name    string   `scale:"max=32"`
+	Data    []byte   `scale:"max=1024"`
+	Tags    []string `scale:"max=8"`
+	Id      Hash     `scale:"fixed=32"`
+	Array   [4]byte
+	Inner   Inner
+	Proof   shared.Proof
+	Maybe   *Inner
+	Items   []Inner `scale:"max=16"`
+	Pair    [2]Inner
+	Fixed   uint32 `scale:"fixed"`
+	Flags   uint8  `scale:"fixed"`
+	Delta   int64
+	Offset  Offset
+	Target  *big.Int `scale:"u256"`
+	Ignored chan int `scale:"-"`
+}
+
+type MissingLimit struct {
+	Data []byte
+}
+
+type WithMap struct {
+	Values map[string]uint64
+}
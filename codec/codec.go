@@ -78,3 +78,21 @@ func Decode(buf []byte, value Decodable) error {
 	}
 	return nil
 }
+
+// DecodeStrict decodes value from buf like Decode, but rejects non canonical encodings and inputs
+// longer than maxBytes. Use it for untrusted input, so that every value has a single encoding and
+// a peer can't make the decoder allocate more than it sent.
+func DecodeStrict(buf []byte, value Decodable, maxBytes int) error {
+	if len(buf) > maxBytes {
+		return fmt.Errorf("decode from buffer: %w: %d bytes", ErrDecodeTooManyBytes, maxBytes)
+	}
+	d := NewDecoder(bytes.NewReader(buf), WithDecodeMaxNested(6), WithDecodeStrict(), WithDecodeMaxBytes(len(buf)))
+	n, err := value.DecodeScale(d)
+	if err != nil {
+		return fmt.Errorf("decode from buffer: %w", err)
+	}
+	if n != len(buf) {
+		return ErrShortRead
+	}
+	return nil
+}
@@ -20,6 +20,12 @@ var (
 
 	// ErrDecodeNestedTooDeep is returned when nested level is too deep.
 	ErrDecodeNestedTooDeep = errors.New("nested level is too deep")
+
+	// ErrDecodeNonCanonical is returned by strict decoders for encodings the encoder never produces.
+	ErrDecodeNonCanonical = errors.New("non canonical encoding")
+
+	// ErrDecodeTooManyBytes is returned when the input exceeds the byte budget of the decoder.
+	ErrDecodeTooManyBytes = errors.New("input exceeds the decoder byte budget")
 )
 
 type Decodable interface {
@@ -44,11 +50,32 @@ func WithDecodeMaxElements(elements uint32) decoderOpts {
 	}
 }
 
+// WithDecodeStrict rejects encodings the encoder never produces, like compact integers with
+// superfluous bytes or booleans other than 0 and 1, so every value has exactly one accepted encoding.
+func WithDecodeStrict() decoderOpts {
+	return func(d *Decoder) {
+		d.strict = true
+	}
+}
+
+// WithDecodeMaxBytes limits the total number of bytes the decoder reads. Lengths that exceed what
+// is left of the budget are rejected before anything is allocated for them.
+// A value of 0 means no limit, the default.
+func WithDecodeMaxBytes(bytes int) decoderOpts {
+	return func(d *Decoder) {
+		d.maxBytes = bytes
+	}
+}
+
 type Decoder struct {
 	r           io.Reader
 	scratch     [9]byte
 	maxNested   uint
 	maxElements uint32
+	strict      bool
+	maxBytes    int
+	// consumed is the number of bytes read so far.
+	consumed int
 }
 
 func (d *Decoder) enterNested() error {
@@ -64,7 +91,29 @@ func (e *Decoder) leaveNested() {
 }
 
 func (d *Decoder) read(buf []byte) (int, error) {
-	return io.ReadFull(d.r, buf)
+	if err := d.reserve(len(buf)); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(d.r, buf)
+	d.consumed += n
+	return n, err
+}
+
+// reserve checks that size more bytes fit the byte budget.
+func (d *Decoder) reserve(size int) error {
+	if d.maxBytes > 0 && size > d.maxBytes-d.consumed {
+		return fmt.Errorf("%w: %d bytes", ErrDecodeTooManyBytes, d.maxBytes)
+	}
+	return nil
+}
+
+// canonicalBigInt checks that the most significant byte of a compact integer in big integer mode is
+// used, otherwise the value has a shorter encoding.
+func (d *Decoder) canonicalBigInt(needed byte) error {
+	if d.strict && d.scratch[needed-1] == 0 {
+		return fmt.Errorf("%w: compact integer with %d bytes", ErrDecodeNonCanonical, needed)
+	}
+	return nil
 }
 
 func DecodeByte(d *Decoder) (byte, int, error) {
@@ -194,6 +243,9 @@ func DecodeCompact32(d *Decoder) (uint32, int, error) {
 			return value, 0, err
 		}
 		total += int(needed)
+		if err := d.canonicalBigInt(needed); err != nil {
+			return 0, 0, err
+		}
 		for i := 0; i < int(needed); i++ {
 			value |= uint32(d.scratch[i]) << (8 * i)
 		}
@@ -261,6 +313,9 @@ func DecodeCompact64(d *Decoder) (uint64, int, error) {
 			return 0, 0, err
 		}
 		total += n
+		if err := d.canonicalBigInt(needed); err != nil {
+			return 0, 0, err
+		}
 		for i := 0; i < int(needed); i++ {
 			value |= uint64(d.scratch[i]) << (8 * i)
 		}
@@ -279,6 +334,9 @@ func DecodeBool(d *Decoder) (bool, int, error) {
 	if d.scratch[0] == 1 {
 		return true, n, nil
 	}
+	if d.strict && d.scratch[0] != 0 {
+		return false, 0, fmt.Errorf("%w: bool %d", ErrDecodeNonCanonical, d.scratch[0])
+	}
 	return false, n, nil
 }
 
@@ -294,6 +352,9 @@ func DecodeByteSliceWithLimit(d *Decoder, limit uint32) ([]byte, int, error) {
 	if lth == 0 {
 		return nil, total, nil
 	}
+	if err := d.reserve(int(lth)); err != nil {
+		return nil, 0, err
+	}
 	value := make([]byte, lth)
 	n, err := DecodeByteArray(d, value)
 	if err != nil {
@@ -325,7 +386,11 @@ func DecodeSliceOfByteSliceWithLimit(d *Decoder, limit uint32) ([][]byte, int, e
 		return nil, 0, fmt.Errorf("DecodeLen failed: %w", err)
 	}
 	if resultLen == 0 {
-		return nil, 0, nil
+		return nil, total, nil
+	}
+	// 每个元素至少有一个字节的长度前缀
+	if err := d.reserve(int(resultLen)); err != nil {
+		return nil, 0, err
 	}
 	result := make([][]byte, 0, resultLen)
 
@@ -351,7 +416,7 @@ func DecodeStringSliceWithLimit(d *Decoder, limit uint32) ([]string, int, error)
 		return nil, 0, err
 	}
 	if sliceOfByteSlices == nil {
-		return nil, 0, nil
+		return nil, n, nil
 	}
 	result := make([]string, 0, len(sliceOfByteSlices))
 	for i := range sliceOfByteSlices {
@@ -260,10 +260,16 @@ func EncodeOption(e *Encoder, value Encodable) (int, error) {
 	}
 	return total + n, nil
 }
+
+// IsNil reports whether i is nil or holds a nil pointer, map, slice, channel, function or interface.
 func IsNil(i interface{}) bool {
-	defer func() {
-		recover()
-	}()
+	if i == nil {
+		return true
+	}
 	vi := reflect.ValueOf(i)
-	return vi.IsNil()
+	switch vi.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return vi.IsNil()
+	}
+	return false
 }
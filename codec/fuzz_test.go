@@ -0,0 +1,227 @@
+//go:build go1.18
+
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+// decodeFunc decodes a value and returns the function to encode it again.
+type decodeFunc func(d *Decoder) (EncodeFunc, int, error)
+
+// fuzzDecode feeds arbitrary input to decode. The lenient decoder must not panic and must report the
+// bytes it consumed; whatever the strict decoder accepts must encode back to the bytes it consumed.
+func fuzzDecode(f *testing.F, decode decodeFunc, seeds ...string) {
+	f.Add([]byte{})
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		if _, n, err := decode(NewDecoder(r, WithDecodeMaxBytes(len(data)))); err == nil && n != len(data)-r.Len() {
+			t.Fatalf("reported %d bytes, consumed %d", n, len(data)-r.Len())
+		}
+
+		r = bytes.NewReader(data)
+		encode, n, err := decode(NewDecoder(r, WithDecodeStrict(), WithDecodeMaxBytes(len(data))))
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if _, err := encode(NewEncoder(&buf)); err != nil {
+			t.Fatalf("decoded value does not encode: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), data[:n]) {
+			t.Fatalf("%x decoded and encoded as %x", data[:n], buf.Bytes())
+		}
+	})
+}
+
+func FuzzDecodeByte(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeByte(d)
+		return func(e *Encoder) (int, error) { return EncodeByte(e, v) }, n, err
+	}, "\x01")
+}
+
+func FuzzDecodeCompact8(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeCompact8(d)
+		return func(e *Encoder) (int, error) { return EncodeCompact8(e, v) }, n, err
+	}, "\x04", "\x01\x01", "\xfd\x03")
+}
+
+func FuzzDecodeCompact16(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeCompact16(d)
+		return func(e *Encoder) (int, error) { return EncodeCompact16(e, v) }, n, err
+	}, "\xfd\xff", "\x02\x00\x01\x00", "\xfe\xff\x03\x00")
+}
+
+func FuzzDecodeCompact32(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeCompact32(d)
+		return func(e *Encoder) (int, error) { return EncodeCompact32(e, v) }, n, err
+	}, "\xfe\xff\xff\xff", "\x03\x00\x00\x00\x40", "\x03\xff\xff\xff\xff")
+}
+
+func FuzzDecodeCompact64(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeCompact64(d)
+		return func(e *Encoder) (int, error) { return EncodeCompact64(e, v) }, n, err
+	}, "\x03\x00\x00\x00\x40", "\x07\x00\x00\x00\x40\x00", "\x13\xff\xff\xff\xff\xff\xff\xff\xff")
+}
+
+func FuzzDecodeLen(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeLen(d, 1000)
+		return func(e *Encoder) (int, error) { return EncodeLen(e, v, 1000) }, n, err
+	}, "\xa1\x0f", "\xa5\x0f")
+}
+
+func FuzzDecodeBool(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeBool(d)
+		return func(e *Encoder) (int, error) { return EncodeBool(e, v) }, n, err
+	}, "\x00", "\x01", "\x02")
+}
+
+func FuzzDecodeByteSlice(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeByteSliceWithLimit(d, 64)
+		return func(e *Encoder) (int, error) { return EncodeByteSliceWithLimit(e, v, 64) }, n, err
+	}, "\x00", "\x0c123", "\xa1\x0f")
+}
+
+func FuzzDecodeString(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeString(d)
+		return func(e *Encoder) (int, error) { return EncodeString(e, v) }, n, err
+	}, "\x0cabc")
+}
+
+func FuzzDecodeSliceOfByteSlice(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeSliceOfByteSlice(d)
+		return func(e *Encoder) (int, error) {
+			total, err := EncodeLen(e, uint32(len(v)), e.maxElements)
+			if err != nil {
+				return 0, err
+			}
+			for _, item := range v {
+				n, err := EncodeByteSlice(e, item)
+				if err != nil {
+					return 0, err
+				}
+				total += n
+			}
+			return total, nil
+		}, n, err
+	}, "\x00", "\x08\x04a\x00")
+}
+
+func FuzzDecodeStringSlice(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeStringSlice(d)
+		return func(e *Encoder) (int, error) { return EncodeStringSlice(e, v) }, n, err
+	}, "\x00", "\x08\x04a\x08bc")
+}
+
+func FuzzDecodeOption(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		var post Post
+		exists, n, err := DecodeOption(d, &post)
+		return func(e *Encoder) (int, error) {
+			if !exists {
+				return EncodeOption(e, nil)
+			}
+			return EncodeOption(e, &post)
+		}, n, err
+	}, "\x00", "\x01\xfe\xff\x03\x00\x0c123\xfe\xff\xff\x03", "\x02")
+}
+
+func FuzzDecodeFixedInts(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		var (
+			total int
+			u16   uint16
+			u32   uint32
+			u64   uint64
+			i8    int8
+			i16   int16
+			i32   int32
+			i64   int64
+		)
+		for _, decode := range []func() (int, error){
+			func() (n int, err error) { u16, n, err = DecodeUint16(d); return },
+			func() (n int, err error) { u32, n, err = DecodeUint32(d); return },
+			func() (n int, err error) { u64, n, err = DecodeUint64(d); return },
+			func() (n int, err error) { i8, n, err = DecodeInt8(d); return },
+			func() (n int, err error) { i16, n, err = DecodeInt16(d); return },
+			func() (n int, err error) { i32, n, err = DecodeInt32(d); return },
+			func() (n int, err error) { i64, n, err = DecodeInt64(d); return },
+		} {
+			n, err := decode()
+			if err != nil {
+				return nil, 0, err
+			}
+			total += n
+		}
+		return func(e *Encoder) (int, error) {
+			total := 0
+			for _, encode := range []func() (int, error){
+				func() (int, error) { return EncodeUint16(e, u16) },
+				func() (int, error) { return EncodeUint32(e, u32) },
+				func() (int, error) { return EncodeUint64(e, u64) },
+				func() (int, error) { return EncodeInt8(e, i8) },
+				func() (int, error) { return EncodeInt16(e, i16) },
+				func() (int, error) { return EncodeInt32(e, i32) },
+				func() (int, error) { return EncodeInt64(e, i64) },
+			} {
+				n, err := encode()
+				if err != nil {
+					return 0, err
+				}
+				total += n
+			}
+			return total, nil
+		}, total, nil
+	}, string(make([]byte, 29)))
+}
+
+func FuzzDecodeU256(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		v, n, err := DecodeU256(d)
+		return func(e *Encoder) (int, error) { return EncodeU256(e, v) }, n, err
+	}, string(make([]byte, U256Size)))
+}
+
+func FuzzDecodeStructSlice(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		var posts []Post
+		n, err := DecodeStructSliceWithLimit(d, 16,
+			func(length int) { posts = make([]Post, length) },
+			func(i int) Decodable { return &posts[i] })
+		return func(e *Encoder) (int, error) {
+			return EncodeStructSliceWithLimit(e, len(posts), func(i int) Encodable { return &posts[i] }, 16)
+		}, n, err
+	}, "\x00", "\x04\x04\x041\x08")
+}
+
+func FuzzDecodeStructArray(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		var posts [2]Post
+		n, err := DecodeStructArray(d, len(posts), func(i int) Decodable { return &posts[i] })
+		return func(e *Encoder) (int, error) {
+			return EncodeStructArray(e, len(posts), func(i int) Encodable { return &posts[i] })
+		}, n, err
+	}, "\x04\x041\x08\x00\x00\x00")
+}
+
+func FuzzDecodeMap(f *testing.F) {
+	fuzzDecode(f, func(d *Decoder) (EncodeFunc, int, error) {
+		m, err := decodeStringMap(d)
+		return func(e *Encoder) (int, error) { return encodeStringMap(e, m) }, d.consumed, err
+	}, "\x00", "\x10\x04a\x04\x04b\x08\x04c\x0c\x08aa\x10", "\x08\x04b\x08\x04a\x04")
+}
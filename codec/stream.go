@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxFrameSize is the largest frame a FrameReader accepts by default.
+const DefaultMaxFrameSize = 1 << 20
+
+// ErrFrameTooLarge is returned for frames larger than the limit of the reader or writer.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// A frame is the SCALE encoding of one value, prefixed by its length as compact integer. Frames are
+// written back to back, so a stream of proofs is read one by one without knowing their number ahead,
+// and the same bytes serve as archive file and as network message.
+
+// FrameWriter writes values as frames.
+type FrameWriter struct {
+	w            io.Writer
+	buf          bytes.Buffer
+	maxFrameSize int
+}
+
+// NewFrameWriter returns a writer of frames of at most maxFrameSize bytes to w, DefaultMaxFrameSize if 0.
+func NewFrameWriter(w io.Writer, maxFrameSize int) *FrameWriter {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &FrameWriter{w: w, maxFrameSize: maxFrameSize}
+}
+
+// EncodeTo writes value as one frame and returns the number of bytes written, prefix included.
+func (fw *FrameWriter) EncodeTo(value Encodable) (int, error) {
+	fw.buf.Reset()
+	size, err := EncodeTo(&fw.buf, value)
+	if err != nil {
+		return 0, err
+	}
+	if size > fw.maxFrameSize {
+		return 0, fmt.Errorf("%w: %d bytes; expected: <= %d", ErrFrameTooLarge, size, fw.maxFrameSize)
+	}
+	total, err := EncodeCompact32(NewEncoder(fw.w), uint32(size))
+	if err != nil {
+		return total, err
+	}
+	n, err := fw.w.Write(fw.buf.Bytes())
+	return total + n, err
+}
+
+// FrameReader reads frames written by FrameWriter.
+type FrameReader struct {
+	r            io.Reader
+	buf          []byte
+	maxFrameSize int
+}
+
+// NewFrameReader returns a reader of frames of at most maxFrameSize bytes from r, DefaultMaxFrameSize if 0.
+func NewFrameReader(r io.Reader, maxFrameSize int) *FrameReader {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &FrameReader{r: r, maxFrameSize: maxFrameSize}
+}
+
+// DecodeFrom decodes the next frame into value and returns the number of bytes read, prefix included.
+// Frames are decoded strictly and must be consumed entirely. At the end of the stream DecodeFrom
+// returns io.EOF, a stream that ends within a frame gives io.ErrUnexpectedEOF.
+func (fr *FrameReader) DecodeFrom(value Decodable) (int, error) {
+	d := NewDecoder(fr.r, WithDecodeStrict())
+	size, total, err := DecodeCompact32(d)
+	if err != nil {
+		if d.consumed > 0 && errors.Is(err, io.EOF) {
+			return d.consumed, io.ErrUnexpectedEOF
+		}
+		return d.consumed, err
+	}
+	if uint64(size) > uint64(fr.maxFrameSize) {
+		return total, fmt.Errorf("%w: %d bytes; expected: <= %d", ErrFrameTooLarge, size, fr.maxFrameSize)
+	}
+	if cap(fr.buf) < int(size) {
+		fr.buf = make([]byte, size)
+	}
+	fr.buf = fr.buf[:size]
+	n, err := io.ReadFull(fr.r, fr.buf)
+	total += n
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return total, err
+	}
+	if err := DecodeStrict(fr.buf, value, int(size)); err != nil {
+		return total, err
+	}
+	return total, nil
+}
@@ -0,0 +1,90 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrames(t *testing.T) {
+	posts := []*Post{
+		{Nonce: 1, Indices: []byte("a"), Pow: 2},
+		{Nonce: 0xffff, Indices: bytes.Repeat([]byte{7}, 300), Pow: 1 << 40},
+		{},
+	}
+	var buf bytes.Buffer
+	fw := NewFrameWriter(&buf, 0)
+	written := 0
+	for _, post := range posts {
+		n, err := fw.EncodeTo(post)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written += n
+	}
+	if written != buf.Len() {
+		t.Fatalf("reported %d bytes, wrote %d", written, buf.Len())
+	}
+	stream := append([]byte(nil), buf.Bytes()...)
+
+	fr := NewFrameReader(&buf, 0)
+	read := 0
+	for i := 0; ; i++ {
+		var post Post
+		n, err := fr.DecodeFrom(&post)
+		read += n
+		if err == io.EOF {
+			if i != len(posts) {
+				t.Fatalf("read %d frames, expected %d", i, len(posts))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if post.Nonce != posts[i].Nonce || post.Pow != posts[i].Pow || !bytes.Equal(post.Indices, posts[i].Indices) {
+			t.Fatalf("frame %d: %+v", i, post)
+		}
+	}
+	if read != len(stream) {
+		t.Fatalf("reported %d bytes, read %d", read, len(stream))
+	}
+
+	for _, cut := range []int{1, 4, len(stream) - 1} {
+		fr := NewFrameReader(bytes.NewReader(stream[:cut]), 0)
+		var err error
+		for err == nil {
+			_, err = fr.DecodeFrom(&Post{})
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("stream cut at %d: %v", cut, err)
+		}
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	post := &Post{Indices: bytes.Repeat([]byte{1}, 100)}
+	var buf bytes.Buffer
+	if _, err := NewFrameWriter(&buf, 50).EncodeTo(post); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("large frame written: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("rejected frame partially written")
+	}
+	if _, err := NewFrameWriter(&buf, 0).EncodeTo(post); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFrameReader(bytes.NewReader(buf.Bytes()), 50).DecodeFrom(&Post{}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("large frame read: %v", err)
+	}
+
+	// 帧内多余的字节
+	var frame bytes.Buffer
+	if _, err := EncodeByteSlice(NewEncoder(&frame), append(buf.Bytes()[2:], 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFrameReader(&frame, 0).DecodeFrom(&Post{}); !errors.Is(err, ErrShortRead) {
+		t.Fatalf("frame with trailing bytes accepted: %v", err)
+	}
+}
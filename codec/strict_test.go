@@ -0,0 +1,109 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestStrictCompact(t *testing.T) {
+	for _, encoded := range []string{
+		"0300000040",   // 2^30, canonical
+		"070000004000", // 2^30 with a superfluous byte
+	} {
+		data, _ := hex.DecodeString(encoded)
+		value, _, err := DecodeCompact64(NewDecoder(bytes.NewReader(data)))
+		if err != nil || value != 1<<30 {
+			t.Fatalf("lenient decoding of %s: %d, %v", encoded, value, err)
+		}
+	}
+
+	data, _ := hex.DecodeString("070000004000")
+	if _, _, err := DecodeCompact64(NewDecoder(bytes.NewReader(data), WithDecodeStrict())); !errors.Is(err, ErrDecodeNonCanonical) {
+		t.Fatalf("superfluous byte accepted: %v", err)
+	}
+	data, _ = hex.DecodeString("0300000040")
+	if value, _, err := DecodeCompact32(NewDecoder(bytes.NewReader(data), WithDecodeStrict())); err != nil || value != 1<<30 {
+		t.Fatalf("canonical encoding rejected: %d, %v", value, err)
+	}
+}
+
+func TestStrictBool(t *testing.T) {
+	if value, _, err := DecodeBool(NewDecoder(bytes.NewReader([]byte{2}))); err != nil || value {
+		t.Fatalf("lenient decoding: %v, %v", value, err)
+	}
+	if _, _, err := DecodeBool(NewDecoder(bytes.NewReader([]byte{2}), WithDecodeStrict())); !errors.Is(err, ErrDecodeNonCanonical) {
+		t.Fatalf("bool 2 accepted: %v", err)
+	}
+	var post Post
+	if _, _, err := DecodeOption(NewDecoder(bytes.NewReader([]byte{2}), WithDecodeStrict()), &post); !errors.Is(err, ErrDecodeNonCanonical) {
+		t.Fatalf("option 2 accepted: %v", err)
+	}
+}
+
+func TestDecodeMaxBytes(t *testing.T) {
+	// 声明1000字节，实际只有3字节
+	data, _ := hex.DecodeString("a10f616263")
+	d := NewDecoder(bytes.NewReader(data), WithDecodeMaxBytes(len(data)))
+	if _, _, err := DecodeByteSlice(d); !errors.Is(err, ErrDecodeTooManyBytes) {
+		t.Fatalf("length beyond the budget accepted: %v", err)
+	}
+	data, _ = hex.DecodeString("a10f616263")
+	if _, _, err := DecodeStringSlice(NewDecoder(bytes.NewReader(data), WithDecodeMaxBytes(len(data)))); !errors.Is(err, ErrDecodeTooManyBytes) {
+		t.Fatalf("length beyond the budget accepted: %v", err)
+	}
+
+	post := &Post{Nonce: 1, Indices: []byte("12345678"), Pow: 2}
+	encoded, err := Encode(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Post
+	if err := DecodeStrict(encoded, &decoded, len(encoded)); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeStrict(encoded, &decoded, len(encoded)-1); !errors.Is(err, ErrDecodeTooManyBytes) {
+		t.Fatalf("input beyond the budget accepted: %v", err)
+	}
+	if _, err := post.DecodeScale(NewDecoder(bytes.NewReader(encoded), WithDecodeMaxBytes(len(encoded)-1))); !errors.Is(err, ErrDecodeTooManyBytes) {
+		t.Fatalf("input beyond the budget accepted: %v", err)
+	}
+}
+
+func TestEmptySlicesRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := EncodeStringSlice(NewEncoder(&buf), nil); err != nil {
+		t.Fatal(err)
+	}
+	value, n, err := DecodeStringSlice(NewDecoder(&buf))
+	if err != nil || value != nil || n != 1 {
+		t.Fatalf("decoded %v, %d bytes: %v", value, n, err)
+	}
+}
+
+func TestIsNil(t *testing.T) {
+	var post *Post
+	var encodable Encodable = post
+	for _, tc := range []struct {
+		value interface{}
+		nil   bool
+	}{
+		{nil, true},
+		{post, true},
+		{encodable, true},
+		{[]byte(nil), true},
+		{&Post{}, false},
+		{Post{}, false},
+		{1, false},
+	} {
+		if IsNil(tc.value) != tc.nil {
+			t.Errorf("IsNil(%#v) != %v", tc.value, tc.nil)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := EncodeOption(NewEncoder(&buf), nil); err != nil || !bytes.Equal(buf.Bytes(), []byte{0}) {
+		t.Fatalf("nil option encoded as %x: %v", buf.Bytes(), err)
+	}
+}
@@ -0,0 +1,307 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+)
+
+// U256Size is the size of an encoded U256.
+const U256Size = 32
+
+var (
+	// ErrU256Range is returned for a U256 that is negative or does not fit 256 bits.
+	ErrU256Range = errors.New("value out of u256 range")
+
+	// ErrMapKeyOrder is returned for maps with duplicate keys or keys not in increasing order of their encoding.
+	ErrMapKeyOrder = errors.New("map keys are not unique and sorted")
+)
+
+// EncodeFunc adapts a function to Encodable, e.g. to encode map keys of basic types.
+type EncodeFunc func(e *Encoder) (int, error)
+
+func (f EncodeFunc) EncodeScale(e *Encoder) (int, error) {
+	return f(e)
+}
+
+// DecodeFunc adapts a function to Decodable.
+type DecodeFunc func(d *Decoder) (int, error)
+
+func (f DecodeFunc) DecodeScale(d *Decoder) (int, error) {
+	return f(d)
+}
+
+func DecodeUint16(d *Decoder) (uint16, int, error) {
+	n, err := d.read(d.scratch[:2])
+	if err != nil {
+		return 0, n, err
+	}
+	return binary.LittleEndian.Uint16(d.scratch[:2]), n, nil
+}
+
+func DecodeUint32(d *Decoder) (uint32, int, error) {
+	n, err := d.read(d.scratch[:4])
+	if err != nil {
+		return 0, n, err
+	}
+	return binary.LittleEndian.Uint32(d.scratch[:4]), n, nil
+}
+
+func DecodeUint64(d *Decoder) (uint64, int, error) {
+	n, err := d.read(d.scratch[:8])
+	if err != nil {
+		return 0, n, err
+	}
+	return binary.LittleEndian.Uint64(d.scratch[:8]), n, nil
+}
+
+// Signed integers are encoded as two's complement, little endian like the unsigned ones.
+
+func EncodeInt8(e *Encoder, value int8) (int, error) {
+	return EncodeByte(e, byte(value))
+}
+
+func EncodeInt16(e *Encoder, value int16) (int, error) {
+	return EncodeUint16(e, uint16(value))
+}
+
+func EncodeInt32(e *Encoder, value int32) (int, error) {
+	return EncodeUint32(e, uint32(value))
+}
+
+func EncodeInt64(e *Encoder, value int64) (int, error) {
+	return EncodeUint64(e, uint64(value))
+}
+
+func DecodeInt8(d *Decoder) (int8, int, error) {
+	v, n, err := DecodeByte(d)
+	return int8(v), n, err
+}
+
+func DecodeInt16(d *Decoder) (int16, int, error) {
+	v, n, err := DecodeUint16(d)
+	return int16(v), n, err
+}
+
+func DecodeInt32(d *Decoder) (int32, int, error) {
+	v, n, err := DecodeUint32(d)
+	return int32(v), n, err
+}
+
+func DecodeInt64(d *Decoder) (int64, int, error) {
+	v, n, err := DecodeUint64(d)
+	return int64(v), n, err
+}
+
+// EncodeU256 encodes value as 32 bytes big endian, the way PoW difficulties and other 256 bit
+// thresholds are compared. A nil value is encoded as zero.
+func EncodeU256(e *Encoder, value *big.Int) (int, error) {
+	var buf [U256Size]byte
+	if value != nil {
+		if value.Sign() < 0 || value.BitLen() > 8*U256Size {
+			return 0, fmt.Errorf("%w: %s", ErrU256Range, value)
+		}
+		value.FillBytes(buf[:])
+	}
+	return EncodeByteArray(e, buf[:])
+}
+
+func DecodeU256(d *Decoder) (*big.Int, int, error) {
+	var buf [U256Size]byte
+	n, err := DecodeByteArray(d, buf[:])
+	if err != nil {
+		return nil, n, err
+	}
+	return new(big.Int).SetBytes(buf[:]), n, nil
+}
+
+// EncodeStructSlice encodes length elements returned by elem, prefixed by the length.
+func EncodeStructSlice(e *Encoder, length int, elem func(i int) Encodable) (int, error) {
+	return EncodeStructSliceWithLimit(e, length, elem, e.maxElements)
+}
+
+func EncodeStructSliceWithLimit(e *Encoder, length int, elem func(i int) Encodable, limit uint32) (int, error) {
+	if uint64(length) > uint64(limit) {
+		return 0, fmt.Errorf("%w: %d", ErrEncodeTooManyElements, limit)
+	}
+	total, err := EncodeLen(e, uint32(length), limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := EncodeStructArray(e, length, elem)
+	if err != nil {
+		return 0, err
+	}
+	return total + n, nil
+}
+
+// EncodeStructArray encodes length elements returned by elem, without length prefix.
+func EncodeStructArray(e *Encoder, length int, elem func(i int) Encodable) (int, error) {
+	if err := e.enterNested(); err != nil {
+		return 0, err
+	}
+	defer e.leaveNested()
+	total := 0
+	for i := 0; i < length; i++ {
+		n, err := elem(i).EncodeScale(e)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// DecodeStructSlice decodes a slice encoded by EncodeStructSlice. alloc is called with the length
+// before elem returns where to decode element i into.
+func DecodeStructSlice(d *Decoder, alloc func(length int), elem func(i int) Decodable) (int, error) {
+	return DecodeStructSliceWithLimit(d, d.maxElements, alloc, elem)
+}
+
+func DecodeStructSliceWithLimit(d *Decoder, limit uint32, alloc func(length int), elem func(i int) Decodable) (int, error) {
+	length, total, err := DecodeLen(d, limit)
+	if err != nil {
+		return 0, err
+	}
+	if length == 0 {
+		return total, nil
+	}
+	// 每个元素至少占一个字节，超出预算的长度不分配
+	if err := d.reserve(int(length)); err != nil {
+		return 0, err
+	}
+	alloc(int(length))
+	n, err := DecodeStructArray(d, int(length), elem)
+	if err != nil {
+		return 0, err
+	}
+	return total + n, nil
+}
+
+// DecodeStructArray decodes length elements encoded by EncodeStructArray.
+func DecodeStructArray(d *Decoder, length int, elem func(i int) Decodable) (int, error) {
+	if err := d.enterNested(); err != nil {
+		return 0, err
+	}
+	defer d.leaveNested()
+	total := 0
+	for i := 0; i < length; i++ {
+		n, err := elem(i).DecodeScale(d)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// EncodeMap encodes length entries returned by entry, prefixed by the length. Entries are written in
+// increasing order of their encoded keys, so equal maps always encode the same.
+func EncodeMap(e *Encoder, length int, entry func(i int) (key, value Encodable)) (int, error) {
+	return EncodeMapWithLimit(e, length, entry, e.maxElements)
+}
+
+func EncodeMapWithLimit(e *Encoder, length int, entry func(i int) (key, value Encodable), limit uint32) (int, error) {
+	if uint64(length) > uint64(limit) {
+		return 0, fmt.Errorf("%w: %d", ErrEncodeTooManyElements, limit)
+	}
+	if err := e.enterNested(); err != nil {
+		return 0, err
+	}
+	defer e.leaveNested()
+
+	type encodedEntry struct {
+		key   []byte
+		value Encodable
+	}
+	entries := make([]encodedEntry, length)
+	for i := range entries {
+		key, value := entry(i)
+		var buf bytes.Buffer
+		if _, err := key.EncodeScale(&Encoder{w: &buf, maxNested: e.maxNested, maxElements: e.maxElements}); err != nil {
+			return 0, err
+		}
+		entries[i] = encodedEntry{key: buf.Bytes(), value: value}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	total, err := EncodeLen(e, uint32(length), limit)
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range entries {
+		if i > 0 && bytes.Equal(entries[i-1].key, entry.key) {
+			return 0, ErrMapKeyOrder
+		}
+		n, err := EncodeByteArray(e, entry.key)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		n, err = entry.value.EncodeScale(e)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// DecodeMap decodes a map encoded by EncodeMap. For every entry, entry returns where to decode the
+// key and the value into and insert, which is called once both are decoded. Keys out of order or
+// repeated are rejected with ErrMapKeyOrder.
+func DecodeMap(d *Decoder, entry func() (key, value Decodable, insert func())) (int, error) {
+	return DecodeMapWithLimit(d, d.maxElements, entry)
+}
+
+func DecodeMapWithLimit(d *Decoder, limit uint32, entry func() (key, value Decodable, insert func())) (int, error) {
+	length, total, err := DecodeLen(d, limit)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.enterNested(); err != nil {
+		return 0, err
+	}
+	defer d.leaveNested()
+	var previous []byte
+	for i := uint32(0); i < length; i++ {
+		key, value, insert := entry()
+		r := d.r
+		recorder := &recordingReader{r: r}
+		d.r = recorder
+		n, err := key.DecodeScale(d)
+		d.r = r
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		if i > 0 && bytes.Compare(previous, recorder.buf) >= 0 {
+			return 0, ErrMapKeyOrder
+		}
+		previous = recorder.buf
+		n, err = value.DecodeScale(d)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		insert()
+	}
+	return total, nil
+}
+
+// recordingReader keeps what is read through it.
+type recordingReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
@@ -0,0 +1,230 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestFixedInts(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	for _, encode := range []func() (int, error){
+		func() (int, error) { return EncodeUint16(e, 0x0102) },
+		func() (int, error) { return EncodeUint32(e, 0x01020304) },
+		func() (int, error) { return EncodeUint64(e, math.MaxUint64) },
+		func() (int, error) { return EncodeInt8(e, -1) },
+		func() (int, error) { return EncodeInt16(e, math.MinInt16) },
+		func() (int, error) { return EncodeInt32(e, -2) },
+		func() (int, error) { return EncodeInt64(e, math.MinInt64) },
+	} {
+		if _, err := encode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "0201" + "04030201" + "ffffffffffffffff" + "ff" + "0080" + "feffffff" + "0000000000000080"
+	if got := hex.EncodeToString(buf.Bytes()); got != expected {
+		t.Fatalf("encoded %s, expected %s", got, expected)
+	}
+
+	d := NewDecoder(&buf)
+	u16, _, err := DecodeUint16(d)
+	if err != nil || u16 != 0x0102 {
+		t.Fatalf("uint16 %x: %v", u16, err)
+	}
+	u32, _, err := DecodeUint32(d)
+	if err != nil || u32 != 0x01020304 {
+		t.Fatalf("uint32 %x: %v", u32, err)
+	}
+	u64, _, err := DecodeUint64(d)
+	if err != nil || u64 != math.MaxUint64 {
+		t.Fatalf("uint64 %x: %v", u64, err)
+	}
+	i8, _, err := DecodeInt8(d)
+	if err != nil || i8 != -1 {
+		t.Fatalf("int8 %d: %v", i8, err)
+	}
+	i16, _, err := DecodeInt16(d)
+	if err != nil || i16 != math.MinInt16 {
+		t.Fatalf("int16 %d: %v", i16, err)
+	}
+	i32, _, err := DecodeInt32(d)
+	if err != nil || i32 != -2 {
+		t.Fatalf("int32 %d: %v", i32, err)
+	}
+	i64, _, err := DecodeInt64(d)
+	if err != nil || i64 != math.MinInt64 {
+		t.Fatalf("int64 %d: %v", i64, err)
+	}
+	if _, _, err := DecodeUint32(d); err == nil {
+		t.Fatal("decoded past the end")
+	}
+}
+
+func TestU256(t *testing.T) {
+	const encoded = "0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f20"
+	value, _ := new(big.Int).SetString(encoded, 16)
+	var buf bytes.Buffer
+	n, err := EncodeU256(NewEncoder(&buf), value)
+	if err != nil || n != U256Size {
+		t.Fatalf("encoded %d bytes: %v", n, err)
+	}
+	if got := hex.EncodeToString(buf.Bytes()); got != encoded {
+		t.Fatalf("not big endian: %s", got)
+	}
+	decoded, _, err := DecodeU256(NewDecoder(&buf))
+	if err != nil || decoded.Cmp(value) != 0 {
+		t.Fatalf("decoded %s: %v", decoded, err)
+	}
+
+	tooLarge := new(big.Int).Lsh(big.NewInt(1), 256)
+	if _, err := EncodeU256(NewEncoder(&buf), tooLarge); !errors.Is(err, ErrU256Range) {
+		t.Fatalf("2^256 encoded: %v", err)
+	}
+	if _, err := EncodeU256(NewEncoder(&buf), big.NewInt(-1)); !errors.Is(err, ErrU256Range) {
+		t.Fatalf("negative encoded: %v", err)
+	}
+}
+
+func TestStructSlice(t *testing.T) {
+	posts := []Post{{Nonce: 1, Indices: []byte("a"), Pow: 2}, {Nonce: 3, Indices: []byte("bc"), Pow: 4}}
+	var buf bytes.Buffer
+	n, err := EncodeStructSlice(NewEncoder(&buf), len(posts), func(i int) Encodable { return &posts[i] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != buf.Len() {
+		t.Fatalf("reported %d bytes, wrote %d", n, buf.Len())
+	}
+	encoded := append([]byte(nil), buf.Bytes()...)
+
+	var decoded []Post
+	n, err = DecodeStructSlice(NewDecoder(&buf),
+		func(length int) { decoded = make([]Post, length) },
+		func(i int) Decodable { return &decoded[i] })
+	if err != nil || n != len(encoded) {
+		t.Fatalf("decoded %d bytes: %v", n, err)
+	}
+	if len(decoded) != 2 || decoded[1].Nonce != 3 || !bytes.Equal(decoded[1].Indices, []byte("bc")) {
+		t.Fatalf("decoded %+v", decoded)
+	}
+
+	if _, err := EncodeStructSliceWithLimit(NewEncoder(&buf), len(posts), func(i int) Encodable { return &posts[i] }, 1); !errors.Is(err, ErrEncodeTooManyElements) {
+		t.Fatalf("limit not enforced: %v", err)
+	}
+	_, err = DecodeStructSliceWithLimit(NewDecoder(bytes.NewReader(encoded)), 1,
+		func(length int) { decoded = make([]Post, length) },
+		func(i int) Decodable { return &decoded[i] })
+	if !errors.Is(err, ErrDecodeTooManyElements) {
+		t.Fatalf("limit not enforced: %v", err)
+	}
+	// 声明100万个元素却只有几个字节，不能先分配
+	header := []byte{0x02, 0x09, 0x3d, 0x00, 0x04}
+	allocated := false
+	_, err = DecodeStructSliceWithLimit(NewDecoder(bytes.NewReader(header), WithDecodeMaxBytes(len(header))), 1<<20,
+		func(length int) { allocated = true },
+		func(i int) Decodable { return &Post{} })
+	if !errors.Is(err, ErrDecodeTooManyBytes) || allocated {
+		t.Fatalf("length beyond the budget allocated: %v", err)
+	}
+	_, err = EncodeStructSlice(NewEncoder(&buf, WithEncodeMaxNested(0)), len(posts), func(i int) Encodable { return &posts[i] })
+	if !errors.Is(err, ErrEncodeNestedTooDeep) {
+		t.Fatalf("nesting not enforced: %v", err)
+	}
+}
+
+func TestStructArray(t *testing.T) {
+	var posts [3]Post
+	for i := range posts {
+		posts[i] = Post{Nonce: uint32(i), Indices: []byte{byte(i)}}
+	}
+	var buf bytes.Buffer
+	if _, err := EncodeStructArray(NewEncoder(&buf), len(posts), func(i int) Encodable { return &posts[i] }); err != nil {
+		t.Fatal(err)
+	}
+	var decoded [3]Post
+	if _, err := DecodeStructArray(NewDecoder(&buf), len(decoded), func(i int) Decodable { return &decoded[i] }); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[2].Nonce != 2 || !bytes.Equal(decoded[2].Indices, []byte{2}) {
+		t.Fatalf("decoded %+v", decoded)
+	}
+}
+
+func encodeStringMap(e *Encoder, m map[string]uint64) (int, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return EncodeMap(e, len(keys), func(i int) (Encodable, Encodable) {
+		key, value := keys[i], m[keys[i]]
+		return EncodeFunc(func(e *Encoder) (int, error) { return EncodeString(e, key) }),
+			EncodeFunc(func(e *Encoder) (int, error) { return EncodeCompact64(e, value) })
+	})
+}
+
+func decodeStringMap(d *Decoder) (map[string]uint64, error) {
+	m := make(map[string]uint64)
+	_, err := DecodeMap(d, func() (Decodable, Decodable, func()) {
+		var key string
+		var value uint64
+		return DecodeFunc(func(d *Decoder) (n int, err error) {
+				key, n, err = DecodeString(d)
+				return n, err
+			}),
+			DecodeFunc(func(d *Decoder) (n int, err error) {
+				value, n, err = DecodeCompact64(d)
+				return n, err
+			}),
+			func() { m[key] = value }
+	})
+	return m, err
+}
+
+func TestMap(t *testing.T) {
+	m := map[string]uint64{"b": 2, "a": 1, "c": 3, "aa": 4}
+	var first []byte
+	for i := 0; i < 10; i++ {
+		var buf bytes.Buffer
+		if _, err := encodeStringMap(NewEncoder(&buf), m); err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = buf.Bytes()
+		} else if !bytes.Equal(first, buf.Bytes()) {
+			t.Fatal("map encoding is not deterministic")
+		}
+	}
+	// keys in order of their encoding: the length prefix puts "aa" last
+	if expected := "10" + "046104" + "046208" + "04630c" + "08616110"; hex.EncodeToString(first) != expected {
+		t.Fatalf("encoded %x, expected %s", first, expected)
+	}
+
+	decoded, err := decodeStringMap(NewDecoder(bytes.NewReader(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(m) {
+		t.Fatalf("decoded %v", decoded)
+	}
+	for key, value := range m {
+		if decoded[key] != value {
+			t.Fatalf("decoded %v", decoded)
+		}
+	}
+
+	unsorted, _ := hex.DecodeString("08" + "046208" + "046104")
+	if _, err := decodeStringMap(NewDecoder(bytes.NewReader(unsorted))); !errors.Is(err, ErrMapKeyOrder) {
+		t.Fatalf("unsorted keys accepted: %v", err)
+	}
+	duplicate, _ := hex.DecodeString("08" + "046104" + "046108")
+	if _, err := decodeStringMap(NewDecoder(bytes.NewReader(duplicate))); !errors.Is(err, ErrMapKeyOrder) {
+		t.Fatalf("duplicate keys accepted: %v", err)
+	}
+	if _, err := decodeStringMap(NewDecoder(bytes.NewReader(first), WithDecodeMaxElements(2))); !errors.Is(err, ErrDecodeTooManyElements) {
+		t.Fatalf("limit not enforced: %v", err)
+	}
+}
@@ -3,16 +3,17 @@ module github.com/trying2016/post-go
 go 1.17
 
 require (
+	github.com/klauspost/cpuid/v2 v2.0.12
+	github.com/ncw/directio v1.0.5
 	github.com/trying2016/common-tools v0.2.0
 	github.com/ying32/dylib v0.0.0-20220227124818-fdf9ea9fbc96
 	github.com/zeebo/blake3 v0.2.3
+	golang.org/x/sys v0.0.0-20191120155948-bd437916bb0e
 )
 
 require (
-	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/lestrrat/go-file-rotatelogs v0.0.0-20180223000712-d3151e2a480f // indirect
 	github.com/lestrrat/go-strftime v0.0.0-20180220042222-ba3bf9c1d042 // indirect
-	github.com/ncw/directio v1.0.5 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rifflock/lfshook v0.0.0-20180920164130-b9218ef580f5 // indirect
 	github.com/sirupsen/logrus v1.7.0 // indirect
@@ -20,6 +21,5 @@ require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	github.com/xxtea/xxtea-go v0.0.0-20170828040851-35c4b17eecf6 // indirect
-	golang.org/x/sys v0.0.0-20191120155948-bd437916bb0e // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
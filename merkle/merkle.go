@@ -0,0 +1,233 @@
+// Package merkle builds blake3 membership trees, like the ones NIPost uses for PoET challenges, and
+// proves and verifies membership of their leaves. Internal nodes are hashed with
+// shared.HashMembershipTreeNode; a layer with an odd number of nodes pairs its last node with
+// PaddingNode.
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/trying2016/post-go/codec"
+	"github.com/trying2016/post-go/shared"
+	"github.com/zeebo/blake3"
+)
+
+const (
+	// NodeSize is the size of leaves and nodes.
+	NodeSize = 32
+	// MaxHeight is the height of the largest tree a Proof can describe.
+	MaxHeight = 64
+)
+
+var (
+	// PaddingNode is the sibling of the last node of a layer with an odd number of nodes.
+	PaddingNode = make([]byte, NodeSize)
+
+	// ErrInvalidProof is returned for a proof that does not lead from the leaf to the root.
+	ErrInvalidProof = errors.New("invalid membership proof")
+)
+
+// HashLeaf turns arbitrary data into a leaf. Data that already is a 32 byte hash, like a challenge,
+// is used as leaf directly.
+func HashLeaf(data []byte) []byte {
+	hasher := blake3.New()
+	_, _ = hasher.Write([]byte{0x00})
+	_, _ = hasher.Write(data)
+	return hasher.Sum(nil)
+}
+
+// Tree is a membership tree with all its layers, layers[0] are the leaves and the last layer is the root.
+type Tree struct {
+	layers [][][]byte
+}
+
+// NewTree builds the tree over leaves, which must be NodeSize bytes each.
+func NewTree(leaves [][]byte) (*Tree, error) {
+	if len(leaves) == 0 {
+		return nil, errors.New("no leaves")
+	}
+	layer := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		if len(leaf) != NodeSize {
+			return nil, fmt.Errorf("invalid leaf %d length; expected: %d, given: %d", i, NodeSize, len(leaf))
+		}
+		layer[i] = append([]byte(nil), leaf...)
+	}
+	t := &Tree{layers: [][][]byte{layer}}
+	for len(layer) > 1 {
+		parents := make([][]byte, 0, (len(layer)+1)/2)
+		for i := 0; i < len(layer); i += 2 {
+			parents = append(parents, shared.HashMembershipTreeNode(nil, layer[i], sibling(layer, i+1)))
+		}
+		layer = parents
+		t.layers = append(t.layers, layer)
+	}
+	return t, nil
+}
+
+// sibling returns node i of layer, PaddingNode past its end.
+func sibling(layer [][]byte, i int) []byte {
+	if i < len(layer) {
+		return layer[i]
+	}
+	return PaddingNode
+}
+
+// Root returns the root of the tree. The root of a single leaf is the leaf.
+func (t *Tree) Root() []byte {
+	return append([]byte(nil), t.layers[len(t.layers)-1][0]...)
+}
+
+// Len returns the number of leaves.
+func (t *Tree) Len() int {
+	return len(t.layers[0])
+}
+
+// Height returns the number of layers above the leaves, the number of nodes of a Proof.
+func (t *Tree) Height() int {
+	return len(t.layers) - 1
+}
+
+// Leaf returns leaf index.
+func (t *Tree) Leaf(index uint64) ([]byte, error) {
+	if index >= uint64(t.Len()) {
+		return nil, fmt.Errorf("leaf %d out of range; expected: < %d", index, t.Len())
+	}
+	return append([]byte(nil), t.layers[0][index]...), nil
+}
+
+// Find returns the index of the first leaf equal to leaf.
+func (t *Tree) Find(leaf []byte) (uint64, bool) {
+	for i, l := range t.layers[0] {
+		if bytes.Equal(l, leaf) {
+			return uint64(i), true
+		}
+	}
+	return 0, false
+}
+
+// Prove returns the membership proof of leaf index.
+func (t *Tree) Prove(index uint64) (*Proof, error) {
+	if index >= uint64(t.Len()) {
+		return nil, fmt.Errorf("leaf %d out of range; expected: < %d", index, t.Len())
+	}
+	proof := &Proof{LeafIndex: index, Nodes: make([][]byte, 0, t.Height())}
+	i := index
+	for _, layer := range t.layers[:t.Height()] {
+		proof.Nodes = append(proof.Nodes, append([]byte(nil), sibling(layer, int(i^1))...))
+		i /= 2
+	}
+	return proof, nil
+}
+
+// Proof proves that a leaf is in a tree: Nodes are the siblings on the path from leaf LeafIndex to the
+// root, bottom up.
+type Proof struct {
+	LeafIndex uint64
+	Nodes     [][]byte
+}
+
+// Root returns the root the proof leads to from leaf.
+func (p *Proof) Root(leaf []byte) ([]byte, error) {
+	if len(leaf) != NodeSize {
+		return nil, fmt.Errorf("invalid leaf length; expected: %d, given: %d", NodeSize, len(leaf))
+	}
+	if len(p.Nodes) > MaxHeight || (len(p.Nodes) < MaxHeight && p.LeafIndex>>uint(len(p.Nodes)) != 0) {
+		return nil, fmt.Errorf("%w: leaf %d does not fit a tree of height %d", ErrInvalidProof, p.LeafIndex, len(p.Nodes))
+	}
+	node := append([]byte(nil), leaf...)
+	index := p.LeafIndex
+	for i, sibling := range p.Nodes {
+		if len(sibling) != NodeSize {
+			return nil, fmt.Errorf("invalid node %d length; expected: %d, given: %d", i, NodeSize, len(sibling))
+		}
+		if index&1 == 0 {
+			node = shared.HashMembershipTreeNode(node[:0], node, sibling)
+		} else {
+			node = shared.HashMembershipTreeNode(node[:0], sibling, node)
+		}
+		index >>= 1
+	}
+	return node, nil
+}
+
+// Verify checks that the proof leads from leaf to root.
+func (p *Proof) Verify(root, leaf []byte) error {
+	got, err := p.Root(leaf)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// EncodeScale implements scale codec interface.
+func (p *Proof) EncodeScale(enc *codec.Encoder) (total int, err error) {
+	{
+		n, err := codec.EncodeCompact64(enc, p.LeafIndex)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	{
+		n, err := codec.EncodeLen(enc, uint32(len(p.Nodes)), MaxHeight)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	for i, node := range p.Nodes {
+		if len(node) != NodeSize {
+			return total, fmt.Errorf("invalid node %d length; expected: %d, given: %d", i, NodeSize, len(node))
+		}
+		n, err := codec.EncodeByteArray(enc, node)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// DecodeScale implements scale codec interface.
+func (p *Proof) DecodeScale(dec *codec.Decoder) (total int, err error) {
+	{
+		field, n, err := codec.DecodeCompact64(dec)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		p.LeafIndex = field
+	}
+	count, n, err := codec.DecodeLen(dec, MaxHeight)
+	if err != nil {
+		return total, err
+	}
+	total += n
+	p.Nodes = make([][]byte, count)
+	for i := range p.Nodes {
+		p.Nodes[i] = make([]byte, NodeSize)
+		n, err := codec.DecodeByteArray(dec, p.Nodes[i])
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// EncodeProof returns the SCALE encoding of p.
+func EncodeProof(p *Proof) ([]byte, error) {
+	return codec.Encode(p)
+}
+
+// DecodeProof decodes a proof encoded by EncodeProof.
+func DecodeProof(data []byte) (*Proof, error) {
+	var p Proof
+	err := codec.Decode(data, &p)
+	return &p, err
+}
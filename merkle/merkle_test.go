@@ -0,0 +1,79 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"github.com/trying2016/post-go/shared"
+	"testing"
+)
+
+func leaves(count int) [][]byte {
+	list := make([][]byte, count)
+	for i := range list {
+		list[i] = HashLeaf([]byte{byte(i)})
+	}
+	return list
+}
+
+func TestTreeRoot(t *testing.T) {
+	l := leaves(3)
+	tree, err := NewTree(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := shared.HashMembershipTreeNode(nil, l[0], l[1])
+	right := shared.HashMembershipTreeNode(nil, l[2], PaddingNode)
+	if root := shared.HashMembershipTreeNode(nil, left, right); !bytes.Equal(tree.Root(), root) {
+		t.Fatalf("root %x, expected %x", tree.Root(), root)
+	}
+
+	single, err := NewTree(l[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(single.Root(), l[0]) || single.Height() != 0 {
+		t.Fatal("root of a single leaf is not the leaf")
+	}
+}
+
+func TestProofs(t *testing.T) {
+	for _, count := range []int{1, 2, 5, 8, 13} {
+		l := leaves(count)
+		tree, err := NewTree(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range l {
+			proof, err := tree.Prove(uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := EncodeProof(proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeProof(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := decoded.Verify(tree.Root(), l[i]); err != nil {
+				t.Fatalf("leaf %d of %d: %v", i, count, err)
+			}
+			if count > 1 {
+				if err := decoded.Verify(tree.Root(), l[(i+1)%count]); !errors.Is(err, ErrInvalidProof) {
+					t.Fatalf("proof of leaf %d verifies another leaf: %v", i, err)
+				}
+			}
+		}
+	}
+
+	tree, _ := NewTree(leaves(5))
+	proof, _ := tree.Prove(4)
+	proof.LeafIndex = 1 << 10
+	if err := proof.Verify(tree.Root(), leaves(5)[4]); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("index beyond the tree accepted: %v", err)
+	}
+	if _, err := tree.Prove(5); err == nil {
+		t.Fatal("proof of a missing leaf")
+	}
+}
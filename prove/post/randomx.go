@@ -5,6 +5,7 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // 常量定义
@@ -51,6 +52,8 @@ type RandomX struct {
 	thread       int32
 	affinity     int32
 	affinityStep int32
+	// 计算pow时的线程上限，0表示不限制，可在运行中调整
+	threadLimit int32
 	// 代理Prove回调
 	proveCallback ProveCallback
 }
@@ -124,10 +127,24 @@ func (r *RandomX) Pow(input []byte, difficulty []byte) uint64 {
 	if r.proveCallback != nil {
 		return r.proveCallback(input, difficulty)
 	}
-	pow := CallRandomXProve(uint(r.flags), r.cache, r.dataset, input, difficulty, r.thread, r.affinity, r.affinityStep)
+	thread := r.thread
+	if limit := atomic.LoadInt32(&r.threadLimit); limit > 0 && (thread <= 0 || limit < thread) {
+		thread = limit
+	}
+	pow := CallRandomXProve(uint(r.flags), r.cache, r.dataset, input, difficulty, thread, r.affinity, r.affinityStep)
 	return uint64(pow)
 }
 
+// SetThreadLimit 限制pow使用的线程数，0为不限制，对下一次Pow生效
+func (r *RandomX) SetThreadLimit(limit int32) {
+	atomic.StoreInt32(&r.threadLimit, limit)
+}
+
+// Ready 是否已分配cache和dataset
+func (r *RandomX) Ready() bool {
+	return r.cache != nil && r.dataset != nil
+}
+
 // GetFlags 获取flags
 func (r *RandomX) GetFlags() int32 {
 	return r.flags
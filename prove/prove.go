@@ -10,10 +10,12 @@ package prove
 
 import (
 	"errors"
+	"fmt"
 	"github.com/trying2016/post-go/prove/post"
 	post_go "github.com/trying2016/post-go/prove/prove_go"
 	"github.com/trying2016/post-go/shared"
 	"sync"
+	"time"
 )
 
 const (
@@ -58,22 +60,155 @@ func NewProve(proofType ProofType, thread, nonces int32) (*Prove, error) {
 			proofType: proofType,
 			thread:    thread,
 			nonces:    nonces,
+			reader:    defaultReaderOptions(),
 		}, nil
 	default:
 		return nil, errors.New("unknown proof type")
 	}
 }
 
+func defaultReaderOptions() post_go.ReaderOptions {
+	opts := post_go.DefaultReaderOptions()
+	opts.Buffers = post_go.DefaultProofBuffers
+	return opts
+}
+
 type Prove struct {
 	proofType ProofType
 	thread    int32
 	nonces    int32
+	reader    post_go.ReaderOptions
+	throttle  *post_go.Throttle
+	lookahead bool
+	deadline  time.Time
+	// 保存扫盘进度的间隔，0为不保存
+	checkpoint time.Duration
+	config     shared.Config
+	initOpts   shared.InitOpts
+	// 数据应属于的节点，为空时不比较
+	nodeId []byte
+	// 为true时生成proof前不做预检
+	skipPreflight bool
+}
+
+// SetReadParallelism 设置同时读取的文件数以及每个磁盘上同时读取的文件数，仅Go版本有效
+func (p *Prove) SetReadParallelism(files, perDevice int32) {
+	p.reader.Parallel = int(files)
+	p.reader.PerDevice = int(perDevice)
+}
+
+// SetBufferCount 设置读盘缓冲区个数，内存占用为 count * 1MiB，仅Go版本有效
+func (p *Prove) SetBufferCount(count int32) {
+	p.reader.Buffers = int(count)
+}
+
+// SetIOEngine 设置读盘引擎: buffered、direct、mmap、uring，auto为自动探测最快的引擎，仅Go版本有效
+func (p *Prove) SetIOEngine(engine string) {
+	p.reader.Engine = engine
+}
+
+// SetLookahead 读盘成为瓶颈时顺带扫描下一个nonce区间，仅Go版本有效
+func (p *Prove) SetLookahead(enabled bool) {
+	p.lookahead = enabled
+}
+
+// SetCheckpoint 每隔seconds秒把扫盘进度保存到数据目录，中断后用相同的challenge重新生成proof时从保存的进度继续；
+// 0为关闭(默认)。仅Go版本有效
+func (p *Prove) SetCheckpoint(seconds int32) {
+	if seconds <= 0 {
+		p.checkpoint = 0
+		return
+	}
+	p.checkpoint = time.Duration(seconds) * time.Second
+}
+
+// SetDeadline 设置生成proof的截止时间(unix秒)，设置后根据测得的读盘和AES速度自动选择每一轮的nonce数和线程数，
+// 使截止前完成的概率最大，线程数不超过NewProve的thread；0为取消。仅Go版本有效
+func (p *Prove) SetDeadline(unix int64) {
+	if unix <= 0 {
+		p.deadline = time.Time{}
+		return
+	}
+	p.deadline = time.Unix(unix, 0)
+}
+
+// SetThrottle 设置限速模式: eco、balanced、max，限制读盘速率、prove线程数以及RandomX线程数。
+// 可在生成proof的过程中调用，立即生效。Rust版本只限制RandomX线程数。
+// 注意RandomX是进程内共享的，线程数限制作用于进程内所有Prove，以最后一次调用为准
+func (p *Prove) SetThrottle(name string) error {
+	profile, err := post_go.ThrottleProfileByName(name)
+	if err != nil {
+		return err
+	}
+	if p.throttle == nil {
+		p.throttle = post_go.NewThrottle(profile)
+	} else {
+		p.throttle.SetProfile(profile)
+	}
+	post.GetRandomX().SetThreadLimit(int32(profile.RandomXThreads))
+	return nil
+}
+
+// SetExpectedConfig 设置数据目录应有的配置，生成proof前与metadata及数据文件比较，不一致时返回*shared.CompatibilityError。
+// 零值的字段不比较
+func (p *Prove) SetExpectedConfig(cfg shared.Config, opts shared.InitOpts) {
+	p.config = cfg
+	p.initOpts = opts
+}
+
+// SetExpectedNodeId 设置数据目录应属于的节点，生成proof前与metadata比较，不一致时返回*shared.CompatibilityError。
+// nil为不比较。与GenerateProof的creatorId(计算PoW用的id)无关
+func (p *Prove) SetExpectedNodeId(nodeId []byte) error {
+	if nodeId != nil && len(nodeId) != 32 {
+		return fmt.Errorf("invalid NodeId length; expected: 32, given: %d", len(nodeId))
+	}
+	p.nodeId = nodeId
+	return nil
+}
+
+// SetPreflight 设置生成proof前是否预检，默认开启
+func (p *Prove) SetPreflight(enabled bool) {
+	p.skipPreflight = !enabled
 }
 
-// GenerateProof 生成proof
+// Preflight 预检数据目录：challenge和难度、metadata、数据文件是否完整、key.bin是否为NodeId的私钥（加密的key.json只比较NodeId）、
+// RandomX和读盘缓冲区的内存是否足够以及每个数据文件是否可读。report.Err()不为nil时生成proof注定失败
+func (p *Prove) Preflight(dataDir string, challenge []byte, powDifficulty []byte) *post_go.PreflightReport {
+	opts := post_go.PreflightOptions{DataDirOnly: p.proofType == ProofType_Rust}
+	if p.proofType == PowType_Go {
+		opts.Reader = p.reader
+	}
+	if randomX := post.GetRandomX(); !randomX.Ready() {
+		opts.RandomXMemory = post_go.RandomXLightMemory
+		if randomX.GetFlags()&post.RANDOMX_FLAG_FULL_MEM != 0 {
+			opts.RandomXMemory = post_go.RandomXFastMemory
+		}
+	}
+	return post_go.Preflight(dataDir, challenge, powDifficulty, opts)
+}
+
+// GenerateProof 生成proof，creatorId为计算PoW用的id。数据所属的节点由SetExpectedNodeId设置
 func (p *Prove) GenerateProof(dataDir string, challenge []byte, powDifficulty []byte, creatorId []byte, affinityStart, affinityStep int32) (*shared.Proof, error) {
+	if p.proofType == ProofType_Rust {
+		// Rust版本只读取数据目录，不支持manifest
+		if err := shared.CheckNoManifest(dataDir); err != nil {
+			return nil, err
+		}
+	}
+	if !p.skipPreflight {
+		if err := p.Preflight(dataDir, challenge, powDifficulty).Err(); err != nil {
+			return nil, err
+		}
+	}
+	var checks []shared.CompatibilityOptionFunc
+	if p.nodeId != nil {
+		checks = append(checks, shared.WithNodeId(p.nodeId))
+	}
 	switch p.proofType {
 	case ProofType_Rust:
+		if err := shared.CheckCompatibility(dataDir, p.config, p.initOpts, checks...); err != nil {
+			return nil, err
+		}
 		return post.GenerateProof(dataDir,
 			challenge,
 			uint(p.nonces),
@@ -86,18 +221,67 @@ func (p *Prove) GenerateProof(dataDir string, challenge []byte, powDifficulty []
 			affinityStart,
 			affinityStep)
 	case PowType_Go:
+		opts := []post_go.ProofOptionFunc{
+			post_go.WithReaderOptions(p.reader),
+			post_go.WithThrottle(p.throttle),
+			post_go.WithLookahead(p.lookahead),
+			post_go.WithCheckpoint(p.checkpoint),
+			post_go.WithExpectedConfig(p.config, p.initOpts, checks...),
+		}
+		if !p.deadline.IsZero() {
+			opts = append(opts, post_go.WithDeadline(p.deadline, nil))
+		}
 		return post_go.GenerateProof(dataDir,
 			challenge,
 			uint32(p.nonces),
 			shared.K1,
 			shared.K2,
 			powDifficulty,
-			p.thread)
+			p.thread,
+			opts...)
 	default:
 		return nil, errors.New("unknown proof type")
 	}
 }
 
+// Calibrate 诊断模式：按fraction抽样扫描数据(1为全部扫描)，统计每个nonce满足难度的label数并与期望值比较，
+// 偏差过大说明数据损坏、不是scrypt数据或难度计算有误。output不为空时把结果以JSON写入该文件。仅Go版本有效
+func (p *Prove) Calibrate(dataDir string, challenge []byte, powDifficulty []byte, fraction float64, output string) (*post_go.Calibration, error) {
+	if p.proofType != PowType_Go {
+		return nil, errors.New("calibration is only supported by the go prover")
+	}
+	reader := p.reader
+	reader.Throttle = p.throttle
+	c, err := post_go.Calibrate(dataDir,
+		challenge,
+		uint32(p.nonces),
+		shared.K1,
+		powDifficulty,
+		p.thread,
+		post_go.WithCalibrationReader(reader),
+		post_go.WithSampleFraction(fraction, uint64(time.Now().UnixNano())))
+	if err != nil {
+		return nil, err
+	}
+	if output != "" {
+		if err := c.Save(output); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// CheckHealth 读取数据目录中的全部数据，返回文件布局问题、读失败的label范围以及读盘慢的文件。
+// 读失败时重试后跳过继续，不会中断。仅Go版本有效
+func (p *Prove) CheckHealth(dataDir string) (*post_go.HealthReport, error) {
+	if p.proofType != PowType_Go {
+		return nil, errors.New("health check is only supported by the go prover")
+	}
+	reader := p.reader
+	reader.Throttle = p.throttle
+	return post_go.CheckDataDir(dataDir, reader)
+}
+
 // SetPostLogLevel 设置日志级别
 func SetPostLogLevel(level int32) {
 	post.SetLogCallback(int(level))
@@ -0,0 +1,104 @@
+package aesscan
+
+import (
+	"crypto/aes"
+	"math/rand"
+	"testing"
+)
+
+// referenceScan encrypts with crypto/aes and collects the offsets byte by byte.
+func referenceScan(t *testing.T, key, src []byte, threshold byte) []uint32 {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(src))
+	for off := 0; off < len(src); off += BlockSize {
+		block.Encrypt(out[off:], src[off:off+BlockSize])
+	}
+	var offsets []uint32
+	for i, b := range out {
+		if b <= threshold {
+			offsets = append(offsets, uint32(i))
+		}
+	}
+	return offsets
+}
+
+func TestExpandKey(t *testing.T) {
+	// FIPS-197 appendix A.1
+	key := []byte{0x2b, 0x7e, 0x15, 0x16, 0x28, 0xae, 0xd2, 0xa6, 0xab, 0xf7, 0x15, 0x88, 0x09, 0xcf, 0x4f, 0x3c}
+	last := []byte{0xd0, 0x14, 0xf9, 0xa8, 0xc9, 0xee, 0x25, 0x89, 0xe1, 0x3f, 0x0c, 0xc8, 0xb6, 0x63, 0x0c, 0xa6}
+	var rk [176]byte
+	expandKey(key, rk[:])
+	if string(rk[160:]) != string(last) {
+		t.Fatalf("last round key %x, want %x", rk[160:], last)
+	}
+}
+
+func TestScan(t *testing.T) {
+	t.Logf("kernel: %s", Kernel())
+	checkScan(t)
+}
+
+// checkScan compares Scan with referenceScan for the current kernel.
+func checkScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// 长度覆盖16-block主循环、单block尾部以及空输入
+	for _, blocks := range []int{0, 1, 3, 4, 5, 15, 16, 17, 33, 1000, 4096 + 7} {
+		for _, threshold := range []byte{0, 1, 37, 128, 255} {
+			key := make([]byte, KeySize)
+			src := make([]byte, blocks*BlockSize)
+			rnd.Read(key)
+			rnd.Read(src)
+			c, err := NewCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := referenceScan(t, key, src, threshold)
+			got := c.Scan(src, threshold, nil)
+			if len(got) != len(want) {
+				t.Fatalf("blocks %d threshold %d: %d offsets, want %d", blocks, threshold, len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("blocks %d threshold %d: offset %d is %d, want %d", blocks, threshold, i, got[i], want[i])
+				}
+			}
+			if generic := scanGeneric(c, src, threshold, nil); len(generic) != len(want) {
+				t.Fatalf("blocks %d threshold %d: generic found %d offsets, want %d", blocks, threshold, len(generic), len(want))
+			}
+		}
+	}
+}
+
+func TestScanAppends(t *testing.T) {
+	key := make([]byte, KeySize)
+	src := make([]byte, 64*BlockSize)
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []uint32{7, 8, 9}
+	got := c.Scan(src, 255, prefix[:3:3])
+	if len(got) != 3+len(src) {
+		t.Fatalf("got %d offsets, want %d", len(got), 3+len(src))
+	}
+	for i := 3; i < len(got); i++ {
+		if got[i] != uint32(i-3) {
+			t.Fatalf("offset %d is %d, want %d", i, got[i], i-3)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	key := make([]byte, KeySize)
+	src := make([]byte, 64*1024)
+	rand.Read(src)
+	c, _ := NewCipher(key)
+	dst := make([]uint32, 0, 1024)
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		dst = c.Scan(src, 0, dst[:0])
+	}
+}
@@ -0,0 +1,63 @@
+package aesscan
+
+import (
+	"github.com/klauspost/cpuid/v2"
+)
+
+// scanAESNI and scanVAES512 encrypt src and write the offset of every output byte <= threshold to out.
+// They stop early when out might not hold the candidates of the next iteration and return the number
+// of offsets written and of blocks consumed.
+
+//go:noescape
+func scanAESNI(rk *[176]byte, src []byte, threshold byte, out []uint32) (count, blocks int)
+
+//go:noescape
+func scanVAES512(rk *[176]byte, src []byte, threshold byte, out []uint32) (count, blocks int)
+
+// kernelSpace is the free space in out a kernel needs to process its widest iteration, one
+// candidate per byte.
+const kernelSpace = 16 * BlockSize
+
+var kernel = detectKernel()
+
+func detectKernel() string {
+	switch {
+	case cpuid.CPU.Supports(cpuid.AESNI, cpuid.VAES, cpuid.AVX512F, cpuid.AVX512BW):
+		return KernelVAES512
+	case cpuid.CPU.Supports(cpuid.AESNI, cpuid.SSSE3):
+		return KernelAESNI
+	default:
+		return KernelGeneric
+	}
+}
+
+func scan(c *Cipher, src []byte, threshold byte, dst []uint32) []uint32 {
+	var fn func(*[176]byte, []byte, byte, []uint32) (int, int)
+	switch kernel {
+	case KernelVAES512:
+		fn = scanVAES512
+	case KernelAESNI:
+		fn = scanAESNI
+	default:
+		return scanGeneric(c, src, threshold, dst)
+	}
+
+	for pos := 0; pos < len(src); {
+		if cap(dst)-len(dst) < kernelSpace {
+			grown := make([]uint32, len(dst), 2*cap(dst)+kernelSpace)
+			copy(grown, dst)
+			dst = grown
+		}
+		start := len(dst)
+		count, blocks := fn(&c.rk, src[pos:], threshold, dst[start:cap(dst)])
+		dst = dst[:start+count]
+		if pos > 0 {
+			// 内核输出的偏移相对于本次传入的src
+			for i := start; i < len(dst); i++ {
+				dst[i] += uint32(pos)
+			}
+		}
+		pos += blocks * BlockSize
+	}
+	return dst
+}
@@ -0,0 +1,271 @@
+#include "textflag.h"
+
+// Register use of both kernels:
+//   AX  round keys          SI  src          CX  len(src)
+//   DI  out                 R8  cap(out)     R9  offsets written
+//   R10 bytes consumed      R11, R12, R13, BX, DX scratch
+// The round keys live in X0-X10 (Z0-Z10), the broadcast threshold in X15 (Z15) and the blocks in
+// flight in X11-X14 (Z11-Z14). A byte b is a candidate when max(b, threshold) == threshold.
+
+// EMIT appends base+bit for every set bit of BX to out.
+#define EMIT(base, label, done) \
+label: \
+	TESTQ BX, BX; \
+	JZ    done; \
+	BSFQ  BX, R11; \
+	ADDQ  base, R11; \
+	MOVL  R11, (DI)(R9*4); \
+	INCQ  R9; \
+	LEAQ  -1(BX), R12; \
+	ANDQ  R12, BX; \
+	JMP   label; \
+done:
+
+#define AESNI_ROUND(key) \
+	AESENC key, X11; \
+	AESENC key, X12; \
+	AESENC key, X13; \
+	AESENC key, X14
+
+#define VAES_ROUND(key) \
+	VAESENC key, Z11, Z11; \
+	VAESENC key, Z12, Z12; \
+	VAESENC key, Z13, Z13; \
+	VAESENC key, Z14, Z14
+
+// func scanAESNI(rk *[176]byte, src []byte, threshold byte, out []uint32) (count, blocks int)
+TEXT ·scanAESNI(SB), NOSPLIT, $0-80
+	MOVQ    rk+0(FP), AX
+	MOVQ    src_base+8(FP), SI
+	MOVQ    src_len+16(FP), CX
+	MOVQ    out_base+40(FP), DI
+	MOVQ    out_cap+56(FP), R8
+	XORQ    R9, R9
+	XORQ    R10, R10
+
+	MOVBQZX threshold+32(FP), DX
+	MOVQ    DX, X15
+	PXOR    X14, X14
+	PSHUFB  X14, X15
+
+	MOVOU 0(AX), X0
+	MOVOU 16(AX), X1
+	MOVOU 32(AX), X2
+	MOVOU 48(AX), X3
+	MOVOU 64(AX), X4
+	MOVOU 80(AX), X5
+	MOVOU 96(AX), X6
+	MOVOU 112(AX), X7
+	MOVOU 128(AX), X8
+	MOVOU 144(AX), X9
+	MOVOU 160(AX), X10
+
+aesni_loop4:
+	MOVQ CX, R11
+	SUBQ R10, R11
+	CMPQ R11, $64
+	JB   aesni_loop1
+	MOVQ R8, R11
+	SUBQ R9, R11
+	CMPQ R11, $64
+	JB   aesni_done
+
+	MOVOU 0(SI)(R10*1), X11
+	MOVOU 16(SI)(R10*1), X12
+	MOVOU 32(SI)(R10*1), X13
+	MOVOU 48(SI)(R10*1), X14
+	PXOR  X0, X11
+	PXOR  X0, X12
+	PXOR  X0, X13
+	PXOR  X0, X14
+	AESNI_ROUND(X1)
+	AESNI_ROUND(X2)
+	AESNI_ROUND(X3)
+	AESNI_ROUND(X4)
+	AESNI_ROUND(X5)
+	AESNI_ROUND(X6)
+	AESNI_ROUND(X7)
+	AESNI_ROUND(X8)
+	AESNI_ROUND(X9)
+	AESENCLAST X10, X11
+	AESENCLAST X10, X12
+	AESENCLAST X10, X13
+	AESENCLAST X10, X14
+
+	PMAXUB   X15, X11
+	PMAXUB   X15, X12
+	PMAXUB   X15, X13
+	PMAXUB   X15, X14
+	PCMPEQB  X15, X11
+	PCMPEQB  X15, X12
+	PCMPEQB  X15, X13
+	PCMPEQB  X15, X14
+	PMOVMSKB X11, BX
+	PMOVMSKB X12, R11
+	PMOVMSKB X13, R12
+	PMOVMSKB X14, R13
+	SHLQ     $16, R11
+	SHLQ     $32, R12
+	SHLQ     $48, R13
+	ORQ      R11, BX
+	ORQ      R12, BX
+	ORQ      R13, BX
+
+	EMIT(R10, aesni_emit4, aesni_emit4_done)
+	ADDQ $64, R10
+	JMP  aesni_loop4
+
+aesni_loop1:
+	MOVQ CX, R11
+	SUBQ R10, R11
+	CMPQ R11, $16
+	JB   aesni_done
+	MOVQ R8, R11
+	SUBQ R9, R11
+	CMPQ R11, $16
+	JB   aesni_done
+
+	MOVOU      0(SI)(R10*1), X11
+	PXOR       X0, X11
+	AESENC     X1, X11
+	AESENC     X2, X11
+	AESENC     X3, X11
+	AESENC     X4, X11
+	AESENC     X5, X11
+	AESENC     X6, X11
+	AESENC     X7, X11
+	AESENC     X8, X11
+	AESENC     X9, X11
+	AESENCLAST X10, X11
+	PMAXUB     X15, X11
+	PCMPEQB    X15, X11
+	PMOVMSKB   X11, BX
+
+	EMIT(R10, aesni_emit1, aesni_emit1_done)
+	ADDQ $16, R10
+	JMP  aesni_loop1
+
+aesni_done:
+	MOVQ R9, count+64(FP)
+	SHRQ $4, R10
+	MOVQ R10, blocks+72(FP)
+	RET
+
+// func scanVAES512(rk *[176]byte, src []byte, threshold byte, out []uint32) (count, blocks int)
+TEXT ·scanVAES512(SB), NOSPLIT, $0-80
+	MOVQ rk+0(FP), AX
+	MOVQ src_base+8(FP), SI
+	MOVQ src_len+16(FP), CX
+	MOVQ out_base+40(FP), DI
+	MOVQ out_cap+56(FP), R8
+	XORQ R9, R9
+	XORQ R10, R10
+
+	MOVBQZX      threshold+32(FP), DX
+	VPBROADCASTB DX, Z15
+
+	VBROADCASTI32X4 0(AX), Z0
+	VBROADCASTI32X4 16(AX), Z1
+	VBROADCASTI32X4 32(AX), Z2
+	VBROADCASTI32X4 48(AX), Z3
+	VBROADCASTI32X4 64(AX), Z4
+	VBROADCASTI32X4 80(AX), Z5
+	VBROADCASTI32X4 96(AX), Z6
+	VBROADCASTI32X4 112(AX), Z7
+	VBROADCASTI32X4 128(AX), Z8
+	VBROADCASTI32X4 144(AX), Z9
+	VBROADCASTI32X4 160(AX), Z10
+
+vaes_loop16:
+	MOVQ CX, R11
+	SUBQ R10, R11
+	CMPQ R11, $256
+	JB   vaes_loop1
+	MOVQ R8, R11
+	SUBQ R9, R11
+	CMPQ R11, $256
+	JB   vaes_done
+
+	VMOVDQU64 0(SI)(R10*1), Z11
+	VMOVDQU64 64(SI)(R10*1), Z12
+	VMOVDQU64 128(SI)(R10*1), Z13
+	VMOVDQU64 192(SI)(R10*1), Z14
+	VPXORQ    Z0, Z11, Z11
+	VPXORQ    Z0, Z12, Z12
+	VPXORQ    Z0, Z13, Z13
+	VPXORQ    Z0, Z14, Z14
+	VAES_ROUND(Z1)
+	VAES_ROUND(Z2)
+	VAES_ROUND(Z3)
+	VAES_ROUND(Z4)
+	VAES_ROUND(Z5)
+	VAES_ROUND(Z6)
+	VAES_ROUND(Z7)
+	VAES_ROUND(Z8)
+	VAES_ROUND(Z9)
+	VAESENCLAST Z10, Z11, Z11
+	VAESENCLAST Z10, Z12, Z12
+	VAESENCLAST Z10, Z13, Z13
+	VAESENCLAST Z10, Z14, Z14
+
+	VPMAXUB  Z15, Z11, Z11
+	VPMAXUB  Z15, Z12, Z12
+	VPMAXUB  Z15, Z13, Z13
+	VPMAXUB  Z15, Z14, Z14
+	VPCMPEQB Z15, Z11, K1
+	VPCMPEQB Z15, Z12, K2
+	VPCMPEQB Z15, Z13, K3
+	VPCMPEQB Z15, Z14, K4
+
+	KMOVQ K1, BX
+	EMIT(R10, vaes_emit0, vaes_emit0_done)
+	LEAQ  64(R10), R13
+	KMOVQ K2, BX
+	EMIT(R13, vaes_emit1, vaes_emit1_done)
+	ADDQ  $64, R13
+	KMOVQ K3, BX
+	EMIT(R13, vaes_emit2, vaes_emit2_done)
+	ADDQ  $64, R13
+	KMOVQ K4, BX
+	EMIT(R13, vaes_emit3, vaes_emit3_done)
+
+	ADDQ $256, R10
+	JMP  vaes_loop16
+
+vaes_loop1:
+	// 剩余不足16个block，逐个处理，使用VEX编码避免SSE切换开销
+	MOVQ CX, R11
+	SUBQ R10, R11
+	CMPQ R11, $16
+	JB   vaes_done
+	MOVQ R8, R11
+	SUBQ R9, R11
+	CMPQ R11, $16
+	JB   vaes_done
+
+	VMOVDQU     0(SI)(R10*1), X11
+	VPXOR       X0, X11, X11
+	VAESENC     X1, X11, X11
+	VAESENC     X2, X11, X11
+	VAESENC     X3, X11, X11
+	VAESENC     X4, X11, X11
+	VAESENC     X5, X11, X11
+	VAESENC     X6, X11, X11
+	VAESENC     X7, X11, X11
+	VAESENC     X8, X11, X11
+	VAESENC     X9, X11, X11
+	VAESENCLAST X10, X11, X11
+	VPMAXUB     X15, X11, X11
+	VPCMPEQB    X15, X11, X11
+	VPMOVMSKB   X11, BX
+
+	EMIT(R10, vaes_emit_tail, vaes_emit_tail_done)
+	ADDQ $16, R10
+	JMP  vaes_loop1
+
+vaes_done:
+	VZEROUPPER
+	MOVQ R9, count+64(FP)
+	SHRQ $4, R10
+	MOVQ R10, blocks+72(FP)
+	RET
@@ -0,0 +1,16 @@
+package aesscan
+
+import (
+	"github.com/klauspost/cpuid/v2"
+	"testing"
+)
+
+// TestScanAESNI runs the AES-NI kernel on CPUs where the VAES kernel would be picked.
+func TestScanAESNI(t *testing.T) {
+	if !cpuid.CPU.Supports(cpuid.AESNI, cpuid.SSSE3) {
+		t.Skip("no AES-NI")
+	}
+	defer func(k string) { kernel = k }(kernel)
+	kernel = KernelAESNI
+	checkScan(t)
+}
@@ -0,0 +1,10 @@
+//go:build !amd64
+// +build !amd64
+
+package aesscan
+
+var kernel = KernelGeneric
+
+func scan(c *Cipher, src []byte, threshold byte, dst []uint32) []uint32 {
+	return scanGeneric(c, src, threshold, dst)
+}
@@ -0,0 +1,286 @@
+package post_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDeviationThreshold is the z-score above which a nonce counts as suspicious unless set with
+// WithDeviationThreshold.
+const DefaultDeviationThreshold = 5.0
+
+type calibrationOption struct {
+	reader    ReaderOptions
+	batchSize int
+	fraction  float64
+	seed      uint64
+	threshold float64
+}
+
+// CalibrationOptionFunc is a function that sets an option for Calibrate.
+type CalibrationOptionFunc func(*calibrationOption) error
+
+// WithCalibrationReader sets how the post data files are read while calibrating.
+func WithCalibrationReader(opts ReaderOptions) CalibrationOptionFunc {
+	return func(o *calibrationOption) error {
+		if opts.Parallel < 0 || opts.PerDevice < 0 {
+			return fmt.Errorf("invalid reader options; expected: >= 0, given: parallel %d, per device %d", opts.Parallel, opts.PerDevice)
+		}
+		o.reader = opts
+		return nil
+	}
+}
+
+// WithCalibrationBatchSize sets the number of bytes read and proven at once, it must be a multiple of 4096.
+func WithCalibrationBatchSize(size int) CalibrationOptionFunc {
+	return func(o *calibrationOption) error {
+		if size <= 0 || size%4096 != 0 {
+			return fmt.Errorf("invalid batch size; expected: multiple of 4096, given: %d", size)
+		}
+		o.batchSize = size
+		return nil
+	}
+}
+
+// WithSampleFraction only reads about fraction of the batches, chosen pseudo-randomly from seed. The
+// same seed samples the same batches.
+func WithSampleFraction(fraction float64, seed uint64) CalibrationOptionFunc {
+	return func(o *calibrationOption) error {
+		if !(fraction > 0 && fraction <= 1) {
+			return fmt.Errorf("invalid sample fraction; expected: (0, 1], given: %v", fraction)
+		}
+		o.fraction = fraction
+		o.seed = seed
+		return nil
+	}
+}
+
+// WithDeviationThreshold sets the z-score above which the count of a nonce is reported as suspicious.
+func WithDeviationThreshold(z float64) CalibrationOptionFunc {
+	return func(o *calibrationOption) error {
+		if !(z > 0) {
+			return fmt.Errorf("invalid deviation threshold; expected: > 0, given: %v", z)
+		}
+		o.threshold = z
+		return nil
+	}
+}
+
+// NonceCalibration is the result of a single nonce.
+type NonceCalibration struct {
+	Nonce uint32 `json:"nonce"`
+	// Count is the number of scanned labels below the proving difficulty.
+	Count uint64 `json:"count"`
+	// Deviation is the z-score of Count against the expected count.
+	Deviation float64 `json:"deviation"`
+}
+
+// Calibration compares the number of labels below the proving difficulty found for every nonce with
+// the number ProvingParams.Difficulty predicts. On scrypt labels the counts are binomial with a mean of
+// Expected; large deviations point at corrupt or non-scrypt data or at a wrong difficulty.
+type Calibration struct {
+	Challenge      []byte  `json:"challenge"`
+	K1             uint32  `json:"k1"`
+	Difficulty     uint64  `json:"difficulty"`
+	NumLabels      uint64  `json:"num_labels"`
+	ScannedLabels  uint64  `json:"scanned_labels"`
+	SampleFraction float64 `json:"sample_fraction"`
+	// Expected is the expected count of every nonce for the scanned labels.
+	Expected float64            `json:"expected"`
+	Nonces   []NonceCalibration `json:"nonces"`
+	// Mean is the average count, TotalDeviation the z-score of the sum of all counts.
+	Mean           float64 `json:"mean"`
+	TotalDeviation float64 `json:"total_deviation"`
+	// ChiSquare is the chi-square statistic of the counts with len(Nonces) degrees of freedom.
+	ChiSquare float64 `json:"chi_square"`
+	Threshold float64 `json:"threshold"`
+	// Suspicious are the nonces whose deviation exceeds Threshold.
+	Suspicious     []uint32 `json:"suspicious"`
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+}
+
+// OK reports whether neither a single nonce nor the sum of all counts deviates beyond the threshold.
+func (c *Calibration) OK() bool {
+	return len(c.Suspicious) == 0 && math.Abs(c.TotalDeviation) <= c.Threshold
+}
+
+func (c *Calibration) String() string {
+	return fmt.Sprintf("scanned %d/%d labels, expected %.2f per nonce, mean %.2f, total deviation %.2f, chi-square %.1f (%d nonces), suspicious %d",
+		c.ScannedLabels, c.NumLabels, c.Expected, c.Mean, c.TotalDeviation, c.ChiSquare, len(c.Nonces), len(c.Suspicious))
+}
+
+// WriteJSON writes the calibration as indented JSON to w.
+func (c *Calibration) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
+
+// Save writes the calibration as JSON to filename.
+func (c *Calibration) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteJSON(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Calibrate scans the post data of dataDir for challenge with nonces nonces starting at 0, like
+// GenerateProof does, but does not stop at K2: it counts every label below the proving difficulty for
+// every nonce and compares the counts with the expected one.
+func Calibrate(dataDir string, challenge []byte, nonces, K1 uint32, powDifficulty []byte, thread int32, opts ...CalibrationOptionFunc) (*Calibration, error) {
+	if nonces == 0 || nonces%NONCES_PER_AES != 0 {
+		return nil, fmt.Errorf("invalid nonces; expected: multiple of %d, given: %d", NONCES_PER_AES, nonces)
+	}
+	options := &calibrationOption{
+		reader:    DefaultReaderOptions(),
+		batchSize: BUNCH_SIZE,
+		fraction:  1,
+		threshold: DefaultDeviationThreshold,
+	}
+	options.reader.Buffers = DefaultProofBuffers
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata, err := shared.ReadMetadata(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("loading metadata: %w", err)
+	}
+	params, err := NewProvingParams(metadata, &Config{
+		PowDifficulty: powDifficulty,
+		K1:            K1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating proving params: %w", err)
+	}
+	prover, err := NewProver8_56(challenge, nonceRange(0, nonces), params, metadata.NodeId)
+	if err != nil {
+		return nil, err
+	}
+	defer prover.Destroy()
+	prover.SetWorkers(int(thread))
+	prover.SetThrottle(options.reader.Throttle)
+
+	start := time.Now()
+	counts := make([]uint64, nonces)
+	var scanned uint64
+	consume := func(nonce uint32, index uint64) bool {
+		atomic.AddUint64(&counts[nonce], 1)
+		return false
+	}
+	proveBatch := func(batch *Batch) bool {
+		if batch == nil {
+			return true
+		}
+		prover.prove(batch.Data, batch.Pos/LABEL_SIZE, consume)
+		atomic.AddUint64(&scanned, uint64(len(batch.Data)/LABEL_SIZE))
+		batch.Release()
+		return true
+	}
+	if options.fraction >= 1 {
+		err = ReadDataWithOptions(dataDir, options.batchSize, metadata.MaxFileSize, options.reader, proveBatch)
+	} else {
+		err = readSampled(dataDir, metadata.MaxFileSize, options, proveBatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if scanned == 0 {
+		return nil, fmt.Errorf("no labels scanned in %s", dataDir)
+	}
+
+	c := &Calibration{
+		Challenge:      challenge,
+		K1:             K1,
+		Difficulty:     params.Difficulty,
+		NumLabels:      uint64(metadata.NumUnits) * metadata.LabelsPerUnit,
+		ScannedLabels:  scanned,
+		SampleFraction: options.fraction,
+		Threshold:      options.threshold,
+		Suspicious:     []uint32{},
+	}
+	p := float64(params.Difficulty) / math.Pow(2, 64)
+	c.Expected = p * float64(scanned)
+	// 每个nonce的计数服从二项分布
+	sigma := math.Sqrt(c.Expected * (1 - p))
+	var total uint64
+	for nonce, count := range counts {
+		deviation := (float64(count) - c.Expected) / sigma
+		c.Nonces = append(c.Nonces, NonceCalibration{Nonce: uint32(nonce), Count: count, Deviation: deviation})
+		c.ChiSquare += deviation * deviation
+		if math.Abs(deviation) > options.threshold {
+			c.Suspicious = append(c.Suspicious, uint32(nonce))
+		}
+		total += count
+	}
+	c.Mean = float64(total) / float64(nonces)
+	c.TotalDeviation = (float64(total) - c.Expected*float64(nonces)) / (sigma * math.Sqrt(float64(nonces)))
+	c.ElapsedSeconds = time.Since(start).Seconds()
+	return c, nil
+}
+
+// readSampled reads the batches picked by sampled one file after another and calls fn for each of them.
+func readSampled(datadir string, fileSize uint64, opts *calibrationOption, fn ReadBatch) error {
+	files, err := shared.ListInitFiles(datadir)
+	if err != nil {
+		return err
+	}
+	engine, err := openEngine(datadir, opts.reader.Engine)
+	if err != nil {
+		return err
+	}
+	for _, entry := range files {
+		id := entry.Index
+		file, err := engine.Open(entry.Path)
+		if err != nil {
+			return err
+		}
+		reader := NewEngineBatchingReader(file, uint64(id)*fileSize, opts.batchSize, uint64(file.Size()))
+		reader.configure(opts.reader, id, entry.Path)
+		reader.pos = reader.startingPos
+		// 跳过失败的batch会读到未抽中的位置
+		reader.skipErrors = false
+		for offset := uint64(0); offset < reader.totalSize; offset += uint64(opts.batchSize) {
+			if !sampled(opts.seed, reader.startingPos+offset, opts.fraction) {
+				continue
+			}
+			reader.pos = reader.startingPos + offset
+			batch, err := reader.Next()
+			if err != nil {
+				_ = file.Close()
+				return err
+			}
+			if batch == nil {
+				break
+			}
+			fn(batch)
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	fn(nil)
+	return nil
+}
+
+// sampled reports whether the batch at pos belongs to the sample, by hashing seed and pos (splitmix64).
+func sampled(seed, pos uint64, fraction float64) bool {
+	z := seed + pos + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return float64(z>>11)/(1<<53) < fraction
+}
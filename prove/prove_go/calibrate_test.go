@@ -0,0 +1,93 @@
+package post_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/trying2016/post-go/shared"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCalibrate(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+		nonces        = 64
+	)
+	dir, _ := newTestPost(t, numUnits, labelsPerUnit, 64*1024)
+	challenge := sha256.Sum256([]byte("calibrate"))
+
+	c, err := Calibrate(dir, challenge[:], nonces, shared.K1, TestNetPowDifficulty, 2,
+		WithCalibrationBatchSize(16*1024), WithCalibrationReader(ReaderOptions{Parallel: 2, Buffers: 4, Engine: EngineBuffered}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ScannedLabels != numUnits*labelsPerUnit || len(c.Nonces) != nonces {
+		t.Fatalf("scanned %d labels with %d nonces", c.ScannedLabels, len(c.Nonces))
+	}
+	if c.Expected < float64(shared.K1)*0.9 || c.Expected > float64(shared.K1)*1.1 {
+		t.Fatalf("expected %v per nonce, k1 %d", c.Expected, shared.K1)
+	}
+	if !c.OK() {
+		t.Fatalf("random labels flagged: %v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := c.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Calibration
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Nonces[7] != c.Nonces[7] || decoded.ScannedLabels != c.ScannedLabels {
+		t.Fatalf("json round trip: %+v", decoded)
+	}
+}
+
+func TestCalibrateSampled(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	dir, _ := newTestPost(t, 2, 4096, 64*1024)
+	challenge := sha256.Sum256([]byte("sampled"))
+
+	scan := func(seed uint64) *Calibration {
+		c, err := Calibrate(dir, challenge[:], 16, shared.K1, TestNetPowDifficulty, 1,
+			WithCalibrationBatchSize(4096), WithSampleFraction(0.5, seed), WithCalibrationReader(ReaderOptions{Engine: EngineBuffered}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	c := scan(1)
+	if c.ScannedLabels == 0 || c.ScannedLabels >= 2*4096 || c.ScannedLabels%256 != 0 {
+		t.Fatalf("scanned %d labels", c.ScannedLabels)
+	}
+	if again := scan(1); again.ScannedLabels != c.ScannedLabels || again.Nonces[3] != c.Nonces[3] {
+		t.Fatal("same seed sampled different batches")
+	}
+}
+
+func TestCalibrateCorrupt(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	dir, labels := newTestPost(t, 2, 4096, 64*1024)
+	// 全零数据：所有label相同，每个nonce要么全部命中要么一个都没有
+	zero := make([]byte, len(labels)/2)
+	for i := 0; i < 2; i++ {
+		if err := os.WriteFile(filepath.Join(dir, shared.InitFileName(i)), zero, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	challenge := sha256.Sum256([]byte("corrupt"))
+
+	c, err := Calibrate(dir, challenge[:], 32, shared.K1, TestNetPowDifficulty, 1,
+		WithCalibrationBatchSize(16*1024), WithCalibrationReader(ReaderOptions{Engine: EngineBuffered}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OK() || len(c.Suspicious) == 0 {
+		t.Fatalf("zero labels not flagged: %v", c)
+	}
+}
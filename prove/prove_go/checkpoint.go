@@ -0,0 +1,158 @@
+package post_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CheckpointName is the file the scan progress is saved to, next to the post metadata.
+const CheckpointName = "proof_checkpoint.json"
+
+// DefaultCheckpointInterval is a reasonable interval for WithCheckpoint. Checkpoints are off unless enabled.
+const DefaultCheckpointInterval = time.Minute
+
+// Checkpoint is the progress of a proof scan, enough to resume it after a restart.
+type Checkpoint struct {
+	Challenge  []byte `json:"challenge"`
+	K1         uint32 `json:"k1"`
+	K2         uint32 `json:"k2"`
+	StartNonce uint32 `json:"start_nonce"`
+	Nonces     uint32 `json:"nonces"`
+	// Watermarks maps a post data file id to the position, in bytes from the start of the data, up to
+	// which the file has been proven completely.
+	Watermarks map[int]uint64 `json:"watermarks"`
+	// Indexes are the labels found for each nonce below the watermarks.
+	Indexes map[uint32][]uint64 `json:"indexes"`
+	// Pows are the k2pows of the nonce groups of the range, empty until they are computed.
+	Pows []uint64 `json:"pows,omitempty"`
+}
+
+// LoadCheckpoint reads the checkpoint saved in dataDir. It returns nil without error when there is none.
+func LoadCheckpoint(dataDir string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, CheckpointName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("decoding checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// Save writes the checkpoint to dataDir. The previous checkpoint is replaced atomically, so a crash
+// while saving leaves either the old or the new one.
+func (c *Checkpoint) Save(dataDir string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(dataDir, CheckpointName)
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// RemoveCheckpoint deletes the checkpoint of dataDir, if any.
+func RemoveCheckpoint(dataDir string) error {
+	err := os.Remove(filepath.Join(dataDir, CheckpointName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Matches reports whether the checkpoint was saved by a scan with the same parameters.
+func (c *Checkpoint) Matches(challenge []byte, K1, K2, nonces uint32) bool {
+	return bytes.Equal(c.Challenge, challenge) && c.K1 == K1 && c.K2 == K2 && c.Nonces == nonces
+}
+
+// scanProgress tracks which batches of a scan are proven. Batches of a file are read in order but
+// proven concurrently, so a file's watermark only moves past a batch once all batches before it are done.
+type scanProgress struct {
+	mu         sync.Mutex
+	fileSize   uint64
+	watermarks map[int]uint64
+	// done 已完成但还不连续的batch，位置 -> 长度
+	done map[uint64]uint64
+}
+
+func newScanProgress(fileSize uint64, watermarks map[int]uint64) *scanProgress {
+	p := &scanProgress{
+		fileSize:   fileSize,
+		watermarks: make(map[int]uint64),
+		done:       make(map[uint64]uint64),
+	}
+	for id, wm := range watermarks {
+		p.watermarks[id] = wm
+	}
+	return p
+}
+
+// watermark returns the watermark of file id, the start of the file while nothing of it is proven.
+func (p *scanProgress) watermark(id int) uint64 {
+	if wm, ok := p.watermarks[id]; ok {
+		return wm
+	}
+	return uint64(id) * p.fileSize
+}
+
+// complete marks the batch at pos as proven.
+func (p *scanProgress) complete(pos uint64, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := int(pos / p.fileSize)
+	p.done[pos] = uint64(size)
+	wm := p.watermark(id)
+	for {
+		n, ok := p.done[wm]
+		if !ok {
+			break
+		}
+		delete(p.done, wm)
+		wm += n
+	}
+	p.watermarks[id] = wm
+}
+
+// snapshot returns a copy of the watermarks.
+func (p *scanProgress) snapshot() map[int]uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	watermarks := make(map[int]uint64, len(p.watermarks))
+	for id, wm := range p.watermarks {
+		watermarks[id] = wm
+	}
+	return watermarks
+}
+
+// startOffsets returns where the reader has to start in every file to skip what is proven.
+func (p *scanProgress) startOffsets() map[int]uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offsets := make(map[int]uint64, len(p.watermarks))
+	for id, wm := range p.watermarks {
+		offsets[id] = wm - uint64(id)*p.fileSize
+	}
+	return offsets
+}
+
+// covered reports whether the label index lies below the watermark of its file, the only labels whose
+// batches will not be read again after a resume.
+func covered(watermarks map[int]uint64, fileSize, index uint64) bool {
+	pos := index * LABEL_SIZE
+	id := int(pos / fileSize)
+	wm, ok := watermarks[id]
+	return ok && pos < wm
+}
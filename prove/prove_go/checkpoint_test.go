@@ -0,0 +1,117 @@
+package post_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/trying2016/post-go/shared"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanProgress(t *testing.T) {
+	const fileSize = 4096
+	progress := newScanProgress(fileSize, map[int]uint64{1: fileSize + 1024})
+
+	// 乱序完成，水位线只在连续时前进
+	progress.complete(1024, 1024)
+	if wm := progress.snapshot()[0]; wm != 0 {
+		t.Fatalf("watermark moved to %d past a missing batch", wm)
+	}
+	progress.complete(0, 1024)
+	progress.complete(fileSize+1024, 1024)
+	watermarks := progress.snapshot()
+	if watermarks[0] != 2048 || watermarks[1] != fileSize+2048 {
+		t.Fatalf("unexpected watermarks %v", watermarks)
+	}
+	offsets := progress.startOffsets()
+	if offsets[0] != 2048 || offsets[1] != 2048 {
+		t.Fatalf("unexpected start offsets %v", offsets)
+	}
+	if !covered(watermarks, fileSize, 2047/LABEL_SIZE) || covered(watermarks, fileSize, 2048/LABEL_SIZE) {
+		t.Fatal("covered does not follow the watermark")
+	}
+}
+
+func TestGenerateProofResume(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+		fileSize      = 64 * 1024
+		nonces        = 16
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, fileSize)
+	challenge := sha256.Sum256([]byte("resume"))
+	opts := []ProofOptionFunc{
+		WithBatchSize(16 * 1024),
+		WithReaderOptions(ReaderOptions{Parallel: 1, Engine: EngineBuffered}),
+		WithCheckpoint(DefaultCheckpointInterval),
+	}
+
+	proof, err := GenerateProof(dir, challenge[:], nonces, shared.K1, shared.K2, TestNetPowDifficulty, 1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, CheckpointName)); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint left behind after the proof was found: %v", err)
+	}
+
+	// 模拟第一个文件扫完后中断
+	numLabels := uint64(numUnits * labelsPerUnit)
+	indices := unpackIndices(proof.Indices, int(requiredBits(numLabels)), int(shared.K2))
+	cp := &Checkpoint{
+		Challenge:  challenge[:],
+		K1:         shared.K1,
+		K2:         shared.K2,
+		StartNonce: proof.Nonce / nonces * nonces,
+		Nonces:     nonces,
+		Watermarks: map[int]uint64{0: fileSize},
+		Indexes:    make(map[uint32][]uint64),
+	}
+	for _, index := range indices {
+		if covered(cp.Watermarks, fileSize, index) {
+			cp.Indexes[proof.Nonce] = append(cp.Indexes[proof.Nonce], index)
+		}
+	}
+	if err := cp.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := GenerateProof(dir, challenge[:], nonces, shared.K1, shared.K2, TestNetPowDifficulty, 1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProof(t, resumed, challenge[:], labels, numLabels, shared.K1, shared.K2)
+	if resumed.Nonce != proof.Nonce || !bytes.Equal(resumed.Indices, proof.Indices) {
+		t.Fatalf("resumed proof differs: nonce %d vs %d", resumed.Nonce, proof.Nonce)
+	}
+
+	// 其它challenge的checkpoint必须被丢弃，否则会直接返回这些伪造的索引
+	other := sha256.Sum256([]byte("other"))
+	cp = &Checkpoint{
+		Challenge:  other[:],
+		K1:         shared.K1,
+		K2:         shared.K2,
+		Nonces:     nonces,
+		Watermarks: map[int]uint64{0: fileSize, 1: 2 * fileSize},
+		Indexes:    map[uint32][]uint64{0: make([]uint64, shared.K2)},
+	}
+	if err := cp.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	proof, err = GenerateProof(dir, challenge[:], nonces, shared.K1, shared.K2, TestNetPowDifficulty, 1, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProof(t, proof, challenge[:], labels, numLabels, shared.K1, shared.K2)
+
+	// 删不掉的checkpoint不影响proof
+	if err := os.MkdirAll(filepath.Join(dir, CheckpointName, "stuck"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	proof, err = GenerateProof(dir, challenge[:], nonces, shared.K1, shared.K2, TestNetPowDifficulty, 1, opts...)
+	if err != nil {
+		t.Fatalf("proof aborted by a checkpoint that cannot be removed: %v", err)
+	}
+	checkProof(t, proof, challenge[:], labels, numLabels, shared.K1, shared.K2)
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package post_go
+
+import (
+	"os"
+	"syscall"
+)
+
+// deviceID returns the id of the device holding the file. Symlinks are followed so files linked in
+// from other disks are reported with the device they really live on.
+func deviceID(filename string) uint64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev)
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package post_go
+
+import (
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+)
+
+// deviceID returns an id for the volume holding the file. Symlinks are followed so files linked in
+// from other disks are reported with the volume they really live on.
+func deviceID(filename string) uint64 {
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strings.ToUpper(filepath.VolumeName(filename))))
+	return h.Sum64()
+}
@@ -0,0 +1,272 @@
+package post_go
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ncw/directio"
+	"github.com/trying2016/post-go/shared"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// I/O engine names accepted by ReaderOptions.Engine.
+const (
+	// EngineBuffered reads through the page cache with plain read calls.
+	EngineBuffered = "buffered"
+	// EngineDirect reads with O_DIRECT into aligned buffers, bypassing the page cache.
+	EngineDirect = "direct"
+	// EngineMmap maps the files and advises the kernel about the sequential access pattern.
+	EngineMmap = "mmap"
+	// EngineUring submits reads through an io_uring queue (linux only).
+	EngineUring = "uring"
+	// EngineAuto probes the data dir and picks the fastest available engine.
+	EngineAuto = "auto"
+)
+
+var (
+	// ErrEngineUnsupported is returned when an engine is not available on this platform.
+	ErrEngineUnsupported = errors.New("io engine not supported on this platform")
+	// ErrUnknownEngine is returned for engine names nobody registered.
+	ErrUnknownEngine = errors.New("unknown io engine")
+)
+
+// IOEngine opens post data files for reading.
+type IOEngine interface {
+	// Name returns the name the engine is registered with.
+	Name() string
+	// Open opens the file for reading.
+	Open(filename string) (EngineFile, error)
+}
+
+// EngineFile is a post data file opened by an IOEngine.
+type EngineFile interface {
+	io.ReaderAt
+	io.Closer
+	// Size returns the size of the file in bytes.
+	Size() int64
+	// Alignment returns the alignment required for buffers, offsets and lengths passed to ReadAt.
+	Alignment() int
+}
+
+var (
+	enginesMtx sync.RWMutex
+	engines    = make(map[string]IOEngine)
+)
+
+func init() {
+	RegisterIOEngine(bufferedEngine{})
+	RegisterIOEngine(directEngine{})
+	RegisterIOEngine(mmapEngine{})
+	RegisterIOEngine(uringEngine{})
+}
+
+// RegisterIOEngine makes an engine available by name, replacing any engine registered with the same name.
+func RegisterIOEngine(engine IOEngine) {
+	enginesMtx.Lock()
+	defer enginesMtx.Unlock()
+	engines[engine.Name()] = engine
+}
+
+// IOEngineByName returns the engine registered under name.
+func IOEngineByName(name string) (IOEngine, error) {
+	enginesMtx.RLock()
+	defer enginesMtx.RUnlock()
+	engine, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEngine, name)
+	}
+	return engine, nil
+}
+
+// IOEngines returns the names of all registered engines in sorted order.
+func IOEngines() []string {
+	enginesMtx.RLock()
+	defer enginesMtx.RUnlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// alignedBuffer returns a buffer of size bytes whose address is a multiple of alignment.
+func alignedBuffer(size, alignment int) []byte {
+	if alignment <= 1 {
+		return make([]byte, size)
+	}
+	block := make([]byte, size+alignment)
+	offset := 0
+	if rem := int(uintptrOf(block) % uintptr(alignment)); rem != 0 {
+		offset = alignment - rem
+	}
+	return block[offset : offset+size : offset+size]
+}
+
+func uintptrOf(b []byte) uintptr {
+	return uintptr(unsafe.Pointer(&b[0]))
+}
+
+// alignUp rounds n up to a multiple of alignment.
+func alignUp(n, alignment int) int {
+	if alignment <= 1 {
+		return n
+	}
+	return (n + alignment - 1) / alignment * alignment
+}
+
+// osFile adapts an *os.File to EngineFile.
+type osFile struct {
+	*os.File
+	size      int64
+	alignment int
+}
+
+func newOsFile(file *os.File, alignment int) (*osFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if alignment < 1 {
+		alignment = 1
+	}
+	return &osFile{File: file, size: info.Size(), alignment: alignment}, nil
+}
+
+func (f *osFile) Size() int64 {
+	return f.size
+}
+
+func (f *osFile) Alignment() int {
+	return f.alignment
+}
+
+// bufferedEngine reads through the page cache.
+type bufferedEngine struct{}
+
+func (bufferedEngine) Name() string {
+	return EngineBuffered
+}
+
+func (bufferedEngine) Open(filename string) (EngineFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newOsFile(file, 1)
+}
+
+// directEngine reads with O_DIRECT. Buffers, offsets and lengths have to be aligned to directio.AlignSize.
+type directEngine struct{}
+
+func (directEngine) Name() string {
+	return EngineDirect
+}
+
+func (directEngine) Open(filename string) (EngineFile, error) {
+	file, err := directio.OpenFile(filename, os.O_RDONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return newOsFile(file, directio.AlignSize)
+}
+
+// probeReadSize is the amount of data each engine reads while probing.
+const probeReadSize = 32 * shared.MiB
+
+var (
+	probeMtx   sync.Mutex
+	probeCache = make(map[uint64]string)
+)
+
+// ProbeIOEngine reads a sample of the first post data file of datadir with every registered engine and
+// returns the fastest one. The result is cached per filesystem, so data dirs on the same device are only
+// probed once. Each engine reads a different region of the file, so page cache hits of one engine don't
+// favour the next one.
+func ProbeIOEngine(datadir string) (IOEngine, error) {
+	files, err := shared.ListInitFiles(datadir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no post data files in %s", datadir)
+	}
+	filename := files[0].Path
+	device := deviceID(filename)
+
+	probeMtx.Lock()
+	defer probeMtx.Unlock()
+	if name, ok := probeCache[device]; ok {
+		return IOEngineByName(name)
+	}
+
+	best := ""
+	var bestSpeed float64
+	for i, name := range IOEngines() {
+		engine, err := IOEngineByName(name)
+		if err != nil {
+			continue
+		}
+		speed, err := probeEngine(engine, filename, int64(i)*probeReadSize)
+		if err != nil {
+			continue
+		}
+		if speed > bestSpeed {
+			best, bestSpeed = name, speed
+		}
+	}
+	if best == "" {
+		return nil, fmt.Errorf("no io engine could read %s", filename)
+	}
+	probeCache[device] = best
+	return IOEngineByName(best)
+}
+
+// probeEngine returns the read throughput of engine in bytes per second.
+func probeEngine(engine IOEngine, filename string, offset int64) (float64, error) {
+	file, err := engine.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return probeFile(file, offset)
+}
+
+// probeFile measures the read throughput of file by reading probeReadSize bytes at offset.
+func probeFile(file EngineFile, offset int64) (float64, error) {
+	size := int64(probeReadSize)
+	if size > file.Size() {
+		size = file.Size()
+	}
+	if offset+size > file.Size() {
+		offset = 0
+	}
+	offset = offset / int64(file.Alignment()) * int64(file.Alignment())
+	buf := alignedBuffer(alignUp(BUNCH_SIZE, file.Alignment()), file.Alignment())
+
+	start := time.Now()
+	read := int64(0)
+	for read < size {
+		n, err := file.ReadAt(buf, offset+read)
+		read += int64(n)
+		if err == io.EOF || n == 0 {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	elapsed := time.Since(start).Seconds()
+	if read == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if elapsed <= 0 {
+		elapsed = 1e-9
+	}
+	return float64(read) / elapsed, nil
+}
@@ -0,0 +1,101 @@
+//go:build !windows
+// +build !windows
+
+package post_go
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// mmapReadahead is how far ahead of the last read the mapping is advised as needed soon.
+const mmapReadahead = 32 << 20
+
+// mmapEngine maps the whole file read-only and copies batches out of the mapping.
+// The mapping is advised sequential, and a window ahead of each read as needed soon, so the kernel
+// reads ahead without pulling the whole file into the page cache.
+type mmapEngine struct{}
+
+func (mmapEngine) Name() string {
+	return EngineMmap
+}
+
+func (mmapEngine) Open(filename string) (EngineFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return &mmapFile{}, nil
+	}
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	_ = unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	fadviseSequential(int(file.Fd()), size)
+	f := &mmapFile{data: data}
+	f.willNeed(0)
+	return f, nil
+}
+
+type mmapFile struct {
+	data []byte
+	// 已经advise到的位置，读到窗口的一半时再往后advise
+	advised int64
+}
+
+// willNeed advises the window after off as needed soon, unless most of it was advised already. A read
+// far behind the advised window, e.g. when sampling, starts a new window.
+func (f *mmapFile) willNeed(off int64) {
+	size := int64(len(f.data))
+	if advised := atomic.LoadInt64(&f.advised); off >= size || (off+mmapReadahead/2 < advised && off+mmapReadahead >= advised) {
+		return
+	}
+	// madvise要求起始地址按页对齐，mapping本身是对齐的
+	pageSize := int64(os.Getpagesize())
+	start := off / pageSize * pageSize
+	end := off + mmapReadahead
+	if end > size {
+		end = size
+	}
+	atomic.StoreInt64(&f.advised, end)
+	_ = unix.Madvise(f.data[start:end], unix.MADV_WILLNEED)
+}
+
+func (f *mmapFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	f.willNeed(off + int64(n))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *mmapFile) Size() int64 {
+	return int64(len(f.data))
+}
+
+func (f *mmapFile) Alignment() int {
+	return 1
+}
+
+func (f *mmapFile) Close() error {
+	if f.data == nil {
+		return nil
+	}
+	err := unix.Munmap(f.data)
+	f.data = nil
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package post_go
+
+// mmapEngine is not available on windows.
+type mmapEngine struct{}
+
+func (mmapEngine) Name() string {
+	return EngineMmap
+}
+
+func (mmapEngine) Open(filename string) (EngineFile, error) {
+	return nil, ErrEngineUnsupported
+}
@@ -0,0 +1,67 @@
+package post_go
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIOEngines(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 3*BUNCH_SIZE+4096)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	filename := filepath.Join(dir, "postdata_0.bin")
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range IOEngines() {
+		t.Run(name, func(t *testing.T) {
+			engine, err := IOEngineByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := engine.Open(filename)
+			if errors.Is(err, ErrEngineUnsupported) || (err != nil && name == EngineUring) {
+				t.Skipf("engine not available: %v", err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			reader := NewEngineBatchingReader(file, 0, BUNCH_SIZE, uint64(file.Size()))
+			var got []byte
+			for {
+				batch, err := reader.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if batch == nil {
+					break
+				}
+				if batch.Pos != uint64(len(got)) {
+					t.Fatalf("batch position %d, expected %d", batch.Pos, len(got))
+				}
+				got = append(got, batch.Data...)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("engine %s returned different data", name)
+			}
+		})
+	}
+}
+
+func TestProbeIOEngine(t *testing.T) {
+	dir := t.TempDir()
+	writePosFiles(t, dir, 1, 2*BUNCH_SIZE)
+	engine, err := ProbeIOEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("fastest engine:", engine.Name())
+}
@@ -0,0 +1,325 @@
+package post_go
+
+import (
+	"fmt"
+	"github.com/ncw/directio"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// io_uring ABI, see include/uapi/linux/io_uring.h.
+const (
+	sysIoUringSetup = 425
+	sysIoUringEnter = 426
+
+	uringOffSQRing = 0
+	uringOffCQRing = 0x8000000
+	uringOffSQEs   = 0x10000000
+
+	uringFeatSingleMmap = 1 << 0
+	uringEnterGetEvents = 1 << 0
+	uringOpRead         = 22
+
+	// uringEntries is the submission queue depth of each file.
+	uringEntries = 32
+	// uringChunkSize is the size of a single read request, a ReadAt is split into chunks of this size.
+	uringChunkSize = 128 * 1024
+)
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	resv2                                                           uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	resv2                                                           uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode   uint8
+	flags    uint8
+	ioprio   uint16
+	fd       int32
+	off      uint64
+	addr     uint64
+	len      uint32
+	rwFlags  uint32
+	userData uint64
+	pad      [3]uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringEngine reads O_DIRECT files through an io_uring, keeping up to uringEntries reads in flight.
+type uringEngine struct{}
+
+func (uringEngine) Name() string {
+	return EngineUring
+}
+
+func (uringEngine) Open(filename string) (EngineFile, error) {
+	file, err := directio.OpenFile(filename, os.O_RDONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	ring, err := newUring(uringEntries)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	alignment := directio.AlignSize
+	if alignment < 1 {
+		alignment = 1
+	}
+	return &uringFile{file: file, ring: ring, size: info.Size(), alignment: alignment}, nil
+}
+
+type uring struct {
+	fd     int
+	sqRing []byte
+	cqRing []byte
+	sqeMem []byte
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                []uint32
+	sqes                   []uringSQE
+
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []uringCQE
+	entries                uint32
+}
+
+func newUring(entries uint32) (*uring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(sysIoUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &uring{fd: int(fd), entries: p.sqEntries}
+
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	single := p.features&uringFeatSingleMmap != 0
+	if single && cqSize > sqSize {
+		sqSize = cqSize
+	}
+	var err error
+	r.sqRing, err = unix.Mmap(r.fd, uringOffSQRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap sq ring: %w", err)
+	}
+	if single {
+		r.cqRing = r.sqRing
+	} else {
+		r.cqRing, err = unix.Mmap(r.fd, uringOffCQRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+		if err != nil {
+			r.close()
+			return nil, fmt.Errorf("mmap cq ring: %w", err)
+		}
+	}
+	r.sqeMem, err = unix.Mmap(r.fd, uringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(uringSQE{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap sqes: %w", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+	return r, nil
+}
+
+// push queues a read request, it reports false when the submission queue is full.
+func (r *uring) push(fd int, buf []byte, off int64, userData uint64) bool {
+	head := atomic.LoadUint32(r.sqHead)
+	tail := atomic.LoadUint32(r.sqTail)
+	if tail-head >= r.entries {
+		return false
+	}
+	idx := tail & *r.sqMask
+	r.sqes[idx] = uringSQE{
+		opcode:   uringOpRead,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptrOf(buf)),
+		len:      uint32(len(buf)),
+		userData: userData,
+	}
+	r.sqArray[idx] = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	return true
+}
+
+// enter submits toSubmit queued requests and waits for at least minComplete completions.
+func (r *uring) enter(toSubmit, minComplete uint32) error {
+	for {
+		_, _, errno := unix.Syscall6(sysIoUringEnter, uintptr(r.fd), uintptr(toSubmit), uintptr(minComplete), uringEnterGetEvents, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		return nil
+	}
+}
+
+// reap calls fn for every available completion.
+func (r *uring) reap(fn func(cqe uringCQE)) {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	for ; head != tail; head++ {
+		fn(r.cqes[head&*r.cqMask])
+	}
+	atomic.StoreUint32(r.cqHead, head)
+}
+
+func (r *uring) close() {
+	if r.sqeMem != nil {
+		_ = unix.Munmap(r.sqeMem)
+	}
+	if r.cqRing != nil && len(r.cqRing) > 0 && &r.cqRing[0] != &r.sqRing[0] {
+		_ = unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		_ = unix.Munmap(r.sqRing)
+	}
+	_ = unix.Close(r.fd)
+}
+
+type uringFile struct {
+	mu        sync.Mutex
+	file      *os.File
+	ring      *uring
+	size      int64
+	alignment int
+}
+
+type uringChunk struct {
+	buf  []byte
+	off  int64
+	done int
+	eof  bool
+}
+
+func (f *uringFile) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var chunks []*uringChunk
+	for start := 0; start < len(p); start += uringChunkSize {
+		end := start + uringChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		chunks = append(chunks, &uringChunk{buf: p[start:end], off: off + int64(start)})
+	}
+
+	fd := int(f.file.Fd())
+	queue := make([]uint64, 0, len(chunks))
+	for i := range chunks {
+		queue = append(queue, uint64(i))
+	}
+	var firstErr error
+	inflight := uint32(0)
+	for len(queue) > 0 || inflight > 0 {
+		submitted := uint32(0)
+		for len(queue) > 0 && firstErr == nil {
+			c := chunks[queue[0]]
+			if !f.ring.push(fd, c.buf[c.done:], c.off+int64(c.done), queue[0]) {
+				break
+			}
+			queue = queue[1:]
+			submitted++
+		}
+		inflight += submitted
+		if inflight == 0 {
+			break
+		}
+		if err := f.ring.enter(submitted, 1); err != nil {
+			return 0, err
+		}
+		f.ring.reap(func(cqe uringCQE) {
+			inflight--
+			c := chunks[cqe.userData]
+			switch {
+			case cqe.res < 0:
+				if firstErr == nil {
+					firstErr = syscall.Errno(-cqe.res)
+				}
+			case cqe.res == 0:
+				c.eof = true
+			default:
+				c.done += int(cqe.res)
+				if c.done < len(c.buf) {
+					// 短读，继续读剩余部分
+					queue = append(queue, cqe.userData)
+				}
+			}
+		})
+	}
+	runtime.KeepAlive(p)
+	if firstErr != nil {
+		return 0, firstErr
+	}
+
+	n := 0
+	for _, c := range chunks {
+		n += c.done
+		if c.done < len(c.buf) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (f *uringFile) Size() int64 {
+	return f.size
+}
+
+func (f *uringFile) Alignment() int {
+	return f.alignment
+}
+
+func (f *uringFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ring != nil {
+		f.ring.close()
+		f.ring = nil
+	}
+	return f.file.Close()
+}
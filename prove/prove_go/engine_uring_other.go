@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package post_go
+
+// uringEngine is only available on linux.
+type uringEngine struct{}
+
+func (uringEngine) Name() string {
+	return EngineUring
+}
+
+func (uringEngine) Open(filename string) (EngineFile, error) {
+	return nil, ErrEngineUnsupported
+}
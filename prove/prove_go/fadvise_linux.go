@@ -0,0 +1,9 @@
+package post_go
+
+import "golang.org/x/sys/unix"
+
+// fadviseSequential tells the kernel the whole file will be read sequentially and soon.
+func fadviseSequential(fd int, size int64) {
+	_ = unix.Fadvise(fd, 0, size, unix.FADV_SEQUENTIAL)
+	_ = unix.Fadvise(fd, 0, size, unix.FADV_WILLNEED)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package post_go
+
+// fadviseSequential is a no-op where posix_fadvise is not available, madvise still applies.
+func fadviseSequential(fd int, size int64) {}
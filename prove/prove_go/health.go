@@ -0,0 +1,214 @@
+package post_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReadRetries is how often a failed read is retried unless set in ReaderOptions.
+	DefaultReadRetries = 3
+	// DefaultRetryBackoff is the wait before the first retry, it doubles with every further retry.
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// DefaultSlowFactor flags a file as slow when its throughput is below this share of the median.
+	DefaultSlowFactor = 0.5
+	// minSlowBytes is the amount a file needs to have read before its throughput is judged.
+	minSlowBytes = 4 * 1024 * 1024
+)
+
+// ReadError is a read of a post data file that failed after all retries. It names the labels that
+// could not be read.
+type ReadError struct {
+	// File is the path or name of the file, Offset and Length the failed range in it.
+	File   string `json:"file"`
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+	// FirstLabel and LastLabel are the global indexes of the first and last label of the range.
+	FirstLabel uint64 `json:"first_label"`
+	LastLabel  uint64 `json:"last_label"`
+	Attempts   int    `json:"attempts"`
+	Cause      string `json:"cause"`
+	Err        error  `json:"-"`
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("reading %s at %d (labels %d..%d) failed after %d attempts: %v",
+		e.File, e.Offset, e.FirstLabel, e.LastLabel, e.Attempts, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// FileHealth is the read accounting of one post data file.
+type FileHealth struct {
+	Index    int           `json:"index"`
+	File     string        `json:"file"`
+	Bytes    int64         `json:"bytes"`
+	Reads    int64         `json:"reads"`
+	Retries  int64         `json:"retries"`
+	Errors   int64         `json:"errors"`
+	ReadTime time.Duration `json:"read_time_ns"`
+	// BytesPerSec is the throughput while reading, Slow is set when it is well below the median.
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	Slow        bool    `json:"slow"`
+}
+
+// HealthReport is the state of the post data of a data dir as seen by the readers.
+type HealthReport struct {
+	DataDir string `json:"data_dir"`
+	// Layout are the problems of the files found, see shared.Layout.
+	Layout []shared.LayoutProblem `json:"layout,omitempty"`
+	Files  []FileHealth           `json:"files"`
+	// Errors are the failed reads, with the labels they affect.
+	Errors            []*ReadError `json:"errors,omitempty"`
+	MedianBytesPerSec float64      `json:"median_bytes_per_sec"`
+	// SlowFiles are the indexes of the files flagged as slow.
+	SlowFiles []int `json:"slow_files,omitempty"`
+}
+
+// Healthy reports whether all files are in place and were read without errors.
+func (r *HealthReport) Healthy() bool {
+	return len(r.Layout) == 0 && len(r.Errors) == 0
+}
+
+// WriteJSON writes the report as indented JSON to w.
+func (r *HealthReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// ReadMonitor collects the read accounting of every file read with it, set it in ReaderOptions.
+// It is safe for concurrent use.
+type ReadMonitor struct {
+	// SlowFactor is the share of the median throughput below which a file is slow, 0 means DefaultSlowFactor.
+	SlowFactor float64
+
+	mu     sync.Mutex
+	files  map[int]*FileHealth
+	errors []*ReadError
+}
+
+// NewReadMonitor returns an empty monitor.
+func NewReadMonitor() *ReadMonitor {
+	return &ReadMonitor{files: make(map[int]*FileHealth)}
+}
+
+// file returns the accounting of file index, created on first use.
+func (m *ReadMonitor) file(index int, name string) *FileHealth {
+	f, ok := m.files[index]
+	if !ok {
+		f = &FileHealth{Index: index, File: name}
+		m.files[index] = f
+	}
+	return f
+}
+
+// read records a read attempt of n bytes that took elapsed.
+func (m *ReadMonitor) read(index int, name string, n int, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.file(index, name)
+	f.Reads++
+	f.Bytes += int64(n)
+	f.ReadTime += elapsed
+	if err != nil {
+		f.Errors++
+	}
+}
+
+func (m *ReadMonitor) retry(index int, name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.file(index, name).Retries++
+}
+
+func (m *ReadMonitor) fail(err *ReadError) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, err)
+}
+
+// Report returns the accounting collected so far. Files are flagged as slow against the median
+// throughput of the files that read at least 4 MiB.
+func (m *ReadMonitor) Report() *HealthReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	report := &HealthReport{Errors: append([]*ReadError(nil), m.errors...)}
+	var rates []float64
+	for _, f := range m.files {
+		health := *f
+		if health.ReadTime > 0 {
+			health.BytesPerSec = float64(health.Bytes) / health.ReadTime.Seconds()
+		}
+		if health.Bytes >= minSlowBytes {
+			rates = append(rates, health.BytesPerSec)
+		}
+		report.Files = append(report.Files, health)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Index < report.Files[j].Index
+	})
+	if len(rates) == 0 {
+		return report
+	}
+	sort.Float64s(rates)
+	report.MedianBytesPerSec = rates[len(rates)/2]
+	factor := m.SlowFactor
+	if factor <= 0 {
+		factor = DefaultSlowFactor
+	}
+	for i := range report.Files {
+		f := &report.Files[i]
+		if f.Bytes >= minSlowBytes && f.BytesPerSec < report.MedianBytesPerSec*factor {
+			f.Slow = true
+			report.SlowFiles = append(report.SlowFiles, f.Index)
+		}
+	}
+	return report
+}
+
+// CheckDataDir reads all post data of datadir and reports layout problems, failed reads and slow
+// files. Unlike GenerateProof it does not stop at the first failed read.
+func CheckDataDir(datadir string, opts ReaderOptions) (*HealthReport, error) {
+	metadata, err := shared.ReadMetadata(datadir)
+	if err != nil {
+		return nil, fmt.Errorf("loading metadata: %w", err)
+	}
+	layout, err := shared.NewLayout(datadir, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("listing post data: %w", err)
+	}
+	monitor := opts.Monitor
+	if monitor == nil {
+		monitor = NewReadMonitor()
+		opts.Monitor = monitor
+	}
+	opts.SkipErrors = true
+	err = ReadDataWithOptions(datadir, BUNCH_SIZE, metadata.MaxFileSize, opts, func(batch *Batch) bool {
+		batch.Release()
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	report := monitor.Report()
+	report.DataDir = datadir
+	report.Layout = layout.Problems()
+	return report, nil
+}
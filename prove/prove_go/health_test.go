@@ -0,0 +1,151 @@
+package post_go
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyFile serves data with short reads, fails the first reads at every 4 KiB boundary and always
+// fails reads touching bad.
+type flakyFile struct {
+	data     []byte
+	failures int
+	bad      [2]int64
+
+	mu       sync.Mutex
+	attempts map[int64]int
+}
+
+var errFlaky = errors.New("flaky read")
+
+func (f *flakyFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off < f.bad[1] && off+int64(len(p)) > f.bad[0] {
+		return 0, errFlaky
+	}
+	if off%4096 == 0 {
+		f.attempts[off]++
+		if f.attempts[off] <= f.failures {
+			return 0, errFlaky
+		}
+	}
+	if off >= int64(len(f.data)) {
+		return 0, nil
+	}
+	// 每次最多返回一半，模拟短读
+	n := len(p)
+	if n > 32 {
+		n /= 2
+	}
+	return copy(p[:n], f.data[off:]), nil
+}
+
+func (f *flakyFile) Close() error   { return nil }
+func (f *flakyFile) Size() int64    { return int64(len(f.data)) }
+func (f *flakyFile) Alignment() int { return 1 }
+
+func TestReaderRetries(t *testing.T) {
+	data := make([]byte, 64*1024)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	file := &flakyFile{data: data, failures: 2, bad: [2]int64{-1, -1}, attempts: make(map[int64]int)}
+	monitor := NewReadMonitor()
+	reader := NewEngineBatchingReader(file, 0, 4096, uint64(len(data)))
+	reader.configure(ReaderOptions{Retries: 2, RetryBackoff: time.Microsecond, Monitor: monitor}, 0, "flaky")
+
+	var got []byte
+	for {
+		batch, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch == nil {
+			break
+		}
+		if len(batch.Data) != 4096 {
+			t.Fatalf("short batch of %d bytes at %d", len(batch.Data), batch.Pos)
+		}
+		got = append(got, batch.Data...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data differs")
+	}
+	report := monitor.Report()
+	if len(report.Files) != 1 || report.Files[0].Retries != 2*16 || report.Files[0].Bytes != int64(len(data)) {
+		t.Fatalf("accounting: %+v", report.Files)
+	}
+}
+
+func TestReaderReadError(t *testing.T) {
+	data := make([]byte, 16*1024)
+	file := &flakyFile{data: data, bad: [2]int64{5000, 5001}, attempts: make(map[int64]int)}
+	opts := ReaderOptions{Retries: 1, RetryBackoff: time.Microsecond, Monitor: NewReadMonitor()}
+
+	reader := NewEngineBatchingReader(file, 1<<20, 4096, uint64(len(data)))
+	reader.configure(opts, 1, "bad")
+	var readErr *ReadError
+	for {
+		_, err := reader.Next()
+		if errors.As(err, &readErr) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 失败的是文件内[4096, 8192)，全局位置从1MiB开始
+	if readErr.Offset != 4096 || readErr.FirstLabel != (1<<20+4096)/16 || readErr.LastLabel != (1<<20+8191)/16 ||
+		readErr.Attempts != 2 || !errors.Is(readErr, errFlaky) {
+		t.Fatalf("read error: %+v", readErr)
+	}
+
+	opts.SkipErrors = true
+	reader = NewEngineBatchingReader(file, 0, 4096, uint64(len(data)))
+	reader.configure(opts, 0, "bad")
+	batches := 0
+	for {
+		batch, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch == nil {
+			break
+		}
+		batches++
+	}
+	if report := opts.Monitor.Report(); batches != 3 || len(report.Errors) != 2 || report.Healthy() {
+		t.Fatalf("%d batches, report %+v", batches, report)
+	}
+}
+
+func TestReadMonitorSlowFiles(t *testing.T) {
+	monitor := NewReadMonitor()
+	for index, elapsed := range []time.Duration{time.Second, time.Second, 3 * time.Second, time.Second} {
+		monitor.read(index, "file", 64<<20, elapsed, nil)
+	}
+	// 读取量太少的文件不参与判断
+	monitor.read(4, "small", 1024, time.Second, nil)
+	report := monitor.Report()
+	if len(report.SlowFiles) != 1 || report.SlowFiles[0] != 2 || !report.Files[2].Slow {
+		t.Fatalf("slow files: %v", report.SlowFiles)
+	}
+	if report.MedianBytesPerSec != 64<<20 {
+		t.Fatalf("median %v", report.MedianBytesPerSec)
+	}
+}
+
+func TestCheckDataDir(t *testing.T) {
+	dir, _ := newTestPost(t, 2, 4096, 48*1024)
+	report, err := CheckDataDir(dir, ReaderOptions{Engine: EngineBuffered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Healthy() || len(report.Files) != 3 || report.Files[2].Bytes != 32*1024 {
+		t.Fatalf("report: %+v", report)
+	}
+}
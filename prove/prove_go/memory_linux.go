@@ -0,0 +1,30 @@
+package post_go
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// availableMemory returns the memory the kernel can hand out without swapping, MemAvailable of /proc/meminfo.
+func availableMemory() (uint64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
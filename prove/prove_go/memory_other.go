@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package post_go
+
+// availableMemory is not known on this platform.
+func availableMemory() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,255 @@
+package post_go
+
+import (
+	"fmt"
+	"github.com/trying2016/post-go/prove/prove_go/aesscan"
+	"github.com/trying2016/post-go/shared"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPlanNonces is the widest nonce range a Planner chooses unless set otherwise.
+const DefaultMaxPlanNonces = 1024
+
+// Measurements are the throughputs a Planner bases its predictions on.
+type Measurements struct {
+	// DiskBytesPerSec is the read throughput of the data dir.
+	DiskBytesPerSec float64
+	// AESBytesPerSec is the number of label bytes one thread checks against one nonce group per second.
+	AESBytesPerSec float64
+	// PowPerGroup is the time needed for the k2pow of one nonce group.
+	PowPerGroup time.Duration
+}
+
+// Plan is the choice of nonces and threads for the next pass over the data.
+type Plan struct {
+	Nonces  uint32
+	Threads int
+	// PassTime is the predicted duration of a pass, including the pows of the nonce groups.
+	PassTime time.Duration
+	// PassProbability is the probability that a single pass finds a proof.
+	PassProbability float64
+	// Probability is the probability of finding a proof before the deadline when every pass uses this plan.
+	Probability float64
+}
+
+func (p Plan) String() string {
+	return fmt.Sprintf("nonces %d threads %d pass %v pass probability %.4f probability %.4f",
+		p.Nonces, p.Threads, p.PassTime.Round(time.Millisecond), p.PassProbability, p.Probability)
+}
+
+// Planner chooses nonces and thread counts maximizing the probability of finding a proof before a
+// deadline. The number of labels below the proving difficulty for a nonce is Poisson distributed with
+// a mean of about K1, a nonce is a proof once it has K2 of them.
+type Planner struct {
+	numLabels uint64
+	k2        uint32
+	// lambda 单个nonce扫完全部数据时满足难度的label数的期望
+	lambda     float64
+	maxNonces  uint32
+	maxThreads int
+
+	mu sync.Mutex
+	m  Measurements
+	// correction 实际耗时与预测耗时之比，每轮结束后更新
+	correction float64
+}
+
+// NewPlanner returns a planner for numLabels labels. maxThreads caps the thread count, 0 means the
+// number of CPUs.
+func NewPlanner(numLabels uint64, K1, K2 uint32, m Measurements, maxThreads int) (*Planner, error) {
+	if numLabels <= uint64(K1) {
+		return nil, fmt.Errorf("number of labels (%d) must be bigger than k1 (%d)", numLabels, K1)
+	}
+	if m.DiskBytesPerSec <= 0 || m.AESBytesPerSec <= 0 {
+		return nil, fmt.Errorf("invalid measurements; expected: positive throughputs, given: disk %v aes %v", m.DiskBytesPerSec, m.AESBytesPerSec)
+	}
+	if maxThreads <= 0 {
+		maxThreads = runtime.NumCPU()
+	}
+	difficulty := shared.ProvingDifficulty(numLabels, 1, K1)
+	return &Planner{
+		numLabels:  numLabels,
+		k2:         K2,
+		lambda:     float64(difficulty) / math.Pow(2, 64) * float64(numLabels),
+		maxNonces:  DefaultMaxPlanNonces,
+		maxThreads: maxThreads,
+		m:          m,
+		correction: 1,
+	}, nil
+}
+
+// SetMaxNonces caps the nonces of a plan, it is rounded down to a multiple of 16.
+func (p *Planner) SetMaxNonces(nonces uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.maxNonces = nonces / NONCES_PER_AES * NONCES_PER_AES
+	if p.maxNonces < NONCES_PER_AES {
+		p.maxNonces = NONCES_PER_AES
+	}
+}
+
+// NonceProbability returns the probability that a single nonce is a proof after scanning fraction of
+// the data.
+func (p *Planner) NonceProbability(fraction float64) float64 {
+	return poissonTail(p.lambda*fraction, p.k2)
+}
+
+// PassTime predicts the duration of a pass with the given nonces and threads.
+func (p *Planner) PassTime(nonces uint32, threads int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.passTime(nonces, threads)
+}
+
+func (p *Planner) passTime(nonces uint32, threads int) time.Duration {
+	bytes := float64(p.numLabels) * LABEL_SIZE
+	groups := float64((nonces + NONCES_PER_AES - 1) / NONCES_PER_AES)
+	disk := bytes / p.m.DiskBytesPerSec
+	cpu := bytes * groups / (p.m.AESBytesPerSec * float64(threads))
+	seconds := math.Max(disk, cpu) * p.correction
+	return time.Duration(seconds*float64(time.Second)) + time.Duration(groups)*p.m.PowPerGroup
+}
+
+// Plan returns the plan with the highest probability of finding a proof within remaining. Among plans
+// with the same probability it prefers fewer threads and then shorter passes.
+func (p *Planner) Plan(remaining time.Duration) Plan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best Plan
+	q := poissonTail(p.lambda, p.k2)
+	for nonces := uint32(NONCES_PER_AES); nonces <= p.maxNonces; nonces += NONCES_PER_AES {
+		passProbability := 1 - math.Pow(1-q, float64(nonces))
+		for threads := 1; threads <= p.maxThreads; threads++ {
+			passTime := p.passTime(nonces, threads)
+			plan := Plan{
+				Nonces:          nonces,
+				Threads:         threads,
+				PassTime:        passTime,
+				PassProbability: passProbability,
+				Probability:     p.probability(nonces, passTime, passProbability, remaining),
+			}
+			if best.Nonces == 0 || better(plan, best) {
+				best = plan
+			}
+		}
+	}
+	return best
+}
+
+// better reports whether a is preferable to b.
+func better(a, b Plan) bool {
+	const epsilon = 1e-9
+	switch {
+	case a.Probability > b.Probability+epsilon:
+		return true
+	case a.Probability < b.Probability-epsilon:
+		return false
+	case a.Probability == 0 && a.PassProbability != b.PassProbability:
+		// 截止时间内无法完成，选择单轮成功率最高的
+		return a.PassProbability > b.PassProbability
+	case a.Threads != b.Threads:
+		return a.Threads < b.Threads
+	default:
+		return a.PassTime < b.PassTime
+	}
+}
+
+// probability returns the chance of finding a proof within remaining: every complete pass succeeds
+// with passProbability, the pass cut off by the deadline with the chance of its scanned fraction.
+func (p *Planner) probability(nonces uint32, passTime time.Duration, passProbability float64, remaining time.Duration) float64 {
+	if remaining <= 0 || passTime <= 0 {
+		return 0
+	}
+	passes := math.Floor(float64(remaining) / float64(passTime))
+	fraction := float64(remaining)/float64(passTime) - passes
+	partial := 1 - math.Pow(1-p.NonceProbability(fraction), float64(nonces))
+	return 1 - math.Pow(1-passProbability, passes)*(1-partial)
+}
+
+// Observe corrects the predictions with the measured duration of a pass, without the pows.
+func (p *Planner) Observe(nonces uint32, threads int, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	saved := p.correction
+	p.correction = 1
+	predicted := p.passTime(nonces, threads) - time.Duration((nonces+NONCES_PER_AES-1)/NONCES_PER_AES)*p.m.PowPerGroup
+	p.correction = saved
+	if predicted <= 0 || elapsed <= 0 {
+		return
+	}
+	// 平滑，避免单轮的抖动
+	p.correction = (p.correction + float64(elapsed)/float64(predicted)) / 2
+}
+
+// ObservePow records the time the pows of groups nonce groups took.
+func (p *Planner) ObservePow(groups int, elapsed time.Duration) {
+	if groups <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.m.PowPerGroup = elapsed / time.Duration(groups)
+}
+
+// poissonTail returns P(X >= k) for X Poisson distributed with mean lambda.
+func poissonTail(lambda float64, k uint32) float64 {
+	if lambda <= 0 {
+		if k == 0 {
+			return 1
+		}
+		return 0
+	}
+	term := math.Exp(-lambda)
+	cdf := 0.0
+	for i := uint32(0); i < k; i++ {
+		cdf += term
+		term *= lambda / float64(i+1)
+	}
+	if cdf > 1 {
+		cdf = 1
+	}
+	return 1 - cdf
+}
+
+// aesMeasureTime is how long MeasureAES encrypts.
+const aesMeasureTime = 50 * time.Millisecond
+
+// MeasureAES returns the bytes one thread checks against one nonce group per second with the kernel
+// the prover uses on this CPU.
+func MeasureAES() float64 {
+	buf := make([]byte, proveChunkSize)
+	cipher, _ := aesscan.NewCipher(make([]byte, KEY_SIZE))
+	var offsets []uint32
+	out := make([]byte, len(buf))
+	start := time.Now()
+	bytes := 0
+	for time.Since(start) < aesMeasureTime {
+		if aesscan.Accelerated() {
+			offsets = cipher.Scan(buf, 0, offsets[:0])
+		} else {
+			cipher.Encrypt(out, buf)
+		}
+		bytes += len(buf)
+	}
+	return float64(bytes) / time.Since(start).Seconds()
+}
+
+// MeasureDisk returns the read throughput of the first post data file of datadir with the given engine.
+func MeasureDisk(datadir, engineName string) (float64, error) {
+	engine, err := openEngine(datadir, engineName)
+	if err != nil {
+		return 0, err
+	}
+	files, err := shared.ListInitFiles(datadir)
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no post data files in %s", datadir)
+	}
+	return probeEngine(engine, files[0].Path, 0)
+}
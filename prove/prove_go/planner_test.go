@@ -0,0 +1,137 @@
+package post_go
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPoissonTail(t *testing.T) {
+	if p := poissonTail(26, 0); p != 1 {
+		t.Fatalf("P(X >= 0) = %v", p)
+	}
+	// 直接求和 P(X >= 37) 作为对照
+	sum := 0.0
+	for i := 37; i < 200; i++ {
+		lg, _ := math.Lgamma(float64(i + 1))
+		sum += math.Exp(float64(i)*math.Log(26) - 26 - lg)
+	}
+	if p := poissonTail(26, 37); math.Abs(p-sum) > 1e-9 {
+		t.Fatalf("P(X >= 37) = %v, expected %v", p, sum)
+	}
+}
+
+func TestPlanner(t *testing.T) {
+	const numLabels = 1 << 32
+	// 64GiB数据，磁盘 1GiB/s，单线程单组 2GiB/s
+	m := Measurements{DiskBytesPerSec: 1 << 30, AESBytesPerSec: 2 << 30}
+	planner, err := NewPlanner(numLabels, shared.K1, shared.K2, m, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := planner.Plan(24 * time.Hour)
+	if long.Probability < 0.999 {
+		t.Fatalf("a day should be plenty: %v", long)
+	}
+	short := planner.Plan(2 * time.Minute)
+	if short.Probability >= long.Probability {
+		t.Fatalf("less time can not be more likely: %v vs %v", short, long)
+	}
+	// 只够扫一遍时应当增加nonce以提高单轮成功率
+	if short.Nonces <= NONCES_PER_AES {
+		t.Fatalf("expected a wider nonce range for a single pass: %v", short)
+	}
+	// 磁盘瓶颈下多余的线程没有意义
+	if need := int(math.Ceil(float64(short.Nonces/NONCES_PER_AES) * m.DiskBytesPerSec / m.AESBytesPerSec)); short.Threads > need {
+		t.Fatalf("plan uses %d threads, %d keep up with the disk: %v", short.Threads, need, short)
+	}
+
+	// 实际比预测慢一倍后，同样的时间成功率下降
+	planner.Observe(short.Nonces, short.Threads, 2*planner.PassTime(short.Nonces, short.Threads))
+	if replan := planner.Plan(2 * time.Minute); replan.Probability >= short.Probability {
+		t.Fatalf("slower passes should lower the probability: %v vs %v", replan, short)
+	}
+}
+
+func TestGenerateProofDeadline(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, 64*1024)
+	challenge := sha256.Sum256([]byte("deadline"))
+	planner, err := NewPlanner(numUnits*labelsPerUnit, shared.K1, shared.K2, Measurements{DiskBytesPerSec: 1 << 30, AESBytesPerSec: 1 << 30}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planner.SetMaxNonces(64)
+
+	var plans []Plan
+	proof, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 2,
+		WithReaderOptions(ReaderOptions{Engine: EngineBuffered}), WithCheckpoint(0),
+		WithPlanner(planner), WithDeadline(time.Now().Add(time.Second), func(plan Plan) {
+			plans = append(plans, plan)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProof(t, proof, challenge[:], labels, numUnits*labelsPerUnit, shared.K1, shared.K2)
+	if len(plans) == 0 || plans[0].Nonces > 64 || plans[0].Probability <= 0 {
+		t.Fatalf("unexpected plans %v", plans)
+	}
+}
+
+func TestGenerateProofChangingRounds(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+		numLabels     = numUnits * labelsPerUnit
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, 64*1024)
+	metadata, err := shared.ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第一轮16个nonce都找不到，证明只能来自之后的轮次
+	var challenge [32]byte
+	found := false
+	for i := 0; i < 100 && !found; i++ {
+		challenge = sha256.Sum256([]byte(fmt.Sprintf("changing rounds %d", i)))
+		found = true
+		for _, count := range nonceCounts(t, challenge[:], metadata.NodeId, labels, numLabels, shared.K1, 0, 16, 0) {
+			if count >= int(shared.K2) {
+				found = false
+			}
+		}
+	}
+	if !found {
+		t.Skip("no challenge failing the first round")
+	}
+
+	planner, err := NewPlanner(numLabels, shared.K1, shared.K2, Measurements{DiskBytesPerSec: 1 << 30, AESBytesPerSec: 1 << 30}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 截止时间已过时planner选择最多的nonce，第一轮16个，之后每轮32个，从第二轮起起点都不是32的倍数
+	planner.SetMaxNonces(16)
+	var plans []uint32
+	proof, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 1,
+		WithReaderOptions(ReaderOptions{Engine: EngineBuffered}),
+		WithPlanner(planner), WithDeadline(time.Now().Add(-time.Second), func(plan Plan) {
+			plans = append(plans, plan.Nonces)
+			planner.SetMaxNonces(32)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Nonce < 16 || len(plans) < 2 || plans[0] != 16 || plans[1] != 32 {
+		t.Fatalf("proof of nonce %d with rounds %v", proof.Nonce, plans)
+	}
+	checkProof(t, proof, challenge[:], labels, numLabels, shared.K1, shared.K2)
+}
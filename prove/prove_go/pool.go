@@ -0,0 +1,55 @@
+package post_go
+
+// BufferPool is a fixed set of aligned batch buffers shared by the readers and the provers.
+// Readers block in Get until a prover releases a buffer, so the memory used by a scan is
+// bounded by the number of buffers times their size no matter how fast the disks are.
+type BufferPool struct {
+	free      chan []byte
+	size      int
+	alignment int
+}
+
+// NewBufferPool allocates count buffers of size bytes aligned to alignment.
+func NewBufferPool(count, size, alignment int) *BufferPool {
+	if count < 1 {
+		count = 1
+	}
+	p := &BufferPool{
+		free:      make(chan []byte, count),
+		size:      size,
+		alignment: alignment,
+	}
+	for i := 0; i < count; i++ {
+		p.free <- alignedBuffer(size, alignment)
+	}
+	return p
+}
+
+// Get takes a buffer out of the pool, waiting for one to be released if all of them are in use.
+func (p *BufferPool) Get() []byte {
+	return <-p.free
+}
+
+// Put returns a buffer taken with Get.
+func (p *BufferPool) Put(buf []byte) {
+	select {
+	case p.free <- buf[:cap(buf)]:
+	default:
+		// 不属于该池的缓冲区，直接丢弃
+	}
+}
+
+// Size returns the size of a single buffer.
+func (p *BufferPool) Size() int {
+	return p.size
+}
+
+// Cap returns the number of buffers owned by the pool.
+func (p *BufferPool) Cap() int {
+	return cap(p.free)
+}
+
+// Available returns the number of buffers not in use.
+func (p *BufferPool) Available() int {
+	return len(p.free)
+}
@@ -0,0 +1,301 @@
+package post_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// RandomXFastMemory is the memory RandomX allocates in fast mode, dataset and cache.
+	RandomXFastMemory = (2080 + 256) * shared.MiB
+	// RandomXLightMemory is the memory RandomX allocates in light mode, the cache only.
+	RandomXLightMemory = 256 * shared.MiB
+)
+
+// PreflightStatus is the outcome of a PreflightCheck.
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "pass"
+	// PreflightWarn is a check that could not be done or found something that does not stop proving.
+	PreflightWarn PreflightStatus = "warn"
+	PreflightFail PreflightStatus = "fail"
+)
+
+// PreflightCheck is one check of Preflight. Hint says how to fix a failed check.
+type PreflightCheck struct {
+	Name    string          `json:"name"`
+	Status  PreflightStatus `json:"status"`
+	Message string          `json:"message"`
+	Hint    string          `json:"hint,omitempty"`
+}
+
+func (c PreflightCheck) String() string {
+	s := fmt.Sprintf("[%s] %s: %s", c.Status, c.Name, c.Message)
+	if c.Hint != "" && c.Status != PreflightPass {
+		s += " (" + c.Hint + ")"
+	}
+	return s
+}
+
+// PreflightReport lists the checks Preflight ran.
+type PreflightReport struct {
+	DataDir string           `json:"data_dir"`
+	Checks  []PreflightCheck `json:"checks"`
+}
+
+func (r *PreflightReport) add(name string, status PreflightStatus, hint string, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// Failed returns the failed checks.
+func (r *PreflightReport) Failed() []PreflightCheck {
+	var failed []PreflightCheck
+	for _, c := range r.Checks {
+		if c.Status == PreflightFail {
+			failed = append(failed, c)
+		}
+	}
+	return failed
+}
+
+// OK reports whether no check failed.
+func (r *PreflightReport) OK() bool {
+	return len(r.Failed()) == 0
+}
+
+// Err returns a *PreflightError when a check failed, nil otherwise.
+func (r *PreflightReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return &PreflightError{Report: r}
+}
+
+func (r *PreflightReport) String() string {
+	lines := make([]string, 0, len(r.Checks))
+	for _, c := range r.Checks {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WriteJSON writes the report as indented JSON to w.
+func (r *PreflightReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// PreflightError is returned for a data dir that failed the preflight, proving it would be wasted time.
+type PreflightError struct {
+	Report *PreflightReport
+}
+
+func (e *PreflightError) Error() string {
+	failed := e.Report.Failed()
+	checks := make([]string, 0, len(failed))
+	for _, c := range failed {
+		checks = append(checks, c.String())
+	}
+	return fmt.Sprintf("preflight of %s failed: %s", e.Report.DataDir, strings.Join(checks, "; "))
+}
+
+// PreflightOptions are the resources a proof will use.
+type PreflightOptions struct {
+	// Reader are the reader options of the proof, its buffers are part of the memory needed.
+	Reader ReaderOptions
+	// BatchSize is the size of a buffer, 0 means BUNCH_SIZE.
+	BatchSize int
+	// RandomXMemory is the memory RandomX still has to allocate, 0 when it is initialized already.
+	RandomXMemory uint64
+	// DataDirOnly is set for provers that read only the data dir and not the manifest, like the Rust prover.
+	DataDirOnly bool
+}
+
+// Preflight checks in seconds what would otherwise only show after hours of scanning as a proof that is
+// not found or does not verify: the challenge and PoW difficulty, the metadata, that the files hold
+// exactly NumUnits*LabelsPerUnit labels without gaps or temp files, that key.bin holds the key of
+// NodeId, that there is memory for RandomX and the read buffers, and that every file can be read. An
+// encrypted key.json can only be matched by the NodeId it records and is reported as a warning. The
+// error of the report is set with Err.
+func Preflight(dataDir string, challenge, powDifficulty []byte, opts PreflightOptions) *PreflightReport {
+	report := &PreflightReport{DataDir: dataDir}
+
+	if len(challenge) != 32 {
+		report.add("challenge", PreflightFail, "pass the 32 byte challenge of the current PoET round",
+			"invalid length; expected: 32, given: %d", len(challenge))
+	} else {
+		report.add("challenge", PreflightPass, "", "32 bytes")
+	}
+	if len(powDifficulty) != 32 {
+		report.add("pow difficulty", PreflightFail, "pass the 32 byte difficulty of the network config",
+			"invalid length; expected: 32, given: %d", len(powDifficulty))
+	} else if isZero(powDifficulty) {
+		report.add("pow difficulty", PreflightFail, "pass the difficulty of the network config",
+			"all zero, no nonce can satisfy it")
+	} else {
+		report.add("pow difficulty", PreflightPass, "", "%x", powDifficulty)
+	}
+
+	metadata, err := shared.ReadMetadata(dataDir)
+	if err != nil {
+		report.add("metadata", PreflightFail, "restore "+shared.MetadataName+" or import it with `postgo metadata -import`", "%v", err)
+		preflightMemory(report, opts)
+		return report
+	}
+	report.add("metadata", PreflightPass, "", "node %x, %d units of %d labels", metadata.NodeId, metadata.NumUnits, metadata.LabelsPerUnit)
+
+	if opts.DataDirOnly {
+		if err := shared.CheckNoManifest(dataDir); err != nil {
+			report.add("manifest", PreflightFail, "move the files back with `postgo move` or use the go prover", "%v", err)
+		}
+	}
+	layout, err := shared.NewLayout(dataDir, metadata)
+	if err != nil {
+		report.add("layout", PreflightFail, "check the data dir and the directories of its manifest", "%v", err)
+	} else {
+		preflightLayout(report, layout)
+	}
+	preflightKey(report, dataDir, metadata)
+	preflightMemory(report, opts)
+	if layout != nil {
+		preflightDevices(report, layout)
+	}
+	return report
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func preflightLayout(report *PreflightReport, layout *shared.Layout) {
+	var size uint64
+	for _, file := range layout.Files {
+		size += uint64(file.Size)
+	}
+	labels := size / shared.LabelLength
+	problems := layout.Problems()
+	if len(problems) == 0 {
+		report.add("layout", PreflightPass, "", "%d files hold all %d labels", len(layout.Files), layout.NumLabels)
+		return
+	}
+	kinds := make(map[shared.LayoutProblemKind]bool)
+	list := make([]string, 0, len(problems))
+	for _, p := range problems {
+		kinds[p.Kind] = true
+		list = append(list, p.String())
+	}
+	var hints []string
+	if kinds[shared.LayoutMissingFile] || kinds[shared.LayoutSizeMismatch] {
+		hints = append(hints, "finish or redo the init of the missing and short files")
+	}
+	if kinds[shared.LayoutTempFile] {
+		hints = append(hints, "let the init finish or remove its .dtmp files")
+	}
+	if kinds[shared.LayoutExtraFile] {
+		hints = append(hints, "remove the files beyond the metadata or fix NumUnits")
+	}
+	if kinds[shared.LayoutShadowedFile] {
+		hints = append(hints, "finish the interrupted `postgo move`")
+	}
+	report.add("layout", PreflightFail, strings.Join(hints, ", "),
+		"%d of %d labels present: %s", labels, layout.NumLabels, strings.Join(list, ", "))
+}
+
+func preflightKey(report *PreflightReport, dataDir string, metadata *shared.PostMetadata) {
+	// 加密的key没有密码无法验证私钥，只能比较文件中记录的NodeId
+	if _, err := os.Stat(filepath.Join(dataDir, shared.EncryptedKeyName)); err == nil {
+		nodeId, err := shared.KeyNodeId(dataDir)
+		if err != nil {
+			report.add("key", PreflightFail, "restore the key or import it with `postgo key -import`", "%v", err)
+			return
+		}
+		if !bytes.Equal(nodeId, metadata.NodeId) {
+			report.add("key", PreflightFail, "the data was initialized for another node, use the key of the init",
+				"key of node %x does not belong to node %x", nodeId, metadata.NodeId)
+			return
+		}
+		report.add("key", PreflightWarn, "", "%s is encrypted and records node %x, the key itself is not verified without its passphrase",
+			shared.EncryptedKeyName, nodeId)
+		return
+	} else if !errors.Is(err, os.ErrNotExist) {
+		report.add("key", PreflightFail, "make the key readable", "%v", err)
+		return
+	}
+
+	key, err := shared.ReadPrivateKey(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		report.add("key", PreflightWarn, "", "no %s or %s in the data dir, not checked", shared.KeyName, shared.EncryptedKeyName)
+		return
+	}
+	if err != nil {
+		report.add("key", PreflightFail, "restore the key or import it with `postgo key -import`", "%v", err)
+		return
+	}
+	// 用私钥签名并以NodeId验证，私钥中附带的公钥不可信
+	if !shared.CheckPrivate(key, metadata.NodeId) {
+		report.add("key", PreflightFail, "the data was initialized for another node, use the key of the init",
+			"%s does not hold the key of node %x", shared.KeyName, metadata.NodeId)
+		return
+	}
+	report.add("key", PreflightPass, "", "key belongs to node %x", metadata.NodeId)
+}
+
+func preflightMemory(report *PreflightReport, opts PreflightOptions) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = BUNCH_SIZE
+	}
+	needed := opts.RandomXMemory + uint64(opts.Reader.Buffers)*uint64(batchSize)
+	available, ok := availableMemory()
+	if !ok {
+		report.add("memory", PreflightWarn, "", "%d MiB needed, available memory unknown", needed/shared.MiB)
+		return
+	}
+	if needed > available {
+		report.add("memory", PreflightFail, "free memory, use fewer reader buffers or RandomX light mode",
+			"%d MiB needed, %d MiB available", needed/shared.MiB, available/shared.MiB)
+		return
+	}
+	report.add("memory", PreflightPass, "", "%d MiB needed, %d MiB available", needed/shared.MiB, available/shared.MiB)
+}
+
+// preflightDevices reads the first label of every file, catching unmounted disks and permission problems.
+func preflightDevices(report *PreflightReport, layout *shared.Layout) {
+	devices := make(map[uint64]bool)
+	var failed []string
+	label := make([]byte, shared.LabelLength)
+	for _, file := range layout.Files {
+		f, err := os.Open(file.Path)
+		if err == nil {
+			if file.Size >= shared.LabelLength {
+				_, err = f.ReadAt(label, 0)
+			}
+			_ = f.Close()
+		}
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		devices[deviceID(file.Path)] = true
+	}
+	if len(failed) > 0 {
+		report.add("devices", PreflightFail, "check that all disks are mounted and readable",
+			"%d of %d files unreadable: %s", len(failed), len(layout.Files), strings.Join(failed, ", "))
+		return
+	}
+	report.add("devices", PreflightPass, "", "%d files on %d devices readable", len(layout.Files), len(devices))
+}
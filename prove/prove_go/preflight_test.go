@@ -0,0 +1,118 @@
+package post_go
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/trying2016/post-go/shared"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	dir, _ := newTestPost(t, 2, 4096, 16*1024)
+	challenge := make([]byte, 32)
+
+	report := Preflight(dir, challenge, TestNetPowDifficulty, PreflightOptions{Reader: ReaderOptions{Buffers: 4}})
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其他节点的key、缺失的文件、残留的临时文件以及错误的challenge
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, shared.KeyName), key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, shared.InitFileName(1))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, shared.InitFileTmpName(1)), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	report = Preflight(dir, challenge[:16], TestNetPowDifficulty, PreflightOptions{})
+	failed := make(map[string]bool)
+	for _, c := range report.Failed() {
+		failed[c.Name] = true
+		if c.Hint == "" {
+			t.Errorf("failed check without hint: %v", c)
+		}
+	}
+	for _, name := range []string{"challenge", "layout", "key"} {
+		if !failed[name] {
+			t.Errorf("%s check did not fail:\n%v", name, report)
+		}
+	}
+	var preflightErr *PreflightError
+	if err := report.Err(); !errors.As(err, &preflightErr) {
+		t.Fatalf("expected a PreflightError, got %v", err)
+	}
+}
+
+func TestPreflightDataDirOnly(t *testing.T) {
+	dir, _ := newTestPost(t, 2, 4096, 16*1024)
+	challenge := make([]byte, 32)
+	if err := shared.MoveInitFile(dir, 1, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Preflight(dir, challenge, TestNetPowDifficulty, PreflightOptions{}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	report := Preflight(dir, challenge, TestNetPowDifficulty, PreflightOptions{DataDirOnly: true})
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Name != "manifest" {
+		t.Fatalf("expected the manifest check to fail:\n%v", report)
+	}
+}
+
+func TestPreflightKeyVerified(t *testing.T) {
+	dir, _ := newTestPost(t, 2, 4096, 16*1024)
+	challenge := make([]byte, 32)
+	id, err := shared.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := shared.ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.NodeId = id.NodeId()
+	if err := shared.WriteMetadata(dir, metadata); err != nil {
+		t.Fatal(err)
+	}
+	keyStatus := func() PreflightStatus {
+		for _, c := range Preflight(dir, challenge, TestNetPowDifficulty, PreflightOptions{}).Checks {
+			if c.Name == "key" {
+				return c.Status
+			}
+		}
+		t.Fatal("no key check")
+		return ""
+	}
+
+	if err := shared.SaveIdentity(dir, id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if status := keyStatus(); status != PreflightPass {
+		t.Fatalf("key of the node: %s", status)
+	}
+
+	// 其他节点的私钥配上本节点的公钥
+	other, _ := shared.GenerateIdentity()
+	forged := append(other.PrivateKey()[:32], id.NodeId()...)
+	if err := os.WriteFile(filepath.Join(dir, shared.KeyName), forged, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if status := keyStatus(); status != PreflightFail {
+		t.Fatalf("forged key: %s", status)
+	}
+
+	// 加密的key没有密码无法验证
+	if err := shared.SaveIdentity(dir, id, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if status := keyStatus(); status != PreflightWarn {
+		t.Fatalf("encrypted key: %s", status)
+	}
+}
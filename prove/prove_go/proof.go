@@ -5,15 +5,169 @@ import (
 	"errors"
 	"fmt"
 	"github.com/trying2016/post-go/shared"
+	"github.com/trying2016/post-go/store"
+	"log"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func GenerateProof(dataDir string, challenge []byte, nonces, K1, K2 uint32, powDifficulty []byte, thread int32) (*shared.Proof, error) {
-	metadata, err := shared.ReadMetadata(dataDir)
+// DefaultProofBuffers is the number of pooled batch buffers used by GenerateProof unless set with WithReaderOptions.
+const DefaultProofBuffers = 32
+
+type proofOption struct {
+	reader     ReaderOptions
+	batchSize  int
+	throttle   *Throttle
+	checkpoint time.Duration
+	lookahead  bool
+	deadline   time.Time
+	planner    *Planner
+	report     func(Plan)
+	store      store.LabelStore
+	config     shared.Config
+	initOpts   shared.InitOpts
+	checks     []shared.CompatibilityOptionFunc
+}
+
+// ProofOptionFunc is a function that sets an option for GenerateProof.
+type ProofOptionFunc func(*proofOption) error
+
+// WithReaderOptions sets how the post data files are read while scanning.
+func WithReaderOptions(opts ReaderOptions) ProofOptionFunc {
+	return func(o *proofOption) error {
+		if opts.Parallel < 0 || opts.PerDevice < 0 {
+			return fmt.Errorf("invalid reader options; expected: >= 0, given: parallel %d, per device %d", opts.Parallel, opts.PerDevice)
+		}
+		o.reader = opts
+		return nil
+	}
+}
+
+// WithThrottle caps disk read rate and prover threads with throttle. The profile of the throttle can be
+// changed while the proof is generated.
+func WithThrottle(throttle *Throttle) ProofOptionFunc {
+	return func(o *proofOption) error {
+		o.throttle = throttle
+		return nil
+	}
+}
+
+// WithCheckpoint sets how often the scan progress is saved to CheckpointName in the data dir, 0, the
+// default, disables checkpoints. A later GenerateProof with the same challenge resumes from the saved
+// progress, a different challenge discards it.
+func WithCheckpoint(interval time.Duration) ProofOptionFunc {
+	return func(o *proofOption) error {
+		if interval < 0 {
+			return fmt.Errorf("invalid checkpoint interval; expected: >= 0, given: %v", interval)
+		}
+		o.checkpoint = interval
+		return nil
+	}
+}
+
+// WithLookahead also scans the following nonce range in the same pass while the provers keep up with the
+// disk. The next range is only proven for batches read while the batch queue is nearly empty, so it costs
+// no read throughput; a proof found there saves a whole extra pass over the data.
+func WithLookahead(enabled bool) ProofOptionFunc {
+	return func(o *proofOption) error {
+		o.lookahead = enabled
+		return nil
+	}
+}
+
+// WithDeadline lets a Planner choose nonces and threads of every pass, maximizing the probability of
+// finding a proof before deadline. The nonces and thread arguments of GenerateProof are ignored, the
+// thread count stays capped by thread. report, if not nil, is called with the plan of every pass,
+// otherwise the plan is logged.
+func WithDeadline(deadline time.Time, report func(Plan)) ProofOptionFunc {
+	return func(o *proofOption) error {
+		if deadline.IsZero() {
+			return errors.New("invalid deadline; expected: non-zero time")
+		}
+		o.deadline = deadline
+		o.report = report
+		return nil
+	}
+}
+
+// WithPlanner sets the planner used with WithDeadline, instead of one based on throughputs measured
+// when GenerateProof starts.
+func WithPlanner(planner *Planner) ProofOptionFunc {
+	return func(o *proofOption) error {
+		o.planner = planner
+		return nil
+	}
+}
+
+// WithStore reads the metadata and post data from s instead of the data dir. Checkpoints are still
+// saved to the data dir, an empty data dir disables them.
+func WithStore(s store.LabelStore) ProofOptionFunc {
+	return func(o *proofOption) error {
+		if s == nil {
+			return errors.New("invalid store; expected: non-nil")
+		}
+		o.store = s
+		return nil
+	}
+}
+
+// WithExpectedConfig makes GenerateProof fail with a *shared.CompatibilityError when the data dir was not
+// initialized with cfg, opts and checks, zero values are not compared. See shared.CheckCompatibility.
+func WithExpectedConfig(cfg shared.Config, opts shared.InitOpts, checks ...shared.CompatibilityOptionFunc) ProofOptionFunc {
+	return func(o *proofOption) error {
+		o.config = cfg
+		o.initOpts = opts
+		o.checks = checks
+		return nil
+	}
+}
+
+// WithBatchSize sets the number of bytes read and proven at once, it must be a multiple of 4096.
+func WithBatchSize(size int) ProofOptionFunc {
+	return func(o *proofOption) error {
+		if size <= 0 || size%4096 != 0 {
+			return fmt.Errorf("invalid batch size; expected: multiple of 4096, given: %d", size)
+		}
+		o.batchSize = size
+		return nil
+	}
+}
+
+func GenerateProof(dataDir string, challenge []byte, nonces, K1, K2 uint32, powDifficulty []byte, thread int32, opts ...ProofOptionFunc) (*shared.Proof, error) {
+	options := &proofOption{
+		reader:    DefaultReaderOptions(),
+		batchSize: BUNCH_SIZE,
+	}
+	options.reader.Buffers = DefaultProofBuffers
+	for _, opt := range opts {
+		if err := opt(options); err != nil {
+			return nil, err
+		}
+	}
+	if options.throttle != nil {
+		options.reader.Throttle = options.throttle
+	}
+
+	if options.store != nil && dataDir == "" {
+		options.checkpoint = 0
+	}
+	var metadata *shared.PostMetadata
+	var err error
+	if options.store != nil {
+		metadata, err = options.store.Metadata()
+	} else {
+		metadata, err = shared.ReadMetadata(dataDir)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("loading metadata: %w", err)
 	}
+	if options.store == nil {
+		if err := shared.CheckCompatibility(dataDir, options.config, options.initOpts, options.checks...); err != nil {
+			return nil, err
+		}
+	}
 	params, err := NewProvingParams(metadata, &Config{
 		PowDifficulty: powDifficulty,
 		K1:            K1,
@@ -27,21 +181,82 @@ func GenerateProof(dataDir string, challenge []byte, nonces, K1, K2 uint32, powD
 	fmt.Printf("Generating proof with params: %v \n", params)
 	startNonce := uint32(0)
 
+	planner := options.planner
+	if !options.deadline.IsZero() && planner == nil {
+		var disk float64
+		if options.store != nil {
+			disk, err = MeasureStore(options.store)
+		} else {
+			disk, err = MeasureDisk(dataDir, options.reader.Engine)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("measuring disk throughput: %w", err)
+		}
+		planner, err = NewPlanner(numLabels, K1, K2, Measurements{DiskBytesPerSec: disk, AESBytesPerSec: MeasureAES()}, int(thread))
+		if err != nil {
+			return nil, fmt.Errorf("creating planner: %w", err)
+		}
+	}
+	// plan 选择一轮扫描的nonce数和线程数，没有截止时间时使用固定参数
+	plan := func(remaining time.Duration, report bool) (uint32, int32) {
+		if options.deadline.IsZero() {
+			return nonces, thread
+		}
+		p := planner.Plan(remaining)
+		if report {
+			if options.report != nil {
+				options.report(p)
+			} else {
+				log.Printf("plan: %v", p)
+			}
+		}
+		threads := int32(p.Threads)
+		if thread > 0 && threads > thread {
+			threads = thread
+		}
+		return p.Nonces, threads
+	}
+	roundNonces, roundThread := plan(time.Until(options.deadline), true)
+
+	var resume *Checkpoint
+	if options.checkpoint > 0 {
+		cp, err := LoadCheckpoint(dataDir)
+		if err != nil {
+			log.Printf("discard checkpoint: %v", err)
+		}
+		matchNonces := nonces
+		if cp != nil && !options.deadline.IsZero() {
+			// 由planner决定nonce数时，沿用中断前那一轮的nonce数
+			matchNonces = cp.Nonces
+		}
+		if cp != nil && cp.Matches(challenge, K1, K2, matchNonces) {
+			log.Printf("resume from checkpoint, start nonce %d", cp.StartNonce)
+			resume = cp
+			startNonce = cp.StartNonce
+			roundNonces = cp.Nonces
+		} else if err := RemoveCheckpoint(dataDir); err != nil {
+			// 删不掉只是下次多一次比较，不影响这次proof
+			log.Printf("remove checkpoint failed: %v", err)
+		}
+	}
+
 	// 进行扫盘
-	generate := func(startNonce uint32) (*shared.Proof, error) {
+	generate := func(startNonce, nonces uint32, thread int32, resume *Checkpoint, prove *Prover8_56, next *preparedProver) (*shared.Proof, error) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer func() {
 			cancel()
 		}()
 
 		indexes := make(map[uint32][]uint64)
-		prove, err := NewProver8_56(challenge, nonceRange(startNonce, uint32(nonces)), params, metadata.NodeId)
-		if err != nil {
-			return nil, err
+		var watermarks map[int]uint64
+		if resume != nil {
+			indexes = resume.Indexes
+			if indexes == nil {
+				indexes = make(map[uint32][]uint64)
+			}
+			watermarks = resume.Watermarks
 		}
-		defer func() {
-			prove.Destroy()
-		}()
+		progress := newScanProgress(metadata.MaxFileSize, watermarks)
 		var foundNonce int64 = -1
 
 		//proveQueue := queue.NewNormal(ctx, 4, func(v interface{}) {
@@ -70,38 +285,109 @@ func GenerateProof(dataDir string, challenge []byte, nonces, K1, K2 uint32, powD
 		var job sync.WaitGroup
 		var lock sync.RWMutex
 
-		ch := make(chan *Batch, 128)
+		queueSize := options.reader.Buffers
+		if queueSize <= 0 {
+			queueSize = 128
+		}
+		ch := make(chan *Batch, queueSize)
+		// starved counts the batches proven while the queue was nearly empty
+		var starved int64
 		proof := func() {
 			defer func() {
 				job.Done()
 				fmt.Println("proof exit")
 			}()
-			for {
+			consume := func(nonce uint32, index uint64) bool {
+				lock.Lock()
+				defer lock.Unlock()
+				if len(indexes[nonce]) >= int(K2) {
+					return true
+				}
+				indexes[nonce] = append(indexes[nonce], index)
+				if len(indexes[nonce]) >= int(K2) {
+					foundNonce = int64(nonce)
+					cancel()
+					fmt.Println("found nonces", foundNonce)
+					return true
+				}
+
+				return false
+			}
+			for batch := range ch {
 				select {
 				case <-ctx.Done():
-					fmt.Println("proof done")
-					return
-				case batch := <-ch:
-					if batch == nil {
-						return
+					// 已找到，只归还缓冲区，让读盘尽快退出
+				default:
+					prove.prove(batch.Data, batch.Pos/LABEL_SIZE, consume)
+					if ctx.Err() == nil {
+						progress.complete(batch.Pos, len(batch.Data))
 					}
-					prove.prove(batch.Data, batch.Pos/LABEL_SIZE, func(nonce uint32, index uint64) bool {
-						lock.Lock()
-						defer lock.Unlock()
-						if len(indexes[nonce]) >= int(K2) {
-							return true
+					// 队列几乎为空说明读盘是瓶颈，用空闲的CPU准备下一轮的prover并顺带扫描下一个nonce区间。
+					// 读盘刚开始时队列也是空的，持续一个队列长度的batch后才算瓶颈
+					if ctx.Err() == nil && len(ch) <= cap(ch)/4 {
+						if atomic.AddInt64(&starved, 1) >= int64(cap(ch)) {
+							next.start()
 						}
-						indexes[nonce] = append(indexes[nonce], index)
-						if len(indexes[nonce]) >= int(K2) {
-							foundNonce = int64(nonce)
-							cancel()
-							fmt.Println("found nonces", foundNonce)
-							return true
+						if lookahead := next.ready(); options.lookahead && lookahead != nil {
+							lookahead.prove(batch.Data, batch.Pos/LABEL_SIZE, consume)
 						}
+					}
+				}
+				batch.Release()
+			}
+		}
 
-						return false
-					})
+		// save 保存当前进度，只保留水位线以下的索引，水位线以上的batch恢复后会重新读取
+		save := func(startNonce uint32) {
+			cp := &Checkpoint{
+				Challenge:  challenge,
+				K1:         K1,
+				K2:         K2,
+				StartNonce: startNonce,
+				Nonces:     nonces,
+				Watermarks: progress.snapshot(),
+				Indexes:    make(map[uint32][]uint64),
+				Pows:       prove.Pows(),
+			}
+			lock.RLock()
+			for nonce, list := range indexes {
+				if nonce-startNonce >= nonces {
+					// 预扫描的下一个区间并不完整，不保存
+					continue
 				}
+				for _, index := range list {
+					if covered(cp.Watermarks, metadata.MaxFileSize, index) {
+						cp.Indexes[nonce] = append(cp.Indexes[nonce], index)
+					}
+				}
+			}
+			lock.RUnlock()
+			if err := cp.Save(dataDir); err != nil {
+				log.Printf("save checkpoint failed: %v", err)
+			}
+		}
+		saveDone := make(chan struct{})
+		if options.checkpoint > 0 {
+			save(startNonce)
+			ticker := time.NewTicker(options.checkpoint)
+			go func() {
+				defer ticker.Stop()
+				for {
+					select {
+					case <-saveDone:
+						return
+					case <-ticker.C:
+						save(startNonce)
+					}
+				}
+			}()
+		}
+
+		// 上次中断时已经找到足够的索引
+		for nonce, list := range indexes {
+			if len(list) >= int(K2) && foundNonce == -1 {
+				foundNonce = int64(nonce)
+				cancel()
 			}
 		}
 
@@ -110,22 +396,54 @@ func GenerateProof(dataDir string, challenge []byte, nonces, K1, K2 uint32, powD
 			go proof()
 		}
 
-		err = ReadData(dataDir, BUNCH_SIZE, metadata.MaxFileSize, func(batch *Batch) bool {
+		readerOptions := options.reader
+		readerOptions.StartOffsets = progress.startOffsets()
+		read := func(batch *Batch) bool {
+			if batch == nil {
+				return true
+			}
 			select {
 			case <-ctx.Done():
 				fmt.Println("read exit")
+				batch.Release()
 				return false
 			case ch <- batch:
 				return true
 			}
-		})
+		}
+		if options.store != nil {
+			err = ReadStoreWithOptions(options.store, options.batchSize, metadata.MaxFileSize, readerOptions, read)
+		} else {
+			err = ReadDataWithOptions(dataDir, options.batchSize, metadata.MaxFileSize, readerOptions, read)
+		}
+		close(ch)
 
 		if err != nil {
+			job.Wait()
+			close(saveDone)
+			if options.checkpoint > 0 {
+				save(startNonce)
+			}
 			return nil, err
 		}
 		fmt.Println("wait job done")
 		job.Wait()
 		fmt.Println("job done")
+		close(saveDone)
+
+		if options.checkpoint > 0 {
+			if foundNonce != -1 {
+				if err := RemoveCheckpoint(dataDir); err != nil {
+					log.Printf("remove checkpoint failed: %v", err)
+				}
+			} else {
+				// 当前nonce区间已扫完，从下一个区间开始
+				next := &Checkpoint{Challenge: challenge, K1: K1, K2: K2, StartNonce: startNonce + nonces, Nonces: nonces}
+				if err := next.Save(dataDir); err != nil {
+					log.Printf("save checkpoint failed: %v", err)
+				}
+			}
+		}
 
 		if foundNonce != -1 {
 			list := indexes[uint32(foundNonce)]
@@ -137,20 +455,141 @@ func GenerateProof(dataDir string, challenge []byte, nonces, K1, K2 uint32, powD
 				fmt.Print(", ", v)
 			}
 			fmt.Println("]")
+			// 只有nonce所属的prover持有它的pow
+			var pow uint64
+			if offset := uint32(foundNonce) - startNonce; offset >= nonces {
+				log.Printf("proof found in the lookahead range, nonce %d", foundNonce)
+				pow = next.prover.Pow(offset - nonces)
+			} else {
+				pow = prove.Pow(offset)
+			}
 			return &shared.Proof{
-				Pow:     prove.Pow(uint32(foundNonce) - startNonce),
+				Pow:     pow,
 				Indices: CompressIndices(list, int(requiredBits(numLabels))),
 				Nonce:   uint32(foundNonce),
 			}, nil
 		} else {
-			return nil, errors.New("not found")
+			return nil, errProofNotFound
 		}
 	}
 
+	var pows []uint64
+	if resume != nil {
+		pows = resume.Pows
+	}
+	newProver := func(ctx context.Context, startNonce, nonces uint32, thread int32, pows []uint64) (*Prover8_56, error) {
+		start := time.Now()
+		prover, err := newProver8_56(ctx, challenge, nonceRange(startNonce, nonces), params, metadata.NodeId, pows)
+		if err != nil {
+			return nil, err
+		}
+		if planner != nil && pows == nil {
+			planner.ObservePow(len(prover.groupCipher), time.Since(start))
+		}
+		prover.SetWorkers(int(thread))
+		prover.SetThrottle(options.reader.Throttle)
+		return prover, nil
+	}
+	current, err := newProver(context.Background(), startNonce, roundNonces, roundThread, pows)
+	if err != nil {
+		return nil, err
+	}
 	for {
-		if proof, err := generate(startNonce); err == nil {
-			return proof, nil
+		// 读盘成为瓶颈时，用空闲的CPU准备下一轮的cipher和pow
+		var predicted time.Duration
+		if planner != nil {
+			predicted = planner.PassTime(roundNonces, int(roundThread))
+		}
+		nextNonces, nextThread := plan(time.Until(options.deadline)-predicted, false)
+		next := prepareProver(func(ctx context.Context) (*Prover8_56, error) {
+			return newProver(ctx, startNonce+roundNonces, nextNonces, nextThread, nil)
+		})
+		start := time.Now()
+		proof, err := generate(startNonce, roundNonces, roundThread, resume, current, next)
+		current.Destroy()
+		if err == nil || !errors.Is(err, errProofNotFound) {
+			next.destroy()
+			return proof, err
+		}
+		if planner != nil && resume == nil {
+			planner.Observe(roundNonces, int(roundThread), time.Since(start))
+			// 用实际耗时重新规划，nonce数变化时放弃预先准备的prover
+			if n, t := plan(time.Until(options.deadline), true); n != nextNonces {
+				next.destroy()
+				next = prepareProver(func(ctx context.Context) (*Prover8_56, error) {
+					return newProver(ctx, startNonce+roundNonces, n, t, nil)
+				})
+				nextNonces, nextThread = n, t
+			}
+		}
+		if current, err = next.wait(); err != nil {
+			return nil, err
 		}
-		startNonce += uint32(nonces)
+		resume = nil
+		startNonce += roundNonces
+		roundNonces, roundThread = nextNonces, nextThread
+	}
+}
+
+// errProofNotFound is returned by a round that scanned all data without any nonce reaching K2.
+var errProofNotFound = errors.New("not found")
+
+// preparedProver is a Prover8_56 of the next round that is created in the background once start is
+// called, so its pows only compete for the CPU while reading is the bottleneck.
+type preparedProver struct {
+	create func(ctx context.Context) (*Prover8_56, error)
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	prover *Prover8_56
+	err    error
+	done   chan struct{}
+}
+
+// prepareProver returns a prover to be created with create.
+func prepareProver(create func(ctx context.Context) (*Prover8_56, error)) *preparedProver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &preparedProver{create: create, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// start starts creating the prover in the background, if it is not started yet.
+func (p *preparedProver) start() {
+	p.once.Do(func() {
+		go func() {
+			defer close(p.done)
+			p.prover, p.err = p.create(p.ctx)
+		}()
+	})
+}
+
+// wait creates the prover, if it is not started yet, and blocks until it is created.
+func (p *preparedProver) wait() (*Prover8_56, error) {
+	p.start()
+	<-p.done
+	return p.prover, p.err
+}
+
+// ready returns the prover if it is created, nil otherwise.
+func (p *preparedProver) ready() *Prover8_56 {
+	select {
+	case <-p.done:
+		return p.prover
+	default:
+		return nil
 	}
 }
+
+// destroy abandons the prover: a prover not started is never created, the pows of one being created are
+// cancelled, which releases the RandomX lock, and a created one is released.
+func (p *preparedProver) destroy() {
+	p.cancel()
+	p.once.Do(func() {
+		close(p.done)
+	})
+	go func() {
+		<-p.done
+		if p.prover != nil {
+			p.prover.Destroy()
+		}
+	}()
+}
@@ -1,14 +1,26 @@
 package post_go
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/trying2016/post-go/prove/post"
 	"github.com/trying2016/post-go/randomx"
 	"github.com/trying2016/post-go/shared"
+	"github.com/zeebo/blake3"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -65,3 +77,295 @@ func TestGenerateProof(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// newTestPost writes a data dir with random labels and its metadata.
+func newTestPost(t *testing.T, numUnits uint32, labelsPerUnit, maxFileSize uint64) (string, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	nodeId := make([]byte, 32)
+	commitment := make([]byte, 32)
+	rand.Read(nodeId)
+	rand.Read(commitment)
+	metadata := shared.PostMetadata{
+		NodeId:          nodeId,
+		CommitmentAtxId: commitment,
+		LabelsPerUnit:   labelsPerUnit,
+		NumUnits:        numUnits,
+		MaxFileSize:     maxFileSize,
+	}
+	data, err := json.Marshal(&metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "postdata_metadata.json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := make([]byte, uint64(numUnits)*labelsPerUnit*LABEL_SIZE)
+	rand.Read(labels)
+	for i := 0; uint64(i)*maxFileSize < uint64(len(labels)); i++ {
+		end := uint64(i+1) * maxFileSize
+		if end > uint64(len(labels)) {
+			end = uint64(len(labels))
+		}
+		if err := os.WriteFile(filepath.Join(dir, shared.InitFileName(i)), labels[uint64(i)*maxFileSize:end], 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, labels
+}
+
+// fakePow stands in for RandomX in tests.
+func fakePow(input, difficulty []byte) uint64 {
+	sum := blake3.Sum256(input)
+	return binary.LittleEndian.Uint64(sum[:])
+}
+
+// unpackIndices reverses CompressIndices.
+func unpackIndices(data []byte, bits int, count int) []uint64 {
+	var list []uint64
+	for i := 0; i < count; i++ {
+		var v uint64
+		for b := 0; b < bits; b++ {
+			bit := i*bits + b
+			if data[bit/8]&(1<<(bit%8)) != 0 {
+				v |= 1 << b
+			}
+		}
+		list = append(list, v)
+	}
+	return list
+}
+
+// checkProof recomputes every proven label with crypto/aes.
+func checkProof(t *testing.T, proof *shared.Proof, challenge, labels []byte, numLabels uint64, k1, k2 uint32) {
+	t.Helper()
+	difficulty, err := provingDifficulty(k1, numLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msb, lsb := splitDifficulty(difficulty)
+	group := calcNonceGroup(proof.Nonce, NONCES_PER_AES)
+	groupCipher, _ := aes.NewCipher(NewAesCipherKey(challenge, group, proof.Pow))
+	lazyCipher, _ := aes.NewCipher(NewLazyAesCipherKey(challenge, proof.Nonce, group, proof.Pow))
+
+	indices := unpackIndices(proof.Indices, int(requiredBits(numLabels)), int(k2))
+	var out [16]byte
+	for i, index := range indices {
+		if i > 0 && indices[i-1] >= index {
+			t.Fatalf("indices not strictly increasing: %v", indices)
+		}
+		label := labels[index*LABEL_SIZE : (index+1)*LABEL_SIZE]
+		groupCipher.Encrypt(out[:], label)
+		value := out[proof.Nonce%NONCES_PER_AES]
+		if value > msb {
+			t.Fatalf("label %d is not below the difficulty for nonce %d", index, proof.Nonce)
+		}
+		if value == msb {
+			lazyCipher.Encrypt(out[:], label)
+			if binary.LittleEndian.Uint64(out[:])&0x00ffffffffffffff >= lsb {
+				t.Fatalf("label %d fails the lsb check for nonce %d", index, proof.Nonce)
+			}
+		}
+	}
+}
+
+func TestGenerateProofSmall(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, 64*1024)
+	challenge := sha256.Sum256([]byte("small"))
+
+	proof, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 2,
+		WithBatchSize(16*1024), WithReaderOptions(ReaderOptions{Parallel: 2, Buffers: 4, Engine: EngineBuffered}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProof(t, proof, challenge[:], labels, numUnits*labelsPerUnit, shared.K1, shared.K2)
+}
+
+func TestGenerateProofLookahead(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, 64*1024)
+	challenge := sha256.Sum256([]byte("lookahead"))
+
+	proof, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 2,
+		WithBatchSize(16*1024), WithReaderOptions(ReaderOptions{Buffers: 8, Engine: EngineBuffered}),
+		WithCheckpoint(0), WithLookahead(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProof(t, proof, challenge[:], labels, numUnits*labelsPerUnit, shared.K1, shared.K2)
+}
+
+// nonceCounts returns the number of labels from index from on that qualify for each of nonces nonces
+// starting at start.
+func nonceCounts(t *testing.T, challenge, minerID, labels []byte, numLabels uint64, k1, start, nonces uint32, from uint64) map[uint32]int {
+	t.Helper()
+	difficulty, err := provingDifficulty(k1, numLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prover, err := NewProver8_56(challenge, nonceRange(start, nonces), &ProvingParams{Difficulty: difficulty}, minerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prover.Destroy()
+	prover.SetWorkers(1)
+	counts := make(map[uint32]int)
+	prover.prove(labels[from*LABEL_SIZE:], from, func(nonce uint32, index uint64) bool {
+		counts[nonce]++
+		return false
+	})
+	return counts
+}
+
+func TestGenerateProofLookaheadHit(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+		numLabels     = numUnits * labelsPerUnit
+		batchSize     = 4096
+		k1, k2        = 16, 24
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, numLabels*LABEL_SIZE)
+	metadata, err := shared.ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 找一个challenge: 第一轮的nonce都达不到k2，下一轮的某个nonce只用第4个batch之后的数据就能达到
+	var challenge [32]byte
+	found := false
+	for i := 0; i < 1000 && !found; i++ {
+		challenge = sha256.Sum256([]byte(fmt.Sprintf("lookahead hit %d", i)))
+		found = true
+		for _, count := range nonceCounts(t, challenge[:], metadata.NodeId, labels, numLabels, k1, 0, 16, 0) {
+			if count >= k2 {
+				found = false
+			}
+		}
+		if !found {
+			continue
+		}
+		found = false
+		for _, count := range nonceCounts(t, challenge[:], metadata.NodeId, labels, numLabels, k1, 16, 16, 4*batchSize/LABEL_SIZE) {
+			if count >= k2 {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Skip("no challenge proving in the lookahead range")
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	// 限速读盘，队列一直是空的，下一轮从第2个batch起就顺带扫描
+	throttle := NewThrottle(ThrottleProfile{Name: "test", ReadBytesPerSec: 1 << 20})
+	proof, err := GenerateProof(dir, challenge[:], 16, k1, k2, TestNetPowDifficulty, 1,
+		WithBatchSize(batchSize), WithReaderOptions(ReaderOptions{Parallel: 1, Buffers: 1, Engine: EngineBuffered}),
+		WithThrottle(throttle), WithLookahead(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Nonce < 16 || !strings.Contains(logs.String(), "lookahead range") {
+		t.Fatalf("proof of nonce %d not found in the lookahead range:\n%s", proof.Nonce, logs.String())
+	}
+	checkProof(t, proof, challenge[:], labels, numLabels, k1, k2)
+}
+
+func TestGenerateProofReadError(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	dir, _ := newTestPost(t, 1, 4096, 64*1024)
+	challenge := sha256.Sum256([]byte("error"))
+
+	// 读盘错误必须返回，而不是继续尝试下一个nonce区间
+	_, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 1,
+		WithReaderOptions(ReaderOptions{Engine: "missing"}), WithCheckpoint(0))
+	if !errors.Is(err, ErrUnknownEngine) {
+		t.Fatalf("expected ErrUnknownEngine, got %v", err)
+	}
+}
+
+func TestGenerateProofManifest(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, 16*1024)
+	disks := []string{t.TempDir(), t.TempDir()}
+	if _, err := shared.Rebalance(dir, disks, nil); err != nil {
+		t.Fatal(err)
+	}
+	challenge := sha256.Sum256([]byte("manifest"))
+
+	proof, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 2,
+		WithBatchSize(16*1024), WithReaderOptions(ReaderOptions{Parallel: 2, PerDevice: 1, Buffers: 4, Engine: EngineBuffered}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProof(t, proof, challenge[:], labels, numUnits*labelsPerUnit, shared.K1, shared.K2)
+}
+
+func TestGenerateProofConfigMismatch(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	dir, _ := newTestPost(t, 1, 4096, 64*1024)
+	challenge := sha256.Sum256([]byte("mismatch"))
+
+	_, err := GenerateProof(dir, challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 1,
+		WithCheckpoint(0), WithExpectedConfig(shared.Config{LabelsPerUnit: 512}, shared.InitOpts{NumUnits: 1}))
+	var mismatch shared.ConfigMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Param != "LabelsPerUnit" {
+		t.Fatalf("expected a LabelsPerUnit mismatch, got %v", err)
+	}
+}
+
+func TestPreparedProverCancel(t *testing.T) {
+	SetRandomxCallback(func(input, difficulty []byte) uint64 {
+		time.Sleep(10 * time.Millisecond)
+		return fakePow(input, difficulty)
+	})
+	defer SetRandomxCallback(fakePow)
+	challenge := sha256.Sum256([]byte("prepared"))
+	minerID := make([]byte, 32)
+	params := &ProvingParams{Difficulty: 1 << 60}
+	create := func(nonces uint32) func(ctx context.Context) (*Prover8_56, error) {
+		return func(ctx context.Context) (*Prover8_56, error) {
+			return newProver8_56(ctx, challenge[:], nonceRange(0, nonces), params, minerID, nil)
+		}
+	}
+
+	// 未开始的不会创建
+	created := false
+	idle := prepareProver(func(ctx context.Context) (*Prover8_56, error) {
+		created = true
+		return nil, nil
+	})
+	idle.destroy()
+	if _, err := idle.wait(); err != nil || created {
+		t.Fatalf("destroyed prover was created: %v", err)
+	}
+
+	// 创建中取消，RandomX锁要马上释放
+	next := prepareProver(create(64 * NONCES_PER_AES))
+	next.start()
+	time.Sleep(50 * time.Millisecond)
+	next.destroy()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	prover, err := create(NONCES_PER_AES)(ctx)
+	if err != nil {
+		t.Fatalf("randomx lock held after cancelling: %v", err)
+	}
+	prover.Destroy()
+}
@@ -1,16 +1,18 @@
 package post_go
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/trying2016/post-go/prove/post"
+	"github.com/trying2016/post-go/prove/prove_go/aesscan"
 	"github.com/trying2016/post-go/shared"
-	"math"
-	"math/big"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,60 +40,22 @@ func SetRandomxCallback(callback RandomxCallback) {
 }
 
 func provingDifficulty(k1 uint32, numLabels uint64) (uint64, error) {
-	if numLabels <= 0 {
-		return 0, fmt.Errorf("number of label blocks must be > 0")
-	}
-	if numLabels <= uint64(k1) {
-		return 0, fmt.Errorf("number of labels (%d) must be bigger than k1 (%d)", numLabels, k1)
-	}
-	difficulty := uint64(math.Pow(2, 64) * float64(k1) / float64(numLabels))
-	return difficulty, nil
+	return shared.LabelDifficulty(k1, numLabels)
 }
 
-type ProvingParams struct {
-	Difficulty    uint64
-	PoWDifficulty [32]byte
-}
+// ProvingParams are the difficulties of a proof, see shared.ProvingParams.
+type ProvingParams = shared.ProvingParams
 
-/*
-	pub fn new(metadata: &PostMetadata, cfg: &Config) -> eyre::Result<Self> {
-	       let num_labels = metadata.num_units as u64 * metadata.labels_per_unit;
-	       let mut pow_difficulty = [0u8; 32];
-	       let difficulty_scaled = U256::from_big_endian(&cfg.pow_difficulty) / metadata.num_units;
-	       difficulty_scaled.to_big_endian(&mut pow_difficulty);
-	       Ok(Self {
-	           difficulty: proving_difficulty(cfg.k1, num_labels)?,
-	           pow_difficulty,
-	       })
-	   }
-*/
-func toBigEndian(nWords int, value []big.Word, bytes []byte) {
-	for i := 0; i < nWords; i++ {
-		binary.BigEndian.PutUint64(bytes[8*i:], uint64(value[nWords-i-1]))
-	}
-}
 func NewProvingParams(metadata *shared.PostMetadata, cfg *Config) (*ProvingParams, error) {
-	numLabels := uint64(metadata.NumUnits) * metadata.LabelsPerUnit
-	var powDifficulty [32]byte
-	difficultyScaled := new(big.Int).SetBytes(cfg.PowDifficulty)
-	difficultyScaled = difficultyScaled.Div(difficultyScaled, new(big.Int).SetUint64(uint64(metadata.NumUnits)))
-	//copy(powDifficulty[:], difficultyScaled.Bytes())
-	toBigEndian(4, difficultyScaled.Bits(), powDifficulty[:])
-
-	diff, err := provingDifficulty(cfg.K1, numLabels)
-	if err != nil {
-		return nil, err
-	}
-	return &ProvingParams{
-		Difficulty:    diff,
-		PoWDifficulty: powDifficulty,
-	}, nil
+	return shared.NewProvingParams(metadata.NumUnits, metadata.LabelsPerUnit, cfg.PowDifficulty, cfg.K1)
 }
 
 type Cipher struct {
 	Aes   *post.Aes
 	GoAes cipher.Block
-	Pow   uint64
+	// Scan 汇编内核，CPU不支持AES-NI时为nil
+	Scan *aesscan.Cipher
+	Pow  uint64
 }
 
 type Prover8_56 struct {
@@ -102,14 +66,34 @@ type Prover8_56 struct {
 	tmpOut        []byte
 	startNonce    uint32
 	nonces        uint32
+
+	workerCount int
+	workersOnce sync.Once
+	workers     []*proverWorker
+	tasks       chan proveTask
+	workerJob   sync.WaitGroup
+	throttle    *Throttle
 }
 
-// 加个全局锁，防止randomx并发
-var randomxLock sync.Mutex
+// 加个全局锁，防止randomx并发。用channel实现，等待锁时可以取消
+var randomxLock = make(chan struct{}, 1)
 
 func NewProver8_56(challenge []byte, nonces []uint32, params *ProvingParams, minerID []byte) (*Prover8_56, error) {
-	randomxLock.Lock()
-	defer randomxLock.Unlock()
+	return newProver8_56(context.Background(), challenge, nonces, params, minerID, nil)
+}
+
+// newProver8_56 works like NewProver8_56 but takes the group pows from pows when it holds one for every
+// nonce group, e.g. when a scan is resumed from a checkpoint. Cancelling ctx abandons the pows between
+// nonce groups and releases the RandomX lock right away.
+func newProver8_56(ctx context.Context, challenge []byte, nonces []uint32, params *ProvingParams, minerID []byte, pows []uint64) (prover *Prover8_56, err error) {
+	select {
+	case randomxLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-randomxLock
+	}()
 
 	if nonces[0]%NONCES_PER_AES != 0 {
 		return nil, errors.New("nonces must start at a multiple of 16")
@@ -121,11 +105,28 @@ func NewProver8_56(challenge []byte, nonces []uint32, params *ProvingParams, min
 	fmt.Printf("calc nonces %v...%v \n", nonces[0], nonces[len(nonces)-1])
 
 	nonceGroup := nonceGroupRange(nonces, NONCES_PER_AES)
+	if len(pows) != len(nonceGroup) {
+		pows = nil
+	}
 	gropuKeys := make([]byte, KEY_SIZE*len(nonceGroup))
 	noncesKeys := make([]byte, KEY_SIZE*len(nonces))
 	var groupCipherList []*Cipher
 	var nonceCipherList []*Cipher
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, cipher := range groupCipherList {
+			cipher.Aes.Free()
+		}
+		for _, cipher := range nonceCipherList {
+			cipher.Aes.Free()
+		}
+	}()
 	for i, group := range nonceGroup {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		//0~6: nonce, 7: group, 8~15: challenge, 16~47: minerID
 		powInput := make([]byte, 8+8+32)
 		powInput[7] = uint8(group)
@@ -134,13 +135,26 @@ func NewProver8_56(challenge []byte, nonces []uint32, params *ProvingParams, min
 
 		//hexInput := hex.EncodeToString(powInput)
 		//hexDifficulty := hex.EncodeToString(params.PoWDifficulty[:])
-		pow := randomxCallback(powInput, params.PoWDifficulty[:])
+		var pow uint64
+		if pows != nil {
+			pow = pows[i]
+		} else {
+			pow = randomxCallback(powInput, params.PoWDifficulty[:])
+		}
 		key := NewAesCipherKey(challenge, uint32(group), pow)
 
 		cipher := &Cipher{
 			Aes: post.NewAes(key),
 			Pow: pow,
 		}
+		if aesscan.Accelerated() {
+			scan, err := aesscan.NewCipher(key)
+			if err != nil {
+				cipher.Aes.Free()
+				return nil, err
+			}
+			cipher.Scan = scan
+		}
 
 		// fmt.Println("group key", hex.EncodeToString(key))
 		copy(gropuKeys[i*KEY_SIZE:], key)
@@ -185,8 +199,18 @@ func (p *Prover8_56) Pow(nonce uint32) uint64 {
 	return p.groupCipher[group].Pow
 }
 
+// Pows returns the pows of the nonce groups in order.
+func (p *Prover8_56) Pows() []uint64 {
+	pows := make([]uint64, len(p.groupCipher))
+	for i, cipher := range p.groupCipher {
+		pows[i] = cipher.Pow
+	}
+	return pows
+}
+
 // Destroy 销毁
 func (p *Prover8_56) Destroy() {
+	p.stopWorkers()
 	for _, cipher := range p.groupCipher {
 		cipher.Aes.Free()
 	}
@@ -244,57 +268,207 @@ fn prove<F>(&self, batch: &[u8], mut index: u64, mut consume: F) -> Option<(u32,
 
 var showTime int64
 
+// proveChunkSize is the slice of a batch a worker encrypts with every group cipher before taking the
+// next one. Input and output of a chunk stay in the L2 cache while all groups are checked.
+const proveChunkSize = 64 * 1024
+
+// ProverStats are counters of the work done by a prover.
+type ProverStats struct {
+	// Bytes is the number of label bytes checked against all group ciphers.
+	Bytes int64
+	// Candidates is the number of labels passing the MSB check, including those sent to the LSB check.
+	Candidates int64
+	// LSBChecks is the number of labels that needed the second cipher.
+	LSBChecks int64
+	// EncryptNanos is the time spent in AES, summed over all workers.
+	EncryptNanos int64
+}
+
+// proverWorker owns the output buffer and counters of one worker so workers never share a cache line.
+type proverWorker struct {
+	out     []byte
+	offsets []uint32
+	stats   ProverStats
+	_       [64]byte
+}
+
+// proveCall is a single prove invocation split into chunk tasks.
+type proveCall struct {
+	batch     []byte
+	baseIndex uint64
+	consume   func(uint32, uint64) bool
+	stop      int32
+	job       sync.WaitGroup
+}
+
+type proveTask struct {
+	call       *proveCall
+	start, end int
+}
+
+// SetWorkers sets the number of goroutines encrypting batch chunks. It has to be called before the
+// first batch is proven, later calls have no effect.
+func (p *Prover8_56) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	p.workerCount = workers
+}
+
+// SetThrottle caps the number of workers encrypting at the same time with the throttle's ProverThreads.
+// Like SetWorkers it has to be called before the first batch is proven.
+func (p *Prover8_56) SetThrottle(throttle *Throttle) {
+	p.throttle = throttle
+}
+
+// Stats returns the counters summed over all workers.
+func (p *Prover8_56) Stats() ProverStats {
+	var total ProverStats
+	for _, w := range p.workers {
+		total.Bytes += atomic.LoadInt64(&w.stats.Bytes)
+		total.Candidates += atomic.LoadInt64(&w.stats.Candidates)
+		total.LSBChecks += atomic.LoadInt64(&w.stats.LSBChecks)
+		total.EncryptNanos += atomic.LoadInt64(&w.stats.EncryptNanos)
+	}
+	return total
+}
+
+func (p *Prover8_56) startWorkers() {
+	if p.workerCount < 1 {
+		p.workerCount = runtime.NumCPU()
+	}
+	p.tasks = make(chan proveTask, p.workerCount*2)
+	for i := 0; i < p.workerCount; i++ {
+		w := &proverWorker{out: make([]byte, proveChunkSize)}
+		p.workers = append(p.workers, w)
+		p.workerJob.Add(1)
+		go func() {
+			defer p.workerJob.Done()
+			for task := range p.tasks {
+				if atomic.LoadInt32(&task.call.stop) == 0 {
+					p.throttle.AcquireProver()
+					if p.proveChunk(w, task) {
+						atomic.StoreInt32(&task.call.stop, 1)
+					}
+					p.throttle.ReleaseProver()
+				}
+				task.call.job.Done()
+			}
+		}()
+	}
+}
+
+func (p *Prover8_56) stopWorkers() {
+	if p.tasks != nil {
+		close(p.tasks)
+		p.workerJob.Wait()
+	}
+}
+
 // prove 满足consume的调节后，返回true，读盘停止运行
+// The batch is cut into chunks that are spread over the workers, consume is called concurrently
+// from several workers and must be safe for concurrent use.
 func (p *Prover8_56) prove(batch []byte, baseIndex uint64, consume func(uint32, uint64) bool) bool {
-	count := int64(len(batch)) / 16 * int64(len(p.groupCipher))
-	groupCost := int64(0)
-	tmpData := make([]byte, len(batch))
+	p.workersOnce.Do(p.startWorkers)
+	tick := time.Now().UnixMilli()
+
+	call := &proveCall{
+		batch:     batch,
+		baseIndex: baseIndex,
+		consume:   consume,
+	}
+	for start := 0; start < len(batch); start += proveChunkSize {
+		end := start + proveChunkSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+		call.job.Add(1)
+		p.tasks <- proveTask{call: call, start: start, end: end}
+	}
+	call.job.Wait()
+
+	if now := time.Now().Unix(); now-atomic.LoadInt64(&showTime) > 10 {
+		atomic.StoreInt64(&showTime, now)
+		stats := p.Stats()
+		fmt.Println("cost time:", time.Now().UnixMilli()-tick, " ms count:", stats.Candidates, " group cost:", stats.EncryptNanos/1e6)
+	}
+	return atomic.LoadInt32(&call.stop) != 0
+}
+
+// proveChunk checks batch[task.start:task.end] against every group cipher. It returns true once consume did.
+func (p *Prover8_56) proveChunk(w *proverWorker, task proveTask) bool {
+	call := task.call
+	chunk := call.batch[task.start:task.end]
+	out := w.out[:len(chunk)]
+	// chunk内第一个label的索引
+	baseIndex := call.baseIndex + uint64(task.start/LABEL_SIZE)
 	var temp [16]byte
+	defer atomic.AddInt64(&w.stats.Bytes, int64(len(chunk)))
 
-	calcGroup := func(i int, cipher *Cipher) {
+	for i, cipher := range p.groupCipher {
+		if atomic.LoadInt32(&call.stop) != 0 {
+			return false
+		}
 		group := uint32(i) + p.startNonce/16
+		if cipher.Scan != nil {
+			if p.scanChunk(w, cipher, group, chunk, baseIndex, call.consume) {
+				return true
+			}
+			continue
+		}
 		t := time.Now().UnixNano()
-		cipher.Aes.Encrypt(batch, tmpData, len(batch))
-		t = time.Now().UnixNano() - t
-		groupCost += t
-		for offset, msb := range tmpData {
-			if msb <= p.DifficultyMSB {
-				if msb == p.DifficultyMSB {
-					// Check LSB
-					nonce := calcNonce(group, uint32(offset), NONCES_PER_AES)
-					labelOffset := offset / int(NONCES_PER_AES) * LABEL_SIZE
-					count++
-					if p.checkLSB(batch[labelOffset:labelOffset+LABEL_SIZE], temp[:], nonce, uint32(offset), baseIndex, consume) {
-						return
-					}
-				} else {
-					// valid label
-					index := baseIndex + uint64(offset/int(NONCES_PER_AES))
-					nonce := calcNonce(group, uint32(offset), NONCES_PER_AES)
-					if consume(nonce, index) {
-						return
-					}
-				}
+		cipher.Aes.Encrypt(chunk, out, len(chunk))
+		atomic.AddInt64(&w.stats.EncryptNanos, time.Now().UnixNano()-t)
+		for offset, msb := range out {
+			if msb > p.DifficultyMSB {
+				continue
+			}
+			if p.checkCandidate(w, chunk, group, offset, msb, baseIndex, temp[:], call.consume) {
+				return true
 			}
 		}
 	}
-	var job sync.WaitGroup
-	tick := time.Now().UnixMilli()
-	for i, cipher := range p.groupCipher {
-		func(index int, c *Cipher) {
-			calcGroup(index, c)
-		}(i, cipher)
-	}
-	job.Wait()
+	return false
+}
 
-	if time.Now().Unix()-showTime > 10 {
-		showTime = time.Now().Unix()
-		costTime := time.Now().UnixMilli() - tick
-		fmt.Println("cost time:", costTime, " ms count:", count, " group cost:", groupCost/1e6)
+// scanChunk checks chunk against one group cipher with the aesscan kernel, which encrypts and compares
+// the MSB in one pass and only returns the offsets of candidates.
+func (p *Prover8_56) scanChunk(w *proverWorker, cipher *Cipher, group uint32, chunk []byte, baseIndex uint64, consume func(uint32, uint64) bool) bool {
+	var temp, block [16]byte
+	t := time.Now().UnixNano()
+	w.offsets = cipher.Scan.Scan(chunk, p.DifficultyMSB, w.offsets[:0])
+	atomic.AddInt64(&w.stats.EncryptNanos, time.Now().UnixNano()-t)
+	for _, off := range w.offsets {
+		offset := int(off)
+		msb := p.DifficultyMSB
+		if msb != 0 {
+			// 内核只保证输出<=MSB，重新加密该label区分是否需要检查LSB
+			labelOffset := offset / int(NONCES_PER_AES) * LABEL_SIZE
+			cipher.Scan.Encrypt(block[:], chunk[labelOffset:labelOffset+LABEL_SIZE])
+			msb = block[offset%LABEL_SIZE]
+		}
+		if p.checkCandidate(w, chunk, group, offset, msb, baseIndex, temp[:], consume) {
+			return true
+		}
 	}
 	return false
 }
 
+// checkCandidate handles an output byte at offset of chunk that passed the MSB check.
+func (p *Prover8_56) checkCandidate(w *proverWorker, chunk []byte, group uint32, offset int, msb uint8, baseIndex uint64, temp []byte, consume func(uint32, uint64) bool) bool {
+	atomic.AddInt64(&w.stats.Candidates, 1)
+	nonce := calcNonce(group, uint32(offset), NONCES_PER_AES)
+	if msb == p.DifficultyMSB {
+		// Check LSB
+		atomic.AddInt64(&w.stats.LSBChecks, 1)
+		labelOffset := offset / int(NONCES_PER_AES) * LABEL_SIZE
+		return p.checkLSB(chunk[labelOffset:labelOffset+LABEL_SIZE], temp, nonce, uint32(offset), baseIndex, consume)
+	}
+	// valid label
+	index := baseIndex + uint64(offset/int(NONCES_PER_AES))
+	return consume(nonce, index)
+}
+
 /*
 // LSB part of the difficulty is checked with second sequence of AES ciphers.
 
@@ -317,7 +491,8 @@ func (p *Prover8_56) prove(batch []byte, baseIndex uint64, consume func(uint32,
 
 func (p *Prover8_56) checkLSB(label, temp []byte, nonce, offset uint32, baseIndex uint64, consume func(uint32, uint64) bool) bool {
 
-	lazy := p.nonceCipher[nonce%p.nonces]
+	// nonceCipher从startNonce开始排列，一轮的起点不一定是nonce数的倍数
+	lazy := p.nonceCipher[nonce-p.startNonce]
 	lazy.GoAes.Encrypt(temp[:], label)
 	//lazy.Aes.EncryptUint(label, temp[:], 16)
 	lsb := binary.LittleEndian.Uint64(temp[:]) & 0x00ffffffffffffff
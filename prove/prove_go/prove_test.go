@@ -0,0 +1,116 @@
+package post_go
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/trying2016/post-go/shared"
+	"sync"
+	"testing"
+)
+
+type candidate struct {
+	nonce uint32
+	index uint64
+}
+
+// referenceCandidates scans batch with crypto/aes, one label and nonce at a time.
+func referenceCandidates(t *testing.T, challenge, batch []byte, startNonce, nonces uint32, pows []uint64, difficulty uint64) map[candidate]bool {
+	t.Helper()
+	msb, lsb := splitDifficulty(difficulty)
+	found := make(map[candidate]bool)
+	var out [16]byte
+	for nonce := startNonce; nonce < startNonce+nonces; nonce++ {
+		group := calcNonceGroup(nonce, NONCES_PER_AES)
+		pow := pows[group-startNonce/NONCES_PER_AES]
+		groupCipher, _ := aes.NewCipher(NewAesCipherKey(challenge, group, pow))
+		lazyCipher, _ := aes.NewCipher(NewLazyAesCipherKey(challenge, nonce, group, pow))
+		for index := 0; index < len(batch)/LABEL_SIZE; index++ {
+			label := batch[index*LABEL_SIZE : (index+1)*LABEL_SIZE]
+			groupCipher.Encrypt(out[:], label)
+			value := out[nonce%NONCES_PER_AES]
+			if value < msb {
+				found[candidate{nonce, uint64(index)}] = true
+			} else if value == msb {
+				lazyCipher.Encrypt(out[:], label)
+				if binary.LittleEndian.Uint64(out[:])&0x00ffffffffffffff < lsb {
+					found[candidate{nonce, uint64(index)}] = true
+				}
+			}
+		}
+	}
+	return found
+}
+
+func TestProverWorkers(t *testing.T) {
+	// 4096个label时DifficultyMSB不为0，覆盖汇编内核重新计算MSB的分支
+	for _, numLabels := range []uint64{64 * 1024, 4096} {
+		for _, scan := range []bool{true, false} {
+			checkProverWorkers(t, numLabels, scan)
+		}
+	}
+}
+
+func checkProverWorkers(t *testing.T, numLabels uint64, scan bool) {
+	SetRandomxCallback(fakePow)
+	challenge := sha256.Sum256([]byte("workers"))
+	minerID := make([]byte, 32)
+	batch := make([]byte, 3*proveChunkSize+4096)
+	rand.Read(batch)
+
+	difficulty, err := provingDifficulty(shared.K1, numLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := &ProvingParams{Difficulty: difficulty}
+	prover, err := NewProver8_56(challenge[:], nonceRange(32, 32), params, minerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prover.Destroy()
+	prover.SetWorkers(4)
+	if !scan {
+		for _, cipher := range prover.groupCipher {
+			cipher.Scan = nil
+		}
+	}
+
+	var lock sync.Mutex
+	got := make(map[candidate]bool)
+	stopped := prover.prove(batch, 0, func(nonce uint32, index uint64) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		got[candidate{nonce, index}] = true
+		return false
+	})
+	if stopped {
+		t.Fatal("prove stopped without consume asking for it")
+	}
+
+	pows := []uint64{prover.groupCipher[0].Pow, prover.groupCipher[1].Pow}
+	want := referenceCandidates(t, challenge[:], batch, 32, 32, pows, difficulty)
+	if len(got) != len(want) {
+		t.Fatalf("labels %d scan %v: expected %d candidates, got %d", numLabels, scan, len(want), len(got))
+	}
+	for c := range want {
+		if !got[c] {
+			t.Fatalf("missing candidate nonce %d index %d", c.nonce, c.index)
+		}
+	}
+	if stats := prover.Stats(); stats.Bytes != int64(len(batch)) {
+		t.Fatalf("stats count %d bytes, expected %d", stats.Bytes, len(batch))
+	}
+
+	// 第一次consume返回true后应当停止
+	calls := 0
+	stopped = prover.prove(batch, 0, func(nonce uint32, index uint64) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		return true
+	})
+	if !stopped {
+		t.Fatal("prove did not report the stop")
+	}
+}
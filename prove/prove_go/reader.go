@@ -1,13 +1,16 @@
 package post_go
 
 import (
-	"github.com/ncw/directio"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
-	"regexp"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ReadBatch is a function that is called for each batch of data read from the file.
@@ -16,18 +19,48 @@ type ReadBatch func(batch *Batch) bool
 type Batch struct {
 	Data []byte
 	Pos  uint64
+
+	buf  []byte
+	pool *BufferPool
+}
+
+// Release hands the buffer of a pooled batch back to its pool. The batch must not be used afterwards.
+// It is a no-op for batches that were not read into a pooled buffer.
+func (b *Batch) Release() {
+	if b == nil || b.pool == nil {
+		return
+	}
+	b.pool.Put(b.buf)
+	b.pool = nil
+	b.buf = nil
+	b.Data = nil
 }
 
 type BatchingReader struct {
-	reader      *os.File
+	reader      EngineFile
 	startingPos uint64
 	pos         uint64
 	batchSize   int
 	totalSize   uint64
+	pool        *BufferPool
+	throttle    *Throttle
+	// 所属文件，用于错误信息和统计
+	index      int
+	name       string
+	retries    int
+	backoff    time.Duration
+	monitor    *ReadMonitor
+	skipErrors bool
 	//tempData    []byte
 }
 
 func NewBatchingReader(reader *os.File, pos uint64, batchSize int, totalSize uint64) *BatchingReader {
+	return NewEngineBatchingReader(&osFile{File: reader, size: int64(totalSize), alignment: 1}, pos, batchSize, totalSize)
+}
+
+// NewEngineBatchingReader returns a reader for a file opened by an IOEngine. Buffers are allocated with
+// the alignment the engine asks for.
+func NewEngineBatchingReader(reader EngineFile, pos uint64, batchSize int, totalSize uint64) *BatchingReader {
 	return &BatchingReader{
 		reader:      reader,
 		startingPos: pos,
@@ -38,104 +71,360 @@ func NewBatchingReader(reader *os.File, pos uint64, batchSize int, totalSize uin
 	}
 }
 
+// Next reads the next batch, nil at the end of the file. A failed read is retried with backoff; once
+// the retries are exhausted Next returns a *ReadError, or skips the batch when the reader is configured
+// to skip errors.
 func (r *BatchingReader) Next() (*Batch, error) {
-	posInFile := r.pos - r.startingPos
-	if posInFile >= r.totalSize {
-		return nil, nil
+	for {
+		posInFile := r.pos - r.startingPos
+		if posInFile >= r.totalSize {
+			return nil, nil
+		}
+		remaining := r.totalSize - posInFile
+		batchSize := r.batchSize
+		if batchSize > int(remaining) {
+			batchSize = int(remaining)
+		}
+		alignment := r.reader.Alignment()
+		// O_DIRECT 要求读取长度对齐，文件末尾会短读
+		var data []byte
+		if r.pool != nil {
+			data = r.pool.Get()[:alignUp(batchSize, alignment)]
+		} else {
+			data = alignedBuffer(alignUp(batchSize, alignment), alignment)
+		}
+		n, attempts, err := r.readRetry(data, posInFile, batchSize)
+		if err != nil || n == 0 {
+			if r.pool != nil {
+				r.pool.Put(data)
+			}
+			if err == nil {
+				return nil, nil
+			}
+			readErr := r.readError(posInFile, batchSize, attempts, err)
+			r.monitor.fail(readErr)
+			if r.skipErrors {
+				r.pos += uint64(batchSize)
+				continue
+			}
+			return nil, readErr
+		}
+		if n > batchSize {
+			n = batchSize
+		}
+		r.throttle.WaitRead(n)
+		batch := &Batch{
+			Data: data[:n],
+			Pos:  r.pos,
+			buf:  data,
+			pool: r.pool,
+		}
+		r.pos += uint64(n)
+		return batch, nil
 	}
-	remaining := r.totalSize - posInFile
-	batchSize := r.batchSize
-	if batchSize > int(remaining) {
-		batchSize = int(remaining)
+}
+
+// readRetry reads batchSize bytes at posInFile into data, retrying failed reads. It returns the bytes
+// read, fewer only at the end of the file, and the number of attempts.
+func (r *BatchingReader) readRetry(data []byte, posInFile uint64, batchSize int) (int, int, error) {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		n, err := r.readFull(data, int64(posInFile), batchSize)
+		r.monitor.read(r.index, r.name, n, time.Since(start), err)
+		if err == nil || attempt > r.retries {
+			return n, attempt, err
+		}
+		r.monitor.retry(r.index, r.name)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
-	data := make([]byte, batchSize)
-	n, err := r.reader.Read(data)
-	if err != nil {
-		return nil, err
+}
+
+// readFull reads until want bytes are in data or the file ends. Engines may return short reads without
+// an error; they are continued as long as the position stays aligned.
+func (r *BatchingReader) readFull(data []byte, off int64, want int) (int, error) {
+	alignment := r.reader.Alignment()
+	got := 0
+	for got < want {
+		n, err := r.reader.ReadAt(data[got:], off+int64(got))
+		got += n
+		if err == io.EOF {
+			return got, nil
+		}
+		if err != nil {
+			return got, err
+		}
+		if n == 0 {
+			return got, io.ErrNoProgress
+		}
+		if got < want && got%alignment != 0 {
+			return got, fmt.Errorf("short read of %d bytes not aligned to %d", got, alignment)
+		}
 	}
-	if n == 0 {
-		return nil, nil
+	return got, nil
+}
+
+// readError describes the failed read of length bytes at posInFile.
+func (r *BatchingReader) readError(posInFile uint64, length, attempts int, err error) *ReadError {
+	pos := r.startingPos + posInFile
+	return &ReadError{
+		File:       r.name,
+		Offset:     posInFile,
+		Length:     length,
+		FirstLabel: pos / LABEL_SIZE,
+		LastLabel:  (pos + uint64(length) - 1) / LABEL_SIZE,
+		Attempts:   attempts,
+		Cause:      err.Error(),
+		Err:        err,
 	}
-	batch := &Batch{
-		Data: data,
-		Pos:  r.pos,
+}
+
+// configure applies the per-reader settings of opts to the reader of file index.
+func (r *BatchingReader) configure(opts ReaderOptions, index int, name string) {
+	r.index = index
+	r.name = name
+	r.throttle = opts.Throttle
+	r.monitor = opts.Monitor
+	r.skipErrors = opts.SkipErrors
+	r.retries = opts.Retries
+	if r.retries < 0 {
+		r.retries = 0
 	}
-	r.pos += uint64(n)
-	return batch, nil
+	r.backoff = opts.RetryBackoff
+	r.pos += opts.StartOffsets[index]
 }
 
+// PosFiles returns the postdata_N.bin files of datadir ordered by N. Use posFileIndex for N, files
+// may be missing, see shared.Layout. Files a manifest places in other directories are not listed,
+// shared.ListInitFiles includes them.
 func PosFiles(datadir string) ([]os.FileInfo, error) {
-	fileRe := regexp.MustCompile(`postdata_(\d+)\.bin`)
 	entries, err := ioutil.ReadDir(datadir)
 	if err != nil {
 		return nil, err
 	}
 	var dirEntries []os.FileInfo
 	for _, entry := range entries {
-		if fileRe.MatchString(entry.Name()) {
+		if shared.IsInitFile(entry) {
 			dirEntries = append(dirEntries, entry)
 		}
 	}
 	sort.Slice(dirEntries, func(i, j int) bool {
-		id1 := fileRe.FindStringSubmatch(dirEntries[i].Name())[1]
-		id2 := fileRe.FindStringSubmatch(dirEntries[j].Name())[1]
-		return id1 < id2
+		return posFileIndex(dirEntries[i]) < posFileIndex(dirEntries[j])
 	})
 	return dirEntries, nil
 }
 
+// posFileIndex returns N of a file listed by PosFiles.
+func posFileIndex(entry os.FileInfo) int {
+	index, _ := shared.ParseInitFileIndex(entry.Name())
+	return index
+}
+
+// ReaderOptions controls how the post data files of a data dir are read.
+type ReaderOptions struct {
+	// Parallel is the number of files read concurrently. Values below 2 read the files one after another.
+	Parallel int
+	// PerDevice caps the number of files read concurrently from the same device, 0 means no cap.
+	// Files reached through symlinks are accounted to the device they actually live on.
+	PerDevice int
+	// Engine is the name of the IOEngine used to read the files. Empty means EngineDirect,
+	// EngineAuto probes the data dir for the fastest engine.
+	Engine string
+	// Buffers is the number of batch buffers kept in the pool, it bounds the memory used by a scan to
+	// Buffers * batchSize. Batches have to be released with Batch.Release once they are processed.
+	// 0 allocates a fresh buffer for every batch.
+	Buffers int
+	// StartOffsets maps a file id, the N of postdata_N.bin, to the offset in the file reading starts at.
+	// Offsets have to be multiples of the batch size. Files without an entry are read from the start.
+	StartOffsets map[int]uint64
+	// Throttle caps the read rate of all files together, nil reads flat out. Its profile can be changed
+	// while the data is read.
+	Throttle *Throttle
+	// Retries is how often a failed read is retried, waiting RetryBackoff before the first retry and
+	// twice as long before every further one.
+	Retries      int
+	RetryBackoff time.Duration
+	// Monitor, if set, collects per-file read statistics and failed reads, see ReadMonitor.Report.
+	Monitor *ReadMonitor
+	// SkipErrors skips batches that still fail after the retries instead of aborting the read. The
+	// failures are only visible through Monitor, so this is meant for diagnostics, not for proving.
+	SkipErrors bool
+}
+
+// DefaultReaderOptions returns the options used by ReadData: one file at a time, no buffer pool.
+func DefaultReaderOptions() ReaderOptions {
+	return ReaderOptions{
+		Parallel:     1,
+		PerDevice:    0,
+		Engine:       EngineDirect,
+		Buffers:      0,
+		Retries:      DefaultReadRetries,
+		RetryBackoff: DefaultRetryBackoff,
+	}
+}
+
 // ReadData reads all the data from the given directory and calls the given function for each batch of data read.
 func ReadData(datadir string, batchSize int, fileSize uint64, fn ReadBatch) error {
-	dirEntries, err := PosFiles(datadir)
+	return ReadDataWithOptions(datadir, batchSize, fileSize, DefaultReaderOptions(), fn)
+}
+
+// ReadDataWithOptions works like ReadData but reads up to opts.Parallel files at the same time.
+// When more than one file is read concurrently fn is called from several goroutines and must be
+// safe for concurrent use. Batches of one file are always delivered in order.
+// With opts.Buffers set fn owns every batch it is given, including the one it rejects by returning
+// false, and has to call Batch.Release on it; otherwise the readers stall waiting for free buffers.
+func ReadDataWithOptions(datadir string, batchSize int, fileSize uint64, opts ReaderOptions, fn ReadBatch) error {
+	files, err := shared.ListInitFiles(datadir)
+	if err != nil {
+		return err
+	}
+	engine, err := openEngine(datadir, opts.Engine)
 	if err != nil {
 		return err
 	}
 	var readers []*BatchingReader
-	for id, entry := range dirEntries {
-		pos := uint64(id) * fileSize
-		file, err := directio.OpenFile(path.Join(datadir, entry.Name()), os.O_RDONLY, 0666)
-		//file, err := os.Open(path.Join(datadir, entry.Name()))
-		if err != nil {
-			return err
+	var devices []uint64
+	defer func() {
+		for _, reader := range readers {
+			if reader.reader != nil {
+				_ = reader.reader.Close()
+			}
 		}
-		fileInfo, err := file.Stat()
+	}()
+	for i, entry := range files {
+		id := entry.Index
+		pos := uint64(id) * fileSize
+		filename := entry.Path
+		file, err := engine.Open(filename)
 		if err != nil {
 			return err
 		}
-		posFileSize := uint64(fileInfo.Size())
-		if len(dirEntries) > id+1 && posFileSize != fileSize {
+		posFileSize := uint64(file.Size())
+		if len(files) > i+1 && posFileSize != fileSize {
 			log.Printf("invalid POS file, expected size: %d vs actual size: %d\n", fileSize, posFileSize)
 		}
-		reader := NewBatchingReader(file, pos, batchSize, posFileSize)
+		reader := NewEngineBatchingReader(file, pos, batchSize, posFileSize)
+		reader.configure(opts, id, filename)
 		readers = append(readers, reader)
+		devices = append(devices, deviceID(filename))
 	}
-	defer func() {
+	return readFiles(readers, devices, batchSize, opts, fn)
+}
+
+// readFiles drains the readers, sharing a buffer pool between them when opts.Buffers is set, and
+// calls fn with nil once all of them are done.
+func readFiles(readers []*BatchingReader, devices []uint64, batchSize int, opts ReaderOptions, fn ReadBatch) error {
+	if opts.Buffers > 0 && len(readers) > 0 {
+		alignment := 1
 		for _, reader := range readers {
-			if reader.reader != nil {
-				_ = reader.reader.Close()
+			if reader.reader.Alignment() > alignment {
+				alignment = reader.reader.Alignment()
 			}
 		}
-	}()
-	for _, reader := range readers {
-		for {
-			batch, err := reader.Next()
-			if err != nil {
-				return err
-			}
-			if batch == nil {
-				break
-			}
-			if !fn(batch) {
-				return nil
+		pool := NewBufferPool(opts.Buffers, alignUp(batchSize, alignment), alignment)
+		for _, reader := range readers {
+			reader.pool = pool
+		}
+	}
+
+	if opts.Parallel < 2 || len(readers) < 2 {
+		for _, reader := range readers {
+			for {
+				batch, err := reader.Next()
+				if err != nil {
+					return err
+				}
+				if batch == nil {
+					break
+				}
+				if !fn(batch) {
+					return nil
+				}
 			}
 		}
+		// 发送结束标记
+		fn(nil)
+		return nil
 	}
 
+	stopped, err := readConcurrent(readers, devices, opts, fn)
+	if err != nil || stopped {
+		return err
+	}
 	// 发送结束标记
 	fn(nil)
 	return nil
 }
 
+// openEngine resolves the engine name of ReaderOptions for datadir.
+func openEngine(datadir, name string) (IOEngine, error) {
+	switch name {
+	case "":
+		return IOEngineByName(EngineDirect)
+	case EngineAuto:
+		return ProbeIOEngine(datadir)
+	default:
+		return IOEngineByName(name)
+	}
+}
+
+// readConcurrent drains the readers with at most opts.Parallel of them active at once and at most
+// opts.PerDevice active per device. It reports whether fn asked to stop.
+func readConcurrent(readers []*BatchingReader, devices []uint64, opts ReaderOptions, fn ReadBatch) (bool, error) {
+	var (
+		stop     int32
+		job      sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	slots := make(chan struct{}, opts.Parallel)
+	deviceSlots := make(map[uint64]chan struct{})
+	if opts.PerDevice > 0 {
+		for _, dev := range devices {
+			if _, ok := deviceSlots[dev]; !ok {
+				deviceSlots[dev] = make(chan struct{}, opts.PerDevice)
+			}
+		}
+	}
+
+	for i, reader := range readers {
+		job.Add(1)
+		go func(reader *BatchingReader, device chan struct{}) {
+			defer job.Done()
+			// 先占用设备，再占用全局并发，顺序固定避免死锁
+			if device != nil {
+				device <- struct{}{}
+				defer func() { <-device }()
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			for atomic.LoadInt32(&stop) == 0 {
+				batch, err := reader.Next()
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					atomic.StoreInt32(&stop, 1)
+					return
+				}
+				if batch == nil {
+					return
+				}
+				if !fn(batch) {
+					atomic.StoreInt32(&stop, 1)
+					return
+				}
+			}
+		}(reader, deviceSlots[devices[i]])
+	}
+	job.Wait()
+
+	if firstErr != nil {
+		return true, firstErr
+	}
+	return atomic.LoadInt32(&stop) != 0, nil
+}
+
 func ReadFrom(reader *os.File, batchSize int, maxSize uint64) ([]*Batch, error) {
 	batchingReader := NewBatchingReader(reader, 0, batchSize, maxSize)
 	var batches []*Batch
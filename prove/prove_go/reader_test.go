@@ -1,6 +1,12 @@
 package post_go
 
-import "testing"
+import (
+	"github.com/trying2016/post-go/shared"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
 
 func TestNewBatchingReader(t *testing.T) {
 	err := ReadData("/Volumes/172.16.7.43/post_91ca4e37742c565193b0bbdb5a4f36b99a3e9ac8bc3c7d8e56e1b8bcb0c50b0e", 1024*1024, 32*1024*1024*1024, func(batch *Batch) bool {
@@ -11,3 +17,125 @@ func TestNewBatchingReader(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func writePosFiles(t *testing.T, dir string, count int, fileSize int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		data := make([]byte, fileSize)
+		for j := range data {
+			data[j] = byte(i)
+		}
+		if err := os.WriteFile(filepath.Join(dir, shared.InitFileName(i)), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadDataWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	const fileSize = 64 * 1024
+	writePosFiles(t, dir, 4, fileSize)
+
+	var lock sync.Mutex
+	seen := make(map[uint64]bool)
+	ends := 0
+	err := ReadDataWithOptions(dir, 4096, fileSize, ReaderOptions{Parallel: 3, PerDevice: 2}, func(batch *Batch) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		if batch == nil {
+			ends++
+			return true
+		}
+		if batch.Data[0] != byte(batch.Pos/fileSize) {
+			t.Errorf("batch at %d carries data of file %d", batch.Pos, batch.Data[0])
+		}
+		seen[batch.Pos] = true
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 4*fileSize/4096 {
+		t.Fatalf("expected %d batches, got %d", 4*fileSize/4096, len(seen))
+	}
+	if ends != 1 {
+		t.Fatalf("expected one end marker, got %d", ends)
+	}
+}
+
+func TestReadDataNumericOrder(t *testing.T) {
+	dir := t.TempDir()
+	const fileSize = 4096
+	writePosFiles(t, dir, 12, fileSize)
+
+	entries, err := PosFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if entry.Name() != shared.InitFileName(i) {
+			t.Fatalf("file %d is %s", i, entry.Name())
+		}
+	}
+	// 文件10及以后的数据必须位于对应的位置
+	err = ReadData(dir, fileSize, fileSize, func(batch *Batch) bool {
+		if batch != nil && batch.Data[0] != byte(batch.Pos/fileSize) {
+			t.Errorf("batch at %d carries data of file %d", batch.Pos, batch.Data[0])
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadDataBufferPool(t *testing.T) {
+	dir := t.TempDir()
+	const fileSize = 64 * 1024
+	writePosFiles(t, dir, 3, fileSize)
+
+	var lock sync.Mutex
+	var pool *BufferPool
+	inUse, maxInUse, total := 0, 0, 0
+	batches := make(chan *Batch, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for batch := range batches {
+			lock.Lock()
+			inUse--
+			lock.Unlock()
+			batch.Release()
+		}
+	}()
+	opts := ReaderOptions{Parallel: 3, Buffers: 2}
+	err := ReadDataWithOptions(dir, 4096, fileSize, opts, func(batch *Batch) bool {
+		if batch == nil {
+			return true
+		}
+		lock.Lock()
+		pool = batch.pool
+		inUse++
+		total++
+		if inUse > maxInUse {
+			maxInUse = inUse
+		}
+		lock.Unlock()
+		batches <- batch
+		return true
+	})
+	close(batches)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3*fileSize/4096 {
+		t.Fatalf("expected %d batches, got %d", 3*fileSize/4096, total)
+	}
+	if maxInUse > opts.Buffers {
+		t.Fatalf("%d batches in use with a pool of %d buffers", maxInUse, opts.Buffers)
+	}
+	if pool == nil || pool.Available() != pool.Cap() {
+		t.Fatal("not all buffers returned to the pool")
+	}
+}
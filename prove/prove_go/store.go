@@ -0,0 +1,67 @@
+package post_go
+
+import (
+	"errors"
+	"github.com/trying2016/post-go/shared"
+	"github.com/trying2016/post-go/store"
+	"log"
+)
+
+// storeFile adapts a file of a LabelStore to EngineFile.
+type storeFile struct {
+	s     store.LabelStore
+	index int
+	size  int64
+}
+
+func (f *storeFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.s.ReadAt(f.index, p, off)
+}
+
+// Close is a no-op, the files belong to the store.
+func (f *storeFile) Close() error {
+	return nil
+}
+
+func (f *storeFile) Size() int64 {
+	return f.size
+}
+
+func (f *storeFile) Alignment() int {
+	return 1
+}
+
+// ReadStoreWithOptions works like ReadDataWithOptions but reads the post data files of s. opts.Engine
+// and opts.PerDevice do not apply to stores and are ignored.
+func ReadStoreWithOptions(s store.LabelStore, batchSize int, fileSize uint64, opts ReaderOptions, fn ReadBatch) error {
+	files, err := s.Files()
+	if err != nil {
+		return err
+	}
+	var readers []*BatchingReader
+	var devices []uint64
+	for i, file := range files {
+		if len(files) > i+1 && uint64(file.Size) != fileSize {
+			log.Printf("invalid POS file, expected size: %d vs actual size: %d\n", fileSize, file.Size)
+		}
+		reader := NewEngineBatchingReader(&storeFile{s: s, index: file.Index, size: file.Size}, uint64(file.Index)*fileSize, batchSize, uint64(file.Size))
+		reader.configure(opts, file.Index, shared.InitFileName(file.Index))
+		readers = append(readers, reader)
+		// 每个文件视为独立设备，只受opts.Parallel限制
+		devices = append(devices, uint64(i))
+	}
+	opts.PerDevice = 0
+	return readFiles(readers, devices, batchSize, opts, fn)
+}
+
+// MeasureStore returns the read throughput of the first post data file of s.
+func MeasureStore(s store.LabelStore) (float64, error) {
+	files, err := s.Files()
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, errors.New("no post data files in store")
+	}
+	return probeFile(&storeFile{s: s, index: files[0].Index, size: files[0].Size}, 0)
+}
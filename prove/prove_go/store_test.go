@@ -0,0 +1,45 @@
+package post_go
+
+import (
+	"crypto/sha256"
+	"github.com/trying2016/post-go/shared"
+	"github.com/trying2016/post-go/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerateProofFromStore(t *testing.T) {
+	SetRandomxCallback(fakePow)
+	const (
+		numUnits      = 2
+		labelsPerUnit = 4096
+	)
+	dir, labels := newTestPost(t, numUnits, labelsPerUnit, 48*1024)
+	metadata, err := shared.ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory, err := store.NewMemory(metadata, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	stores := map[string]store.LabelStore{
+		"memory": memory,
+		"http":   store.NewHTTP(server.URL, server.Client()),
+	}
+	challenge := sha256.Sum256([]byte("store"))
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			proof, err := GenerateProof("", challenge[:], 16, shared.K1, shared.K2, TestNetPowDifficulty, 2,
+				WithStore(s), WithBatchSize(16*1024), WithReaderOptions(ReaderOptions{Parallel: 2, Buffers: 4}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkProof(t, proof, challenge[:], labels, numUnits*labelsPerUnit, shared.K1, shared.K2)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package post_go
+
+import (
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Throttle profile names accepted by ThrottleProfileByName.
+const (
+	// ThrottleEco keeps proving in the background: a slow disk rate and a quarter of the cores.
+	ThrottleEco = "eco"
+	// ThrottleBalanced leaves about half of the disk and the cores to other services.
+	ThrottleBalanced = "balanced"
+	// ThrottleMax reads and computes flat out.
+	ThrottleMax = "max"
+)
+
+// ThrottleProfile caps the resources used while proving. Zero values mean no cap.
+type ThrottleProfile struct {
+	Name string
+	// ReadBytesPerSec caps the disk read rate summed over all files read concurrently.
+	ReadBytesPerSec int64
+	// ProverThreads caps the number of prover workers encrypting at the same time.
+	ProverThreads int
+	// RandomXThreads caps the threads used to compute the k2pow of the nonce groups. The Throttle does
+	// not apply it, the RandomX instance is shared by the process and its owner sets the limit.
+	RandomXThreads int
+}
+
+// ThrottleProfileByName returns the predefined profile name, sized for the cores of this machine.
+func ThrottleProfileByName(name string) (ThrottleProfile, error) {
+	cpus := runtime.NumCPU()
+	switch name {
+	case ThrottleEco:
+		return ThrottleProfile{
+			Name:            name,
+			ReadBytesPerSec: 100 * shared.MiB,
+			ProverThreads:   atLeastOne(cpus / 4),
+			RandomXThreads:  1,
+		}, nil
+	case ThrottleBalanced:
+		return ThrottleProfile{
+			Name:            name,
+			ReadBytesPerSec: 500 * shared.MiB,
+			ProverThreads:   atLeastOne(cpus / 2),
+			RandomXThreads:  atLeastOne(cpus / 2),
+		}, nil
+	case ThrottleMax, "":
+		return ThrottleProfile{Name: ThrottleMax}, nil
+	default:
+		return ThrottleProfile{}, fmt.Errorf("unknown throttle profile: %s", name)
+	}
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Throttle applies a ThrottleProfile to running readers and provers. The profile can be replaced at
+// any time with SetProfile, waiting readers and provers pick up the new limits right away.
+// A nil *Throttle does not limit anything.
+type Throttle struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	profile ThrottleProfile
+	// next is the time the read budget is used up until
+	next time.Time
+	// active is the number of provers holding a slot
+	active int
+	// changed is closed and replaced by SetProfile to wake up waiting readers
+	changed chan struct{}
+}
+
+// NewThrottle returns a throttle applying profile.
+func NewThrottle(profile ThrottleProfile) *Throttle {
+	t := &Throttle{profile: profile, changed: make(chan struct{})}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// SetProfile replaces the profile of a running throttle.
+func (t *Throttle) SetProfile(profile ThrottleProfile) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.profile = profile
+	// 速率变化后重新计算读盘预算
+	t.next = time.Time{}
+	close(t.changed)
+	t.changed = make(chan struct{})
+	t.cond.Broadcast()
+}
+
+// Profile returns the profile currently applied.
+func (t *Throttle) Profile() ThrottleProfile {
+	if t == nil {
+		return ThrottleProfile{Name: ThrottleMax}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.profile
+}
+
+// WaitRead accounts n bytes read and waits as long as needed to keep the read rate of all callers
+// below the profile's ReadBytesPerSec. A waiting reader is woken up by SetProfile and queues again
+// under the new rate.
+func (t *Throttle) WaitRead(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	for {
+		t.mu.Lock()
+		rate := t.profile.ReadBytesPerSec
+		if rate <= 0 {
+			t.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		if t.next.Before(now) {
+			t.next = now
+		}
+		wait := t.next.Sub(now)
+		t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+		changed := t.changed
+		t.mu.Unlock()
+		if wait <= 0 {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			return
+		case <-changed:
+			// SetProfile清空了读盘预算，按新速率重新排队
+			timer.Stop()
+		}
+	}
+}
+
+// AcquireProver blocks until a prover slot is free under the profile's ProverThreads.
+// Every call has to be paired with ReleaseProver.
+func (t *Throttle) AcquireProver() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.profile.ProverThreads > 0 && t.active >= t.profile.ProverThreads {
+		t.cond.Wait()
+	}
+	t.active++
+}
+
+// ReleaseProver frees a slot taken with AcquireProver.
+func (t *Throttle) ReleaseProver() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	t.cond.Signal()
+}
@@ -0,0 +1,114 @@
+package post_go
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottleProfiles(t *testing.T) {
+	for _, name := range []string{ThrottleEco, ThrottleBalanced, ThrottleMax} {
+		profile, err := ThrottleProfileByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.Name != name {
+			t.Fatalf("profile %s has name %s", name, profile.Name)
+		}
+	}
+	if _, err := ThrottleProfileByName("turbo"); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
+
+func TestThrottleReadRate(t *testing.T) {
+	dir := t.TempDir()
+	const fileSize = 256 * 1024
+	writePosFiles(t, dir, 2, fileSize)
+
+	// 512KiB 以 1MiB/s 读取，至少需要约0.5秒
+	throttle := NewThrottle(ThrottleProfile{Name: "test", ReadBytesPerSec: 1024 * 1024})
+	opts := DefaultReaderOptions()
+	opts.Engine = EngineBuffered
+	opts.Throttle = throttle
+	start := time.Now()
+	err := ReadDataWithOptions(dir, 64*1024, fileSize, opts, func(batch *Batch) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("read 512KiB at 1MiB/s in %v", elapsed)
+	}
+
+	// 运行中切换到max后不再限速
+	throttle.SetProfile(ThrottleProfile{Name: ThrottleMax})
+	start = time.Now()
+	err = ReadDataWithOptions(dir, 64*1024, fileSize, opts, func(batch *Batch) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("unthrottled read took %v", elapsed)
+	}
+}
+
+func TestThrottleWakeOnProfileChange(t *testing.T) {
+	// 1KiB/s下第二次读要等10秒
+	throttle := NewThrottle(ThrottleProfile{Name: "test", ReadBytesPerSec: 1024})
+	throttle.WaitRead(10 * 1024)
+	done := make(chan struct{})
+	go func() {
+		throttle.WaitRead(1024)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	throttle.SetProfile(ThrottleProfile{Name: ThrottleMax})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waiting reader not woken up by SetProfile")
+	}
+}
+
+func TestThrottleProverThreads(t *testing.T) {
+	throttle := NewThrottle(ThrottleProfile{Name: "test", ProverThreads: 2})
+	var active, peak int32
+	var job sync.WaitGroup
+	run := func(n int) {
+		for i := 0; i < n; i++ {
+			job.Add(1)
+			go func() {
+				defer job.Done()
+				throttle.AcquireProver()
+				defer throttle.ReleaseProver()
+				cur := atomic.AddInt32(&active, 1)
+				for {
+					old := atomic.LoadInt32(&peak)
+					if cur <= old || atomic.CompareAndSwapInt32(&peak, old, cur) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&active, -1)
+			}()
+		}
+		job.Wait()
+	}
+
+	run(8)
+	if peak > 2 {
+		t.Fatalf("%d provers ran at the same time, limit 2", peak)
+	}
+
+	peak = 0
+	throttle.SetProfile(ThrottleProfile{Name: "test", ProverThreads: 4})
+	run(16)
+	if peak > 4 || peak < 3 {
+		t.Fatalf("%d provers ran at the same time, limit 4", peak)
+	}
+}
@@ -0,0 +1,137 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CompatibilityError lists every mismatch between a data dir and the configuration it is used with.
+type CompatibilityError struct {
+	DataDir    string
+	Mismatches []ConfigMismatchError
+}
+
+func (err *CompatibilityError) Error() string {
+	mismatches := make([]string, 0, len(err.Mismatches))
+	for _, m := range err.Mismatches {
+		mismatches = append(mismatches, fmt.Sprintf("`%v` expected: %v, found: %v", m.Param, m.Expected, m.Found))
+	}
+	return fmt.Sprintf("config mismatch in %s: %s", err.DataDir, strings.Join(mismatches, "; "))
+}
+
+// As makes errors.As find the first mismatch as a ConfigMismatchError.
+func (err *CompatibilityError) As(target interface{}) bool {
+	if t, ok := target.(*ConfigMismatchError); ok && len(err.Mismatches) > 0 {
+		*t = err.Mismatches[0]
+		return true
+	}
+	return false
+}
+
+type compatibilityOption struct {
+	nodeId          []byte
+	commitmentAtxId []byte
+}
+
+type CompatibilityOptionFunc func(*compatibilityOption) error
+
+// WithNodeId expects the data to be initialized for nodeId.
+func WithNodeId(nodeId []byte) CompatibilityOptionFunc {
+	return func(opts *compatibilityOption) error {
+		if len(nodeId) != 32 {
+			return fmt.Errorf("invalid NodeId length; expected: 32, given: %d", len(nodeId))
+		}
+		opts.nodeId = nodeId
+		return nil
+	}
+}
+
+// WithCommitmentAtxId expects the data to be initialized for commitmentAtxId.
+func WithCommitmentAtxId(commitmentAtxId []byte) CompatibilityOptionFunc {
+	return func(opts *compatibilityOption) error {
+		if len(commitmentAtxId) != 32 {
+			return fmt.Errorf("invalid CommitmentAtxId length; expected: 32, given: %d", len(commitmentAtxId))
+		}
+		opts.commitmentAtxId = commitmentAtxId
+		return nil
+	}
+}
+
+// CheckCompatibility compares the metadata and the post data files of dataDir with cfg and opts and
+// returns a *CompatibilityError listing every mismatch. Zero values of cfg and opts are not compared,
+// so callers pass only what they know; DataDir, ProviderID, Throttle and ComputeBatchSize never apply.
+// It returns ErrInitNotStarted when dataDir has no metadata. GenerateProof calls it before proving.
+func CheckCompatibility(dataDir string, cfg Config, opts InitOpts, checks ...CompatibilityOptionFunc) error {
+	options := &compatibilityOption{}
+	for _, check := range checks {
+		if err := check(options); err != nil {
+			return err
+		}
+	}
+	metadata, err := ReadMetadata(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrInitNotStarted
+	}
+	if err != nil {
+		return fmt.Errorf("loading metadata: %w", err)
+	}
+
+	var mismatches []ConfigMismatchError
+	mismatch := func(param string, expected, found interface{}) {
+		mismatches = append(mismatches, ConfigMismatchError{
+			Param:    param,
+			Expected: fmt.Sprint(expected),
+			Found:    fmt.Sprint(found),
+			DataDir:  dataDir,
+		})
+	}
+	if options.nodeId != nil && !bytes.Equal(options.nodeId, metadata.NodeId) {
+		mismatch("NodeId", hex.EncodeToString(options.nodeId), hex.EncodeToString(metadata.NodeId))
+	}
+	if options.commitmentAtxId != nil && !bytes.Equal(options.commitmentAtxId, metadata.CommitmentAtxId) {
+		mismatch("CommitmentAtxId", hex.EncodeToString(options.commitmentAtxId), hex.EncodeToString(metadata.CommitmentAtxId))
+	}
+	if cfg.LabelsPerUnit != 0 && cfg.LabelsPerUnit != metadata.LabelsPerUnit {
+		mismatch("LabelsPerUnit", cfg.LabelsPerUnit, metadata.LabelsPerUnit)
+	}
+	if cfg.MinNumUnits != 0 && metadata.NumUnits < cfg.MinNumUnits {
+		mismatch("NumUnits", fmt.Sprintf(">= %d", cfg.MinNumUnits), metadata.NumUnits)
+	}
+	if cfg.MaxNumUnits != 0 && metadata.NumUnits > cfg.MaxNumUnits {
+		mismatch("NumUnits", fmt.Sprintf("<= %d", cfg.MaxNumUnits), metadata.NumUnits)
+	}
+	if opts.NumUnits != 0 && opts.NumUnits != metadata.NumUnits {
+		mismatch("NumUnits", opts.NumUnits, metadata.NumUnits)
+	}
+	if opts.MaxFileSize != 0 && opts.MaxFileSize != metadata.MaxFileSize {
+		mismatch("MaxFileSize", opts.MaxFileSize, metadata.MaxFileSize)
+	}
+	if opts.Scrypt != (ScryptParams{}) && metadata.Scrypt != nil && opts.Scrypt != *metadata.Scrypt {
+		mismatch("Scrypt", opts.Scrypt, *metadata.Scrypt)
+	}
+
+	layout, err := NewLayout(dataDir, metadata)
+	if err != nil {
+		return fmt.Errorf("listing post data: %w", err)
+	}
+	for _, problem := range layout.Problems() {
+		switch problem.Kind {
+		case LayoutTempFile:
+			continue
+		case LayoutMissingFile:
+			mismatch(problem.File, "present", "missing")
+		case LayoutSizeMismatch:
+			mismatch(problem.File, fmt.Sprintf("%d bytes", problem.Expected), fmt.Sprintf("%d bytes", problem.Found))
+		default:
+			mismatch(problem.File, "absent", problem.Kind)
+		}
+	}
+	if len(mismatches) > 0 {
+		return &CompatibilityError{DataDir: dataDir, Mismatches: mismatches}
+	}
+	return nil
+}
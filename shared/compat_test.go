@@ -0,0 +1,58 @@
+package shared
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	dir := t.TempDir()
+	if err := CheckCompatibility(dir, Config{}, InitOpts{}); !errors.Is(err, ErrInitNotStarted) {
+		t.Fatalf("expected ErrInitNotStarted, got %v", err)
+	}
+
+	nodeId := make([]byte, 32)
+	nodeId[0] = 1
+	metadata := &PostMetadata{NodeId: nodeId, CommitmentAtxId: make([]byte, 32), LabelsPerUnit: 100, NumUnits: 2, MaxFileSize: 480}
+	if err := WriteMetadata(dir, metadata); err != nil {
+		t.Fatal(err)
+	}
+	labels := make([]byte, 200*LabelLength)
+	writeLayout(t, dir, labels, 480, -1)
+
+	cfg := Config{MinNumUnits: 1, MaxNumUnits: 10, LabelsPerUnit: 100}
+	opts := InitOpts{NumUnits: 2, MaxFileSize: 480, Scrypt: DefaultLabelParams()}
+	if err := CheckCompatibility(dir, cfg, opts, WithNodeId(nodeId), WithCommitmentAtxId(make([]byte, 32))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 最后一个文件不完整，后面的文件缺失
+	if err := os.Remove(filepath.Join(dir, InitFileName(6))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(dir, InitFileName(5)), 100); err != nil {
+		t.Fatal(err)
+	}
+	opts.NumUnits = 3
+	opts.Scrypt.N = 16
+	err := CheckCompatibility(dir, Config{LabelsPerUnit: 64}, opts, WithNodeId(make([]byte, 32)))
+	var compatErr *CompatibilityError
+	if !errors.As(err, &compatErr) {
+		t.Fatalf("expected a compatibility error, got %v", err)
+	}
+	params := map[string]bool{}
+	for _, m := range compatErr.Mismatches {
+		params[m.Param] = true
+	}
+	for _, param := range []string{"NodeId", "LabelsPerUnit", "NumUnits", "Scrypt", InitFileName(5), InitFileName(6)} {
+		if !params[param] {
+			t.Errorf("mismatch of %s not reported: %v", param, err)
+		}
+	}
+	var mismatch ConfigMismatchError
+	if !errors.As(err, &mismatch) || mismatch.DataDir != dir {
+		t.Fatalf("expected a ConfigMismatchError, got %v", err)
+	}
+}
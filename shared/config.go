@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/trying2016/post-go/codec"
 	"os"
 	"path/filepath"
 )
@@ -105,6 +106,31 @@ func (p *ScryptParams) Validate() error {
 	return nil
 }
 
+// EncodeScale implements scale codec interface.
+func (p *ScryptParams) EncodeScale(enc *codec.Encoder) (total int, err error) {
+	for _, v := range []uint{p.N, p.R, p.P} {
+		n, err := codec.EncodeCompact64(enc, uint64(v))
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// DecodeScale implements scale codec interface.
+func (p *ScryptParams) DecodeScale(dec *codec.Decoder) (total int, err error) {
+	for _, field := range []*uint{&p.N, &p.R, &p.P} {
+		v, n, err := codec.DecodeCompact64(dec)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		*field = uint(v)
+	}
+	return total, nil
+}
+
 func DefaultLabelParams() ScryptParams {
 	return ScryptParams{
 		N: 8192,
@@ -0,0 +1,252 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// EncryptedKeyName is the file holding the passphrase encrypted key, next to or instead of key.bin.
+	EncryptedKeyName = "key.json"
+	// DefaultKeyIterations is the PBKDF2 iteration count of newly encrypted keys.
+	DefaultKeyIterations = 600000
+
+	encryptedKeyVersion = 1
+	keyKDF              = "pbkdf2-sha256"
+	keyCipher           = "aes-256-gcm"
+)
+
+var (
+	// ErrWrongPassphrase is returned when an encrypted key cannot be decrypted.
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key")
+	// ErrKeyEncrypted is returned when an encrypted key is loaded without a passphrase.
+	ErrKeyEncrypted = errors.New("key is encrypted, passphrase required")
+)
+
+// keyIterations is the PBKDF2 iteration count used for encryption, tests lower it.
+var keyIterations = DefaultKeyIterations
+
+// Signer signs for a node. The private key may live outside the data dir, see FileHSM.
+type Signer interface {
+	// NodeId returns the public key, the id of the node.
+	NodeId() []byte
+	Sign(msg []byte) ([]byte, error)
+}
+
+// Identity is an ed25519 key held in memory.
+type Identity struct {
+	key ed25519.PrivateKey
+}
+
+// GenerateIdentity returns a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// NewIdentity returns the identity of a 64 byte ed25519 private key or of its 32 byte seed.
+func NewIdentity(privateKey []byte) (*Identity, error) {
+	switch len(privateKey) {
+	case ed25519.SeedSize:
+		return &Identity{key: ed25519.NewKeyFromSeed(privateKey)}, nil
+	case ed25519.PrivateKeySize:
+		key := ed25519.NewKeyFromSeed(privateKey[:ed25519.SeedSize])
+		if !bytes.Equal(key, privateKey) {
+			return nil, errors.New("invalid private key; public half does not match the seed")
+		}
+		return &Identity{key: key}, nil
+	default:
+		return nil, fmt.Errorf("invalid private key length; expected: %d or %d, given: %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(privateKey))
+	}
+}
+
+// NodeIdFromKey derives the NodeId of a private key.
+func NodeIdFromKey(privateKey []byte) ([]byte, error) {
+	id, err := NewIdentity(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return id.NodeId(), nil
+}
+
+func (id *Identity) NodeId() []byte {
+	return append([]byte(nil), id.key.Public().(ed25519.PublicKey)...)
+}
+
+func (id *Identity) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(id.key, msg), nil
+}
+
+// PrivateKey returns a copy of the 64 byte private key.
+func (id *Identity) PrivateKey() []byte {
+	return append([]byte(nil), id.key...)
+}
+
+// encryptedKey is the format of EncryptedKeyName. NodeId is authenticated with the key, so the file
+// tells whose key it is without the passphrase.
+type encryptedKey struct {
+	Version    int    `json:"version"`
+	NodeId     string `json:"node_id"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// keyCipherFor derives the AES-GCM cipher of passphrase.
+func keyCipherFor(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptKey encrypts the private key of id with a key derived from passphrase.
+func EncryptKey(id *Identity, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keyCipherFor(passphrase, salt, keyIterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	nodeId := id.NodeId()
+	return json.MarshalIndent(&encryptedKey{
+		Version:    encryptedKeyVersion,
+		NodeId:     hex.EncodeToString(nodeId),
+		KDF:        keyKDF,
+		Iterations: keyIterations,
+		Salt:       hex.EncodeToString(salt),
+		Cipher:     keyCipher,
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, id.key.Seed(), nodeId)),
+	}, "", "  ")
+}
+
+// DecryptKey decrypts a key encrypted by EncryptKey.
+func DecryptKey(data []byte, passphrase string) (*Identity, error) {
+	var k encryptedKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("decoding encrypted key: %w", err)
+	}
+	if k.Version != encryptedKeyVersion || k.KDF != keyKDF || k.Cipher != keyCipher {
+		return nil, fmt.Errorf("unsupported encrypted key; expected: version %d %s %s, given: version %d %s %s",
+			encryptedKeyVersion, keyKDF, keyCipher, k.Version, k.KDF, k.Cipher)
+	}
+	if k.Iterations <= 0 {
+		return nil, fmt.Errorf("invalid iterations; expected: > 0, given: %d", k.Iterations)
+	}
+	var fields [4][]byte
+	for i, s := range []string{k.NodeId, k.Salt, k.Nonce, k.Ciphertext} {
+		value, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("decoding encrypted key: %w", err)
+		}
+		fields[i] = value
+	}
+	nodeId, salt, nonce, ciphertext := fields[0], fields[1], fields[2], fields[3]
+	aead, err := keyCipherFor(passphrase, salt, k.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length; expected: %d, given: %d", aead.NonceSize(), len(nonce))
+	}
+	seed, err := aead.Open(nil, nonce, ciphertext, nodeId)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	id, err := NewIdentity(seed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(id.NodeId(), nodeId) {
+		return nil, ErrWrongPassphrase
+	}
+	return id, nil
+}
+
+// encryptedKeyNodeId returns the NodeId recorded in an encrypted key.
+func encryptedKeyNodeId(data []byte) ([]byte, error) {
+	var k encryptedKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("decoding encrypted key: %w", err)
+	}
+	return hex.DecodeString(k.NodeId)
+}
+
+// ExportKey returns the key of id encrypted with passphrase, or hex encoded like key.bin when the
+// passphrase is empty.
+func ExportKey(id *Identity, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return []byte(hex.EncodeToString(id.key)), nil
+	}
+	return EncryptKey(id, passphrase)
+}
+
+// ImportKey reads a key exported by ExportKey, a key.bin of go-spacemesh (raw or hex) or a 32 byte seed.
+func ImportKey(data []byte, passphrase string) (*Identity, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if passphrase == "" {
+			return nil, ErrKeyEncrypted
+		}
+		return DecryptKey(trimmed, passphrase)
+	}
+	if key, err := hex.DecodeString(string(trimmed)); err == nil && (len(key) == ed25519.SeedSize || len(key) == ed25519.PrivateKeySize) {
+		return NewIdentity(key)
+	}
+	return NewIdentity(data)
+}
+
+// pbkdf2SHA256 is PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	var counter [4]byte
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for i := 2; i <= iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
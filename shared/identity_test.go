@@ -0,0 +1,135 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	// 测试中加密不需要抗暴力破解
+	keyIterations = 16
+}
+
+func TestPBKDF2(t *testing.T) {
+	for _, tc := range []struct {
+		password, salt string
+		iterations     int
+		expected       string
+	}{
+		// RFC 7914 section 11
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tc.password), []byte(tc.salt), tc.iterations, 64))
+		if got != tc.expected {
+			t.Fatalf("pbkdf2(%s, %s, %d) = %s", tc.password, tc.salt, tc.iterations, got)
+		}
+	}
+}
+
+func TestKeyImportExport(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := id.Sign([]byte("msg"))
+	if !ed25519.Verify(id.NodeId(), []byte("msg"), sig) || !CheckPrivate(id.PrivateKey(), id.NodeId()) {
+		t.Fatal("NodeId is not the public key")
+	}
+
+	for _, passphrase := range []string{"", "secret"} {
+		data, err := ExportKey(id, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := ImportKey(data, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(imported.PrivateKey(), id.PrivateKey()) {
+			t.Fatalf("key exported with passphrase %q differs after import", passphrase)
+		}
+	}
+	encrypted, _ := ExportKey(id, "secret")
+	if bytes.Contains(encrypted, []byte(hex.EncodeToString(id.key.Seed()))) {
+		t.Fatal("encrypted key contains the seed")
+	}
+	if _, err := ImportKey(encrypted, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+	}
+	if _, err := ImportKey(encrypted, ""); !errors.Is(err, ErrKeyEncrypted) {
+		t.Fatalf("expected ErrKeyEncrypted, got %v", err)
+	}
+	// go-spacemesh的key.bin以及32字节的seed
+	for _, data := range [][]byte{id.PrivateKey(), id.key.Seed(), []byte(hex.EncodeToString(id.key.Seed()))} {
+		imported, err := ImportKey(data, "")
+		if err != nil || !bytes.Equal(imported.NodeId(), id.NodeId()) {
+			t.Fatalf("import of %d bytes: %v", len(data), err)
+		}
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	id, _ := GenerateIdentity()
+	metadata := &PostMetadata{NodeId: id.NodeId(), CommitmentAtxId: make([]byte, 32), LabelsPerUnit: 100, NumUnits: 1, MaxFileSize: 1600}
+	if err := WriteMetadata(dir, metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveIdentity(dir, id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateKey(dir, "", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, KeyName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("plain key left behind after encryption")
+	}
+	if err := RotateKey(dir, "first", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIdentity(dir, "first"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("old passphrase still works: %v", err)
+	}
+	loaded, err := LoadIdentity(dir, "second")
+	if err != nil || !bytes.Equal(loaded.NodeId(), id.NodeId()) {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if nodeId, err := KeyNodeId(dir); err != nil || !bytes.Equal(nodeId, id.NodeId()) {
+		t.Fatalf("NodeId without passphrase: %x, %v", nodeId, err)
+	}
+
+	other, _ := GenerateIdentity()
+	var mismatch ConfigMismatchError
+	if err := SaveIdentity(dir, other, "second"); !errors.As(err, &mismatch) || mismatch.Param != "NodeId" {
+		t.Fatalf("key of another node accepted: %v", err)
+	}
+}
+
+func TestFileHSM(t *testing.T) {
+	hsm, err := OpenFileHSM(t.TempDir(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := hsm.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := hsm.List()
+	if err != nil || len(ids) != 1 || !bytes.Equal(ids[0], signer.NodeId()) {
+		t.Fatalf("list %x, %v", ids, err)
+	}
+	again, err := hsm.Signer(signer.NodeId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := again.Sign([]byte("msg"))
+	if err != nil || !ed25519.Verify(signer.NodeId(), []byte("msg"), sig) {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+}
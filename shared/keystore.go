@@ -0,0 +1,199 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LoadIdentity loads the key of dataDir, EncryptedKeyName if there is one and key.bin otherwise.
+func LoadIdentity(dataDir, passphrase string) (*Identity, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, EncryptedKeyName))
+	if err == nil {
+		if passphrase == "" {
+			return nil, ErrKeyEncrypted
+		}
+		return DecryptKey(data, passphrase)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ReadPrivateKey(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewIdentity(key)
+}
+
+// KeyNodeId returns the NodeId of the key stored in dataDir, without needing its passphrase.
+func KeyNodeId(dataDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, EncryptedKeyName))
+	if err == nil {
+		return encryptedKeyNodeId(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ReadPrivateKey(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return NodeIdFromKey(key)
+}
+
+// SaveIdentity stores id in dataDir, encrypted as EncryptedKeyName when passphrase is set and as hex
+// key.bin otherwise; the file of the other format is removed. A key that does not belong to the
+// NodeId of the metadata of dataDir is refused with a ConfigMismatchError.
+func SaveIdentity(dataDir string, id *Identity, passphrase string) error {
+	if err := checkKeyMetadata(dataDir, id.NodeId()); err != nil {
+		return err
+	}
+	data, err := ExportKey(id, passphrase)
+	if err != nil {
+		return err
+	}
+	name, other := KeyName, EncryptedKeyName
+	if passphrase != "" {
+		name, other = EncryptedKeyName, KeyName
+	}
+	if err := writeFileAtomic(filepath.Join(dataDir, name), data, OwnerReadWrite); err != nil {
+		return err
+	}
+	// 不留下另一种格式的旧key，尤其是加密后的明文key
+	if err := os.Remove(filepath.Join(dataDir, other)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return syncDir(dataDir)
+}
+
+// RotateKey re-encrypts the key of dataDir with newPassphrase, an empty one stores it as plain key.bin.
+// The NodeId of initialized data cannot change, so the key is checked against the metadata first.
+func RotateKey(dataDir, oldPassphrase, newPassphrase string) error {
+	id, err := LoadIdentity(dataDir, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	return SaveIdentity(dataDir, id, newPassphrase)
+}
+
+// checkKeyMetadata fails when dataDir has metadata of another node. Dirs without metadata accept any key.
+func checkKeyMetadata(dataDir string, nodeId []byte) error {
+	metadata, err := ReadMetadata(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading metadata: %w", err)
+	}
+	if !bytes.Equal(metadata.NodeId, nodeId) {
+		return ConfigMismatchError{
+			Param:    "NodeId",
+			Expected: hex.EncodeToString(metadata.NodeId),
+			Found:    hex.EncodeToString(nodeId),
+			DataDir:  dataDir,
+		}
+	}
+	return nil
+}
+
+// FileHSM stands in for a hardware security module: keys are kept encrypted in a directory of their
+// own, outside the data dirs, and only Signers leave it.
+type FileHSM struct {
+	dir        string
+	passphrase string
+	mu         sync.Mutex
+}
+
+// OpenFileHSM opens the key directory dir, creating it if needed. All keys are encrypted with passphrase.
+func OpenFileHSM(dir, passphrase string) (*FileHSM, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileHSM{dir: dir, passphrase: passphrase}, nil
+}
+
+func (h *FileHSM) keyPath(nodeId []byte) string {
+	return filepath.Join(h.dir, hex.EncodeToString(nodeId)+".json")
+}
+
+// Generate creates a new key and returns its signer.
+func (h *FileHSM) Generate() (Signer, error) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return h.Import(id)
+}
+
+// Import stores id and returns its signer.
+func (h *FileHSM) Import(id *Identity) (Signer, error) {
+	data, err := EncryptKey(id, h.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := writeFileAtomic(h.keyPath(id.NodeId()), data, OwnerReadWrite); err != nil {
+		return nil, err
+	}
+	return &hsmSigner{id: id}, nil
+}
+
+// Signer returns the signer of nodeId.
+func (h *FileHSM) Signer(nodeId []byte) (Signer, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, err := os.ReadFile(h.keyPath(nodeId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no key for node %x", nodeId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := DecryptKey(data, h.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &hsmSigner{id: id}, nil
+}
+
+// List returns the NodeIds of the stored keys.
+func (h *FileHSM) List() ([][]byte, error) {
+	entries, err := ioutil.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids [][]byte
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		if name == entry.Name() {
+			continue
+		}
+		if id, err := hex.DecodeString(name); err == nil && len(id) == 32 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// hsmSigner signs with a key of a FileHSM without exposing it.
+type hsmSigner struct {
+	id *Identity
+}
+
+func (s *hsmSigner) NodeId() []byte {
+	return s.id.NodeId()
+}
+
+func (s *hsmSigner) Sign(msg []byte) ([]byte, error) {
+	return s.id.Sign(msg)
+}
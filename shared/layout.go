@@ -0,0 +1,259 @@
+package shared
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	initFileRe    = regexp.MustCompile(`^postdata_(\d+)\.bin$`)
+	initFileTmpRe = regexp.MustCompile(`^postdata_(\d+)\.dtmp$`)
+)
+
+// ParseInitFileIndex returns N of a file named postdata_N.bin.
+func ParseInitFileIndex(name string) (int, bool) {
+	match := initFileRe.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+	index, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// LayoutFile is a post data file found in a data dir.
+type LayoutFile struct {
+	Index int
+	Name  string
+	// Path is where the file lives, in the data dir or a directory of its Manifest.
+	Path string
+	Size int64
+}
+
+// LayoutProblemKind classifies a LayoutProblem.
+type LayoutProblemKind string
+
+const (
+	// LayoutMissingFile is a file the labels need that is not in the dir.
+	LayoutMissingFile LayoutProblemKind = "missing file"
+	// LayoutSizeMismatch is a file whose size differs from the one the metadata implies.
+	LayoutSizeMismatch LayoutProblemKind = "size mismatch"
+	// LayoutExtraFile is a file beyond the labels of the metadata.
+	LayoutExtraFile LayoutProblemKind = "extra file"
+	// LayoutTempFile is a .dtmp file left behind by an interrupted init.
+	LayoutTempFile LayoutProblemKind = "leftover temp file"
+	// LayoutShadowedFile is a file of the data dir the manifest places elsewhere, e.g. left behind by an
+	// interrupted move.
+	LayoutShadowedFile LayoutProblemKind = "shadowed file"
+)
+
+// LayoutProblem is an inconsistency between the files of a data dir and its metadata.
+type LayoutProblem struct {
+	Kind LayoutProblemKind
+	File string
+	// Expected and Found are the sizes of a LayoutSizeMismatch.
+	Expected int64
+	Found    int64
+}
+
+func (p LayoutProblem) String() string {
+	if p.Kind == LayoutSizeMismatch {
+		return fmt.Sprintf("%s: %s; expected: %d, found: %d", p.Kind, p.File, p.Expected, p.Found)
+	}
+	return fmt.Sprintf("%s: %s", p.Kind, p.File)
+}
+
+// LayoutError lists the problems of a data dir.
+type LayoutError struct {
+	Dir      string
+	Problems []LayoutProblem
+}
+
+func (err *LayoutError) Error() string {
+	problems := make([]string, 0, len(err.Problems))
+	for _, p := range err.Problems {
+		problems = append(problems, p.String())
+	}
+	return fmt.Sprintf("invalid post data layout in %s: %s", err.Dir, strings.Join(problems, ", "))
+}
+
+// Layout maps the labels of a data dir to its postdata_N.bin files. File N holds the labels starting
+// at N*MaxFileSize/16, every file but the last is MaxFileSize bytes long. Files may be spread over
+// several directories by a Manifest.
+type Layout struct {
+	Dir         string
+	NumLabels   uint64
+	MaxFileSize uint64
+	// Files are the post data files found, ordered by index.
+	Files []LayoutFile
+	// TempFiles are the paths of the .dtmp files found.
+	TempFiles []string
+	// ShadowedFiles are the paths of files in the data dir that the manifest places elsewhere.
+	ShadowedFiles []string
+}
+
+// NewLayout lists the files of dir for the labels described by metadata.
+func NewLayout(dir string, metadata *PostMetadata) (*Layout, error) {
+	if metadata.MaxFileSize == 0 || metadata.MaxFileSize%LabelLength != 0 {
+		return nil, fmt.Errorf("invalid max file size; expected: multiple of %d, given: %d", LabelLength, metadata.MaxFileSize)
+	}
+	files, temps, shadowed, err := listInitFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Layout{
+		Dir:           dir,
+		NumLabels:     uint64(metadata.NumUnits) * metadata.LabelsPerUnit,
+		MaxFileSize:   metadata.MaxFileSize,
+		Files:         files,
+		TempFiles:     temps,
+		ShadowedFiles: shadowed,
+	}, nil
+}
+
+// ReadLayout reads the metadata of dir and returns its layout.
+func ReadLayout(dir string) (*Layout, error) {
+	metadata, err := ReadMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewLayout(dir, metadata)
+}
+
+// FileCount returns the number of files the labels are spread over.
+func (l *Layout) FileCount() int {
+	return int((l.NumLabels*LabelLength + l.MaxFileSize - 1) / l.MaxFileSize)
+}
+
+// FileSize returns the size file index must have.
+func (l *Layout) FileSize(index int) int64 {
+	size := l.NumLabels * LabelLength
+	start := uint64(index) * l.MaxFileSize
+	if start >= size {
+		return 0
+	}
+	if size-start > l.MaxFileSize {
+		return int64(l.MaxFileSize)
+	}
+	return int64(size - start)
+}
+
+// Problems returns the gaps, size mismatches, extra and temp files of the dir.
+func (l *Layout) Problems() []LayoutProblem {
+	var problems []LayoutProblem
+	found := make(map[int]LayoutFile, len(l.Files))
+	for _, file := range l.Files {
+		found[file.Index] = file
+	}
+	count := l.FileCount()
+	for index := 0; index < count; index++ {
+		file, ok := found[index]
+		if !ok {
+			problems = append(problems, LayoutProblem{Kind: LayoutMissingFile, File: InitFileName(index)})
+			continue
+		}
+		if expected := l.FileSize(index); file.Size != expected {
+			problems = append(problems, LayoutProblem{Kind: LayoutSizeMismatch, File: file.Name, Expected: expected, Found: file.Size})
+		}
+	}
+	for _, file := range l.Files {
+		if file.Index >= count {
+			problems = append(problems, LayoutProblem{Kind: LayoutExtraFile, File: file.Name})
+		}
+	}
+	for _, name := range l.TempFiles {
+		problems = append(problems, LayoutProblem{Kind: LayoutTempFile, File: name})
+	}
+	for _, name := range l.ShadowedFiles {
+		problems = append(problems, LayoutProblem{Kind: LayoutShadowedFile, File: name})
+	}
+	return problems
+}
+
+// Check returns a *LayoutError when the dir has problems.
+func (l *Layout) Check() error {
+	if problems := l.Problems(); len(problems) > 0 {
+		return &LayoutError{Dir: l.Dir, Problems: problems}
+	}
+	return nil
+}
+
+// Locate returns the file holding label index and the offset of the label in it.
+func (l *Layout) Locate(index uint64) (int, uint64, error) {
+	if index >= l.NumLabels {
+		return 0, 0, fmt.Errorf("label %d out of range; expected: < %d", index, l.NumLabels)
+	}
+	pos := index * LabelLength
+	return int(pos / l.MaxFileSize), pos % l.MaxFileSize, nil
+}
+
+// LabelIndex returns the index of the label at offset of file, the inverse of Locate.
+func (l *Layout) LabelIndex(file int, offset uint64) (uint64, error) {
+	if file < 0 || offset >= l.MaxFileSize || offset%LabelLength != 0 {
+		return 0, fmt.Errorf("invalid position; expected: label aligned offset < %d, given: file %d offset %d", l.MaxFileSize, file, offset)
+	}
+	index := (uint64(file)*l.MaxFileSize + offset) / LabelLength
+	if index >= l.NumLabels {
+		return 0, fmt.Errorf("label %d out of range; expected: < %d", index, l.NumLabels)
+	}
+	return index, nil
+}
+
+// ReadLabel reads label index from its file.
+func (l *Layout) ReadLabel(index uint64) ([]byte, error) {
+	return l.ReadLabels(index, 1)
+}
+
+// ReadLabels reads count labels starting at label start, crossing file boundaries as needed. It reads
+// the local files of the layout; store.ReadLabels reads from any store, including remote ones.
+func (l *Layout) ReadLabels(start, count uint64) ([]byte, error) {
+	if start+count > l.NumLabels || start+count < start {
+		return nil, fmt.Errorf("labels %d..%d out of range; expected: < %d", start, start+count, l.NumLabels)
+	}
+	paths := make(map[int]string, len(l.Files))
+	for _, file := range l.Files {
+		paths[file.Index] = file.Path
+	}
+	labels := make([]byte, count*LabelLength)
+	for done := uint64(0); done < count; {
+		file, offset, err := l.Locate(start + done)
+		if err != nil {
+			return nil, err
+		}
+		n := (l.MaxFileSize - offset) / LabelLength
+		if n > count-done {
+			n = count - done
+		}
+		path, ok := paths[file]
+		if !ok {
+			return nil, fmt.Errorf("label %d: missing %s", start+done, InitFileName(file))
+		}
+		if err := readFileAt(path, labels[done*LabelLength:(done+n)*LabelLength], int64(offset)); err != nil {
+			return nil, err
+		}
+		done += n
+	}
+	return labels, nil
+}
+
+// readFileAt fills p from filename at offset.
+func readFileAt(filename string, p []byte, offset int64) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.ReadAt(p, offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("reading %s at %d: %w", filename, offset, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeLayout(t *testing.T, dir string, labels []byte, fileSize int, skip int) {
+	t.Helper()
+	for i := 0; i*fileSize < len(labels); i++ {
+		if i == skip {
+			continue
+		}
+		end := (i + 1) * fileSize
+		if end > len(labels) {
+			end = len(labels)
+		}
+		if err := os.WriteFile(filepath.Join(dir, InitFileName(i)), labels[i*fileSize:end], 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	dir := t.TempDir()
+	metadata := &PostMetadata{LabelsPerUnit: 100, NumUnits: 11, MaxFileSize: 160}
+	labels := make([]byte, 1100*LabelLength)
+	for i := range labels {
+		labels[i] = byte(i / LabelLength)
+	}
+	writeLayout(t, dir, labels, 160, -1)
+
+	l, err := NewLayout(dir, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Files) != 110 || l.Files[2].Index != 2 || l.Files[10].Index != 10 {
+		t.Fatalf("files not in numeric order: %v", l.Files[:11])
+	}
+	if err := l.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, offset, err := l.Locate(1057)
+	if err != nil || file != 105 || offset != 7*LabelLength {
+		t.Fatalf("locate: file %d offset %d, %v", file, offset, err)
+	}
+	if index, err := l.LabelIndex(file, offset); err != nil || index != 1057 {
+		t.Fatalf("label index %d, %v", index, err)
+	}
+	if _, _, err := l.Locate(1100); err == nil {
+		t.Fatal("located a label past the end")
+	}
+	got, err := l.ReadLabels(95, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, labels[95*LabelLength:115*LabelLength]) {
+		t.Fatal("labels across files differ")
+	}
+	if label, err := l.ReadLabel(1099); err != nil || label[0] != byte(1099%256) {
+		t.Fatalf("last label %v, %v", label, err)
+	}
+}
+
+func TestLayoutProblems(t *testing.T) {
+	dir := t.TempDir()
+	metadata := &PostMetadata{LabelsPerUnit: 100, NumUnits: 1, MaxFileSize: 480}
+	labels := make([]byte, 100*LabelLength)
+	writeLayout(t, dir, labels, 480, 1)
+	// 末尾文件过短、多余的文件以及残留的临时文件
+	if err := os.Truncate(filepath.Join(dir, InitFileName(3)), 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{InitFileName(4), InitFileTmpName(2)} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := NewLayout(dir, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layoutErr *LayoutError
+	if err := l.Check(); !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a layout error, got %v", err)
+	}
+	kinds := make(map[LayoutProblemKind]string)
+	for _, p := range layoutErr.Problems {
+		kinds[p.Kind] = p.File
+	}
+	expected := map[LayoutProblemKind]string{
+		LayoutMissingFile:  InitFileName(1),
+		LayoutSizeMismatch: InitFileName(3),
+		LayoutExtraFile:    InitFileName(4),
+		LayoutTempFile:     filepath.Join(dir, InitFileTmpName(2)),
+	}
+	for kind, file := range expected {
+		if kinds[kind] != file {
+			t.Errorf("%s: expected %s, got %q", kind, file, kinds[kind])
+		}
+	}
+	if len(layoutErr.Problems) != len(expected) {
+		t.Fatalf("problems: %v", layoutErr)
+	}
+}
@@ -0,0 +1,187 @@
+package shared
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestName is the file in the data dir that records which post data files live elsewhere.
+const ManifestName = "postdata_manifest.json"
+
+// Manifest spreads the postdata_N.bin files of one PoST over several directories, e.g. mount points
+// of different disks. The data dir keeps the metadata and the manifest; files without an entry are
+// in the data dir as before. Only the Go prover reads manifests, the Rust prover of prove/post expects
+// all files in the data dir.
+type Manifest struct {
+	// Files maps N of postdata_N.bin to the directory holding the file. Relative directories are
+	// relative to the data dir.
+	Files map[int]string `json:"files"`
+}
+
+// ReadManifest reads the manifest of dataDir. It returns an empty manifest when there is none.
+func ReadManifest(dataDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{Files: make(map[int]string)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", ManifestName, err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[int]string)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to dataDir, replacing the previous one atomically. An empty manifest is
+// removed instead.
+func (m *Manifest) Save(dataDir string) error {
+	filename := filepath.Join(dataDir, ManifestName)
+	if len(m.Files) == 0 {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data, OwnerReadWrite)
+}
+
+// ErrManifestUnsupported is returned by provers that only read the data dir, like the Rust prover,
+// for a data dir whose manifest moved files out of it.
+var ErrManifestUnsupported = errors.New("post data files outside of the data dir are not supported by this prover")
+
+// CheckNoManifest returns ErrManifestUnsupported when the manifest of dataDir maps files to other dirs.
+func CheckNoManifest(dataDir string) error {
+	m, err := ReadManifest(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(m.Files) > 0 {
+		return fmt.Errorf("%w: %s maps %d files elsewhere", ErrManifestUnsupported, ManifestName, len(m.Files))
+	}
+	return nil
+}
+
+// Dir returns the directory of file index.
+func (m *Manifest) Dir(dataDir string, index int) string {
+	dir, ok := m.Files[index]
+	if !ok {
+		return dataDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(dataDir, dir)
+	}
+	return dir
+}
+
+// InitFilePath returns where postdata_N.bin of dataDir lives, or has to be written by init.
+func InitFilePath(dataDir string, index int) (string, error) {
+	m, err := ReadManifest(dataDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(m.Dir(dataDir, index), InitFileName(index)), nil
+}
+
+// InitFileTmpPath returns where init writes postdata_N.dtmp before it is complete, next to the final file.
+func InitFileTmpPath(dataDir string, index int) (string, error) {
+	m, err := ReadManifest(dataDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(m.Dir(dataDir, index), InitFileTmpName(index)), nil
+}
+
+// ListInitFiles returns the post data files of dataDir and of the directories of its manifest, ordered
+// by index.
+func ListInitFiles(dataDir string) ([]LayoutFile, error) {
+	files, _, _, err := listInitFiles(dataDir)
+	return files, err
+}
+
+// listInitFiles also returns the temp files and the files of the data dir shadowed by the manifest.
+// Manifest directories are only looked at for the files mapped to them, they may hold other PoSTs.
+func listInitFiles(dataDir string) (files []LayoutFile, temps, shadowed []string, err error) {
+	m, err := ReadManifest(dataDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	entries, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if index, ok := ParseInitFileIndex(entry.Name()); ok {
+			if _, moved := m.Files[index]; moved {
+				shadowed = append(shadowed, filepath.Join(dataDir, entry.Name()))
+				continue
+			}
+			files = append(files, LayoutFile{Index: index, Name: entry.Name(), Path: filepath.Join(dataDir, entry.Name()), Size: entry.Size()})
+		} else if initFileTmpRe.MatchString(entry.Name()) {
+			temps = append(temps, filepath.Join(dataDir, entry.Name()))
+		}
+	}
+	for index := range m.Files {
+		dir := m.Dir(dataDir, index)
+		if tmp := filepath.Join(dir, InitFileTmpName(index)); fileExists(tmp) {
+			temps = append(temps, tmp)
+		}
+		filename := filepath.Join(dir, InitFileName(index))
+		info, err := os.Stat(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, LayoutFile{Index: index, Name: InitFileName(index), Path: filename, Size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Index < files[j].Index
+	})
+	sort.Strings(temps)
+	sort.Strings(shadowed)
+	return files, temps, shadowed, nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// writeFileAtomic writes data to filename through a temp file, so readers see either the old or the new content.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,193 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeManifestPost(t *testing.T) (string, []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	metadata := &PostMetadata{NodeId: make([]byte, 32), CommitmentAtxId: make([]byte, 32), LabelsPerUnit: 100, NumUnits: 4, MaxFileSize: 320}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, MetadataName), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	labels := make([]byte, 400*LabelLength)
+	for i := range labels {
+		labels[i] = byte(i / LabelLength)
+	}
+	writeLayout(t, dir, labels, 320, -1)
+	return dir, labels
+}
+
+func TestRebalance(t *testing.T) {
+	dir, labels := writeManifestPost(t)
+	disks := []string{filepath.Join(t.TempDir(), "a"), filepath.Join(t.TempDir(), "b")}
+
+	moves, err := Rebalance(dir, disks, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 20 {
+		t.Fatalf("expected all 20 files to move, got %d", len(moves))
+	}
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layout.Check(); err != nil {
+		t.Fatal(err)
+	}
+	perDisk := make(map[string]int)
+	for _, file := range layout.Files {
+		perDisk[filepath.Dir(file.Path)]++
+	}
+	if perDisk[disks[0]] != 10 || perDisk[disks[1]] != 10 {
+		t.Fatalf("files per disk: %v", perDisk)
+	}
+	got, err := layout.ReadLabels(0, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, labels) {
+		t.Fatal("labels changed by the move")
+	}
+	if size := PlotFilesize(dir); size != int64(len(labels)) || !CheckPlotComplete(dir) {
+		t.Fatalf("plot size %d", size)
+	}
+
+	// 平衡后再次规划不应产生移动
+	if moves, err := PlanRebalance(layout, disks); err != nil || len(moves) != 0 {
+		t.Fatalf("balanced layout planned %v, %v", moves, err)
+	}
+
+	// 全部移回数据目录后manifest被删除
+	for _, file := range layout.Files {
+		if err := MoveInitFile(dir, file.Index, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ManifestName)); !os.IsNotExist(err) {
+		t.Fatalf("manifest left behind: %v", err)
+	}
+	if layout, err = ReadLayout(dir); err != nil || layout.Check() != nil {
+		t.Fatalf("layout after moving back: %v", err)
+	}
+}
+
+func TestManifestShadowed(t *testing.T) {
+	dir, _ := writeManifestPost(t)
+	disk := t.TempDir()
+	if err := MoveInitFile(dir, 3, disk); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟移动中断：manifest已更新但源文件未删除
+	if err := os.WriteFile(filepath.Join(dir, InitFileName(3)), make([]byte, 320), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := InitFilePath(dir, 3); err != nil || path != filepath.Join(disk, InitFileName(3)) {
+		t.Fatalf("path of moved file: %s, %v", path, err)
+	}
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := layout.Problems()
+	if len(problems) != 1 || problems[0].Kind != LayoutShadowedFile {
+		t.Fatalf("problems: %v", problems)
+	}
+	label, err := layout.ReadLabel(3 * 20)
+	if err != nil || label[0] != 60 {
+		t.Fatalf("label of moved file: %v, %v", label, err)
+	}
+}
+
+func TestMoveKeepsOtherPost(t *testing.T) {
+	dir, _ := writeManifestPost(t)
+	disk := t.TempDir()
+	// 目标目录中已有另一个PoST的同名文件
+	other := bytes.Repeat([]byte{0xaa}, 320)
+	if err := os.WriteFile(filepath.Join(disk, InitFileName(3)), other, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveInitFile(dir, 3, disk); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("existing file replaced: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(disk, InitFileName(3))); err != nil || !bytes.Equal(data, other) {
+		t.Fatalf("file of the other PoST changed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, InitFileName(3))); err != nil {
+		t.Fatalf("source removed: %v", err)
+	}
+
+	// 上次中断留下的硬链接可以继续移动
+	if err := os.Link(filepath.Join(dir, InitFileName(4)), filepath.Join(disk, InitFileName(4))); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	if err := MoveInitFile(dir, 4, disk); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := InitFilePath(dir, 4); err != nil || path != filepath.Join(disk, InitFileName(4)) {
+		t.Fatalf("path of moved file: %s, %v", path, err)
+	}
+	if err := CheckNoManifest(dir); !errors.Is(err, ErrManifestUnsupported) {
+		t.Fatalf("manifest not reported: %v", err)
+	}
+}
+
+func TestMoveThroughSymlink(t *testing.T) {
+	dir, _ := writeManifestPost(t)
+	link := filepath.Join(t.TempDir(), "data")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if err := MoveInitFile(dir, 2, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, InitFileName(2))); err != nil {
+		t.Fatalf("file moved onto itself was removed: %v", err)
+	}
+	if err := CheckNoManifest(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveRelativeDir(t *testing.T) {
+	dir, labels := writeManifestPost(t)
+	work := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// 相对于当前目录，而不是数据目录
+	if err := MoveInitFile(dir, 3, "disk"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := InitFilePath(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(work, "disk", InitFileName(3)); !sameDir(filepath.Dir(path), filepath.Dir(want)) {
+		t.Fatalf("manifest points at %s, file moved to %s", path, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, labels[3*320:4*320]) {
+		t.Fatal("moved file differs")
+	}
+}
@@ -0,0 +1,201 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zeebo/blake3"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// MetadataBackupName is the copy of the previous metadata WriteMetadata keeps, ReadMetadata falls back
+// to it when the metadata is damaged.
+const MetadataBackupName = MetadataName + ".bak"
+
+// ErrMetadataChecksum is returned for metadata whose content does not match its checksum.
+var ErrMetadataChecksum = errors.New("metadata checksum mismatch")
+
+// metadataMigrations[v] upgrades metadata of version v to version v+1.
+var metadataMigrations = []func(m *PostMetadata) error{
+	// 0: postcli格式，没有记录scrypt参数，postcli初始化的数据使用默认参数
+	func(m *PostMetadata) error {
+		if m.Scrypt == nil {
+			params := DefaultLabelParams()
+			m.Scrypt = &params
+		}
+		return nil
+	},
+}
+
+// migrateMetadata upgrades m to MetadataVersion.
+func migrateMetadata(m *PostMetadata) error {
+	for m.Version < MetadataVersion {
+		if m.Version < 0 {
+			return fmt.Errorf("unsupported metadata version; expected: <= %d, given: %d", MetadataVersion, m.Version)
+		}
+		if err := metadataMigrations[m.Version](m); err != nil {
+			return fmt.Errorf("migrating metadata from version %d: %w", m.Version, err)
+		}
+		m.Version++
+	}
+	return nil
+}
+
+// checksum returns the checksum of m, computed over its encoding with an empty Checksum.
+func (p *PostMetadata) checksum() (string, error) {
+	c := *p
+	c.Checksum = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	sum := blake3.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseMetadata decodes metadata written by WriteMetadata or by postcli, verifies its checksum and
+// migrates it to MetadataVersion. Unknown fields are rejected.
+func ParseMetadata(data []byte) (*PostMetadata, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	m := &PostMetadata{}
+	if err := decoder.Decode(m); err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	if m.Version > MetadataVersion {
+		return nil, fmt.Errorf("unsupported metadata version; expected: <= %d, given: %d", MetadataVersion, m.Version)
+	}
+	// postcli不写checksum，有版本号的文件必须有
+	if m.Version > 0 || m.Checksum != "" {
+		sum, err := m.checksum()
+		if err != nil {
+			return nil, err
+		}
+		if m.Checksum != sum {
+			return nil, ErrMetadataChecksum
+		}
+	}
+	if err := migrateMetadata(m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadMetadata 读取metadata，损坏时使用备份
+func ReadMetadata(dir string) (*PostMetadata, error) {
+	m, err := readMetadataFile(filepath.Join(dir, MetadataName))
+	if err == nil {
+		return m, nil
+	}
+	backup, backupErr := readMetadataFile(filepath.Join(dir, MetadataBackupName))
+	if backupErr != nil {
+		return nil, err
+	}
+	log.Printf("%s in %s is unusable (%v), using %s", MetadataName, dir, err, MetadataBackupName)
+	return backup, nil
+}
+
+func readMetadataFile(filename string) (*PostMetadata, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ParseMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return m, nil
+}
+
+// WriteMetadata migrates m to MetadataVersion, validates it and writes it with its checksum to dir.
+// The file is replaced atomically and synced to disk, the previous metadata is kept as
+// MetadataBackupName if it is valid.
+func WriteMetadata(dir string, m *PostMetadata) error {
+	c := *m
+	if err := migrateMetadata(&c); err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	sum, err := c.checksum()
+	if err != nil {
+		return err
+	}
+	c.Checksum = sum
+	data, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, MetadataName)
+	// 只备份可用的metadata，避免用损坏的文件覆盖好的备份
+	if previous, err := os.ReadFile(filename); err == nil {
+		if _, err := ParseMetadata(previous); err == nil {
+			if err := writeFileAtomic(filepath.Join(dir, MetadataBackupName), previous, OwnerReadWrite); err != nil {
+				return fmt.Errorf("backing up metadata: %w", err)
+			}
+		}
+	}
+	if err := writeFileAtomic(filename, data, OwnerReadWrite); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	*m = c
+	return nil
+}
+
+// ImportMetadata reads the metadata written by go-spacemesh/postcli from src, a metadata file or the
+// data dir holding it, and writes it in the current format to dataDir. scrypt are the parameters the
+// data was initialized with, nil for the defaults of postcli.
+func ImportMetadata(src, dataDir string, scrypt *ScryptParams) (*PostMetadata, error) {
+	if info, err := os.Stat(src); err == nil && info.IsDir() {
+		src = filepath.Join(src, MetadataName)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	m := &PostMetadata{}
+	if err := decoder.Decode(m); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", src, err)
+	}
+	if m.Version != 0 {
+		// 已经是新格式
+		if m, err = ParseMetadata(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+	} else if scrypt != nil {
+		params := *scrypt
+		m.Scrypt = &params
+	}
+	if err := WriteMetadata(dataDir, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// syncDir makes a rename in dir durable. Windows cannot sync directories.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,218 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"github.com/trying2016/post-go/codec"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// postcliMetadata is a metadata file as written by go-spacemesh/postcli.
+const postcliMetadata = `{
+  "NodeId": "AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA=",
+  "CommitmentAtxId": "ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=",
+  "LabelsPerUnit": 4294967296,
+  "NumUnits": 4,
+  "MaxFileSize": 4294967296,
+  "Nonce": 12345,
+  "NonceValue": "000102030405060708090a0b0c0d0e0f",
+  "LastPosition": 17179869184
+}`
+
+func TestImportPostcliMetadata(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, MetadataName), []byte(postcliMetadata), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// postcli格式不经导入也能读取
+	legacy, err := ReadMetadata(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Version != MetadataVersion || *legacy.Scrypt != DefaultLabelParams() {
+		t.Fatalf("postcli metadata not migrated: %+v", legacy)
+	}
+
+	dir := t.TempDir()
+	scrypt := ScryptParams{N: 16, R: 1, P: 1}
+	if _, err := ImportMetadata(src, dir, &scrypt); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NodeId[0] != 1 || m.CommitmentAtxId[0] != 0x20 || m.NumUnits != 4 || *m.Nonce != 12345 || *m.LastPosition != 1<<34 {
+		t.Fatalf("imported metadata differs: %+v", m)
+	}
+	if !bytes.Equal(m.NonceValue, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}) {
+		t.Fatalf("NonceValue %x", m.NonceValue)
+	}
+	if *m.Scrypt != scrypt || m.Checksum == "" {
+		t.Fatalf("scrypt %+v checksum %q", m.Scrypt, m.Checksum)
+	}
+
+	// 写入的文件可以原样读回
+	data, err := os.ReadFile(filepath.Join(dir, MetadataName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"000102030405060708090a0b0c0d0e0f"`) {
+		t.Fatalf("NonceValue not written as hex:\n%s", data)
+	}
+	again, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Fatalf("metadata does not round-trip:\n%s\n%s", data, again)
+	}
+}
+
+func TestWriteMetadataBackup(t *testing.T) {
+	dir := t.TempDir()
+	m := &PostMetadata{NodeId: make([]byte, 32), CommitmentAtxId: make([]byte, 32), LabelsPerUnit: 100, NumUnits: 1, MaxFileSize: 320}
+	if err := WriteMetadata(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	position := uint64(50)
+	m.LastPosition = &position
+	if err := WriteMetadata(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := readMetadataFile(filepath.Join(dir, MetadataBackupName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.LastPosition != nil {
+		t.Fatal("backup is not the previous metadata")
+	}
+
+	// 被改动的文件校验失败，读取时退回备份
+	filename := filepath.Join(dir, MetadataName)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte(`"NumUnits": 1`), []byte(`"NumUnits": 2`), 1)
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMetadata(data); !errors.Is(err, ErrMetadataChecksum) {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+	m, err = ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NumUnits != 1 || m.LastPosition != nil {
+		t.Fatalf("expected the backup, got %+v", m)
+	}
+}
+
+func TestMetadataValidation(t *testing.T) {
+	for _, data := range []string{
+		`{"NodeId": "AQI=", "CommitmentAtxId": "ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=", "LabelsPerUnit": 1, "NumUnits": 1, "MaxFileSize": 16}`,
+		`{"Version": 99}`,
+		`{"Unknown": 1}`,
+		strings.Replace(postcliMetadata, `"MaxFileSize": 4294967296`, `"MaxFileSize": 100`, 1),
+		strings.Replace(postcliMetadata, `"NonceValue": "000102030405060708090a0b0c0d0e0f"`, `"NonceValue": "0001"`, 1),
+		strings.Replace(postcliMetadata, `"LastPosition": 17179869184`, `"LastPosition": 17179869185`, 1),
+	} {
+		if _, err := ParseMetadata([]byte(data)); err == nil {
+			t.Fatalf("invalid metadata accepted: %s", data)
+		}
+	}
+}
+
+func TestMetadataScale(t *testing.T) {
+	nonce, position := uint64(12345), uint64(1<<34)
+	m := &PostMetadata{
+		Version:         MetadataVersion,
+		NodeId:          bytes.Repeat([]byte{1}, 32),
+		CommitmentAtxId: bytes.Repeat([]byte{2}, 32),
+		LabelsPerUnit:   1 << 32,
+		NumUnits:        4,
+		MaxFileSize:     1 << 32,
+		Nonce:           &nonce,
+		NonceValue:      bytes.Repeat([]byte{3}, LabelLength),
+		LastPosition:    &position,
+		Scrypt:          &ScryptParams{N: 8192, R: 1, P: 1},
+		Checksum:        strings.Repeat("a", 64),
+	}
+	data, err := EncodePostMetadata(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodePostMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Fatalf("decoded %+v, expected %+v", decoded, m)
+	}
+
+	legacy := &PostMetadata{NodeId: m.NodeId, CommitmentAtxId: m.CommitmentAtxId, LabelsPerUnit: 1, NumUnits: 1, MaxFileSize: 16}
+	data, err = EncodePostMetadata(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err = DecodePostMetadata(data); err != nil || !reflect.DeepEqual(decoded, legacy) {
+		t.Fatalf("decoded %+v: %v", decoded, err)
+	}
+
+	legacy.NodeId = legacy.NodeId[:31]
+	if _, err := EncodePostMetadata(legacy); err == nil {
+		t.Fatal("short NodeId encoded")
+	}
+
+	vrf := &VRFNonceMetadata{NodeId: m.NodeId, CommitmentAtxId: m.CommitmentAtxId, NumUnits: 4, LabelsPerUnit: 1 << 32}
+	data, err = codec.Encode(vrf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedVRF VRFNonceMetadata
+	if err := codec.Decode(data, &decodedVRF); err != nil || !reflect.DeepEqual(&decodedVRF, vrf) {
+		t.Fatalf("decoded %+v: %v", decodedVRF, err)
+	}
+}
+
+func TestProofStream(t *testing.T) {
+	metadata := &PostMetadata{NodeId: bytes.Repeat([]byte{1}, 32), CommitmentAtxId: bytes.Repeat([]byte{2}, 32), LabelsPerUnit: 4096, NumUnits: 4}
+	var buf bytes.Buffer
+	fw := codec.NewFrameWriter(&buf, 0)
+	for i := 0; i < 3; i++ {
+		challenge := bytes.Repeat([]byte{byte(i)}, 32)
+		if _, err := fw.EncodeTo(&Proof{Nonce: uint32(i), Indices: []byte{byte(i)}, Pow: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.EncodeTo(NewProofMetadata(metadata, challenge)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fr := codec.NewFrameReader(&buf, 0)
+	for i := 0; ; i++ {
+		var proof Proof
+		if _, err := fr.DecodeFrom(&proof); err == io.EOF {
+			if i != 3 {
+				t.Fatalf("read %d proofs, expected 3", i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		var proofMetadata ProofMetadata
+		if _, err := fr.DecodeFrom(&proofMetadata); err != nil {
+			t.Fatal(err)
+		}
+		if proof.Nonce != uint32(i) || proofMetadata.Challenge[0] != byte(i) || proofMetadata.NumUnits != 4 {
+			t.Fatalf("proof %d: %+v, %+v", i, proof, proofMetadata)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package shared
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Move is the move of one post data file between directories.
+type Move struct {
+	Index int
+	From  string
+	To    string
+	Size  int64
+}
+
+func (m Move) String() string {
+	return fmt.Sprintf("%s: %s -> %s (%d bytes)", InitFileName(m.Index), m.From, m.To, m.Size)
+}
+
+// MoveInitFile moves postdata_N.bin of dataDir to dir and records the new place in the manifest; dir
+// equal to dataDir moves the file back into the data dir. The file is linked or copied and synced
+// first, the manifest updated next and the source removed last, so an interruption leaves either the
+// old file in use or a shadowed file that a repeated move cleans up.
+//
+// dir may hold the files of other PoSTs, so an existing postdata_N.bin in dir is never replaced. The
+// only exception is a hard link to the source left by an interrupted move; a copy left by an
+// interrupted move between file systems has to be removed by hand.
+//
+// A relative dir is relative to the working directory; the manifest records it as absolute path.
+func MoveInitFile(dataDir string, index int, dir string) error {
+	// manifest中的相对路径是相对于dataDir的，必须在写文件和记录之前转为绝对路径
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	m, err := ReadManifest(dataDir)
+	if err != nil {
+		return err
+	}
+	from := m.Dir(dataDir, index)
+	src := filepath.Join(from, InitFileName(index))
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if sameDir(from, dir) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, OwnerReadWriteExec); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, InitFileName(index))
+	dstInfo, err := os.Stat(dst)
+	switch {
+	case err == nil && !os.SameFile(srcInfo, dstInfo):
+		return fmt.Errorf("moving %s to %s: %w", InitFileName(index), dir, os.ErrExist)
+	case err == nil:
+		// 上次移动中断时留下的硬链接
+	case os.IsNotExist(err):
+		if err := linkOrCopy(src, dst, filepath.Join(dir, InitFileTmpName(index))); err != nil {
+			return fmt.Errorf("moving %s to %s: %w", InitFileName(index), dir, err)
+		}
+	default:
+		return err
+	}
+
+	if sameDir(dir, dataDir) {
+		delete(m.Files, index)
+	} else {
+		m.Files[index] = dir
+	}
+	if err := m.Save(dataDir); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// linkOrCopy makes dst a copy of src, through a hard link on the same file system and a synced copy
+// written to tmp otherwise. An existing dst is not replaced.
+func linkOrCopy(src, dst, tmp string) error {
+	if err := os.Link(src, dst); err == nil || os.IsExist(err) {
+		return err
+	}
+	_ = os.Remove(tmp)
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// os.Rename会覆盖已有的dst，用硬链接代替
+	err := os.Link(tmp, dst)
+	if removeErr := os.Remove(tmp); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, OwnerReadWrite)
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyBuffer(out, in, make([]byte, 4<<20))
+	if err == nil && n != info.Size() {
+		err = fmt.Errorf("copied %d of %d bytes", n, info.Size())
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sameDir reports whether a and b name the same directory, following symbolic links.
+func sameDir(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(infoA, infoB)
+	}
+	// 目录不存在时比较路径
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	if resolved, err := filepath.EvalSymlinks(absA); err == nil {
+		absA = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(absB); err == nil {
+		absB = resolved
+	}
+	return absA == absB
+}
+
+// PlanRebalance returns the moves that spread the files of layout evenly, by bytes, over dirs. Files
+// outside of dirs are moved into them, files inside only when that evens out the dirs.
+func PlanRebalance(layout *Layout, dirs []string) ([]Move, error) {
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("invalid dirs; expected: at least one, given: none")
+	}
+	load := make([]int64, len(dirs))
+	place := make(map[int]int, len(layout.Files))
+	var outside []LayoutFile
+	for _, file := range layout.Files {
+		d := -1
+		for i, dir := range dirs {
+			if sameDir(filepath.Dir(file.Path), dir) {
+				d = i
+				break
+			}
+		}
+		if d < 0 {
+			outside = append(outside, file)
+			continue
+		}
+		place[file.Index] = d
+		load[d] += file.Size
+	}
+
+	var moves []Move
+	// 先放置不在目标目录中的文件，大文件优先
+	sort.SliceStable(outside, func(i, j int) bool {
+		return outside[i].Size > outside[j].Size
+	})
+	for _, file := range outside {
+		d := lightest(load)
+		moves = append(moves, Move{Index: file.Index, From: filepath.Dir(file.Path), To: dirs[d], Size: file.Size})
+		place[file.Index] = d
+		load[d] += file.Size
+	}
+
+	// 再从最满的目录向最空的目录移动，直到不能再改善
+	for {
+		heavy, light := heaviest(load), lightest(load)
+		var best *LayoutFile
+		for i := range layout.Files {
+			file := &layout.Files[i]
+			if place[file.Index] != heavy || load[light]+file.Size >= load[heavy] {
+				continue
+			}
+			if best == nil || file.Size > best.Size {
+				best = file
+			}
+		}
+		if best == nil {
+			break
+		}
+		moves = appendMove(moves, Move{Index: best.Index, From: dirs[heavy], To: dirs[light], Size: best.Size})
+		place[best.Index] = light
+		load[heavy] -= best.Size
+		load[light] += best.Size
+	}
+	return moves, nil
+}
+
+// appendMove adds m, merging it with an earlier move of the same file.
+func appendMove(moves []Move, m Move) []Move {
+	for i := range moves {
+		if moves[i].Index == m.Index {
+			moves[i].To = m.To
+			return moves
+		}
+	}
+	return append(moves, m)
+}
+
+func lightest(load []int64) int {
+	min := 0
+	for i := range load {
+		if load[i] < load[min] {
+			min = i
+		}
+	}
+	return min
+}
+
+func heaviest(load []int64) int {
+	max := 0
+	for i := range load {
+		if load[i] > load[max] {
+			max = i
+		}
+	}
+	return max
+}
+
+// Rebalance spreads the files of dataDir evenly over dirs and returns the moves made. progress, if not
+// nil, is called before every move.
+func Rebalance(dataDir string, dirs []string, progress func(Move)) ([]Move, error) {
+	dirs, err := absDirs(dirs)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := ReadLayout(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	moves, err := PlanRebalance(layout, dirs)
+	if err != nil {
+		return nil, err
+	}
+	for i, move := range moves {
+		if progress != nil {
+			progress(move)
+		}
+		if err := MoveInitFile(dataDir, move.Index, move.To); err != nil {
+			return moves[:i], err
+		}
+	}
+	return moves, nil
+}
+
+// absDirs returns dirs as absolute paths.
+func absDirs(dirs []string) ([]string, error) {
+	abs := make([]string, len(dirs))
+	for i, dir := range dirs {
+		var err error
+		if abs[i], err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+	return abs, nil
+}
@@ -1,37 +1,214 @@
 package shared
 
 import (
+	"encoding/base64"
 	"encoding/hex"
-	"github.com/trying2016/common-tools/utils"
+	"encoding/json"
+	"fmt"
+	"github.com/trying2016/post-go/codec"
 )
 
+// MetadataVersion is the schema version WriteMetadata writes. Files without a version are in the format
+// of go-spacemesh/postcli and are migrated when read.
+const MetadataVersion = 1
+
 // PostMetadata is the data associated with the PoST init procedure, persisted in the datadir next to the init files.
 type PostMetadata struct {
+	// Version is the schema version of the file, 0 for files written by postcli.
+	Version int `json:",omitempty"`
+
 	NodeId          []byte
 	CommitmentAtxId []byte
 
 	LabelsPerUnit uint64
 	NumUnits      uint32
 	MaxFileSize   uint64
-	Nonce         *uint64 `json:",omitempty"`
-	NonceValue    []byte
-	LastPosition  *uint64 `json:",omitempty"`
+	Nonce         *uint64    `json:",omitempty"`
+	NonceValue    NonceValue `json:",omitempty"`
+	LastPosition  *uint64    `json:",omitempty"`
+
+	// Scrypt are the parameters the labels were initialized with.
+	Scrypt *ScryptParams `json:",omitempty"`
+	// Checksum is the hex blake3 hash of the metadata with an empty Checksum.
+	Checksum string `json:",omitempty"`
+}
+
+// NonceValue is the label of the VRF nonce. It is stored as hex like postcli does, plain []byte would
+// be base64.
+type NonceValue []byte
+
+func (n NonceValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(n))
+}
+
+// UnmarshalJSON accepts hex and, for files written with plain []byte, base64.
+func (n *NonceValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, err := hex.DecodeString(s)
+	if err != nil {
+		if value, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return fmt.Errorf("invalid NonceValue %q: neither hex nor base64", s)
+		}
+	}
+	*n = value
+	return nil
 }
 
 func (p *PostMetadata) NodeIdStr() string {
 	return hex.EncodeToString(p.NodeId)
 }
 
+// Marshal returns the JSON encoding of the metadata, the same one WriteMetadata writes.
 func (p *PostMetadata) Marshal() ([]byte, error) {
-	data := utils.Map{
-		"NodeId":          p.NodeId,
-		"CommitmentAtxId": p.CommitmentAtxId,
-		"LabelsPerUnit":   p.LabelsPerUnit,
-		"NumUnits":        p.NumUnits,
-		"MaxFileSize":     p.MaxFileSize,
-		"Nonce":           p.Nonce,
-		"NonceValue":      hex.EncodeToString(p.NonceValue),
-		"LastPosition":    p.LastPosition,
-	}.ToJson()
-	return []byte(data), nil
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// NumLabels returns the number of labels of the PoST.
+func (p *PostMetadata) NumLabels() uint64 {
+	return uint64(p.NumUnits) * p.LabelsPerUnit
+}
+
+// Validate checks the metadata for values no PoST can have.
+func (p *PostMetadata) Validate() error {
+	if p.Version < 0 || p.Version > MetadataVersion {
+		return fmt.Errorf("unsupported metadata version; expected: <= %d, given: %d", MetadataVersion, p.Version)
+	}
+	if len(p.NodeId) != 32 {
+		return fmt.Errorf("invalid NodeId length; expected: 32, given: %d", len(p.NodeId))
+	}
+	if len(p.CommitmentAtxId) != 32 {
+		return fmt.Errorf("invalid CommitmentAtxId length; expected: 32, given: %d", len(p.CommitmentAtxId))
+	}
+	if p.LabelsPerUnit == 0 {
+		return fmt.Errorf("invalid LabelsPerUnit; expected: > 0, given: %d", p.LabelsPerUnit)
+	}
+	if p.NumUnits == 0 {
+		return fmt.Errorf("invalid NumUnits; expected: > 0, given: %d", p.NumUnits)
+	}
+	if p.MaxFileSize == 0 || p.MaxFileSize%LabelLength != 0 {
+		return fmt.Errorf("invalid MaxFileSize; expected: a positive multiple of %d, given: %d", LabelLength, p.MaxFileSize)
+	}
+	if len(p.NonceValue) != 0 && len(p.NonceValue) != LabelLength {
+		return fmt.Errorf("invalid NonceValue length; expected: %d, given: %d", LabelLength, len(p.NonceValue))
+	}
+	if p.Nonce != nil && *p.Nonce >= p.NumLabels() {
+		return fmt.Errorf("invalid Nonce; expected: < %d, given: %d", p.NumLabels(), *p.Nonce)
+	}
+	if p.LastPosition != nil && *p.LastPosition > p.NumLabels() {
+		return fmt.Errorf("invalid LastPosition; expected: <= %d, given: %d", p.NumLabels(), *p.LastPosition)
+	}
+	if p.Scrypt != nil {
+		if err := p.Scrypt.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumLength is the length of the hex blake3 Checksum.
+const checksumLength = 64
+
+// EncodeScale implements scale codec interface. Pointer fields are encoded as options.
+func (p *PostMetadata) EncodeScale(enc *codec.Encoder) (total int, err error) {
+	if p.Version < 0 {
+		return total, fmt.Errorf("invalid metadata version; expected: >= 0, given: %d", p.Version)
+	}
+	for _, encode := range []func() (int, error){
+		func() (int, error) { return codec.EncodeCompact32(enc, uint32(p.Version)) },
+		func() (int, error) { return encodeHash32(enc, "NodeId", p.NodeId) },
+		func() (int, error) { return encodeHash32(enc, "CommitmentAtxId", p.CommitmentAtxId) },
+		func() (int, error) { return codec.EncodeCompact64(enc, p.LabelsPerUnit) },
+		func() (int, error) { return codec.EncodeCompact32(enc, p.NumUnits) },
+		func() (int, error) { return codec.EncodeCompact64(enc, p.MaxFileSize) },
+		func() (int, error) { return encodeOptionalUint64(enc, p.Nonce) },
+		func() (int, error) { return codec.EncodeByteSliceWithLimit(enc, p.NonceValue, LabelLength) },
+		func() (int, error) { return encodeOptionalUint64(enc, p.LastPosition) },
+		func() (int, error) { return codec.EncodeOption(enc, p.Scrypt) },
+		func() (int, error) { return codec.EncodeStringWithLimit(enc, p.Checksum, checksumLength) },
+	} {
+		n, err := encode()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// DecodeScale implements scale codec interface.
+func (p *PostMetadata) DecodeScale(dec *codec.Decoder) (total int, err error) {
+	var version uint32
+	var scrypt ScryptParams
+	for _, decode := range []func() (n int, err error){
+		func() (n int, err error) { version, n, err = codec.DecodeCompact32(dec); return },
+		func() (n int, err error) { return decodeHash32(dec, &p.NodeId) },
+		func() (n int, err error) { return decodeHash32(dec, &p.CommitmentAtxId) },
+		func() (n int, err error) { p.LabelsPerUnit, n, err = codec.DecodeCompact64(dec); return },
+		func() (n int, err error) { p.NumUnits, n, err = codec.DecodeCompact32(dec); return },
+		func() (n int, err error) { p.MaxFileSize, n, err = codec.DecodeCompact64(dec); return },
+		func() (n int, err error) { p.Nonce, n, err = decodeOptionalUint64(dec); return },
+		func() (n int, err error) {
+			p.NonceValue, n, err = codec.DecodeByteSliceWithLimit(dec, LabelLength)
+			return
+		},
+		func() (n int, err error) { p.LastPosition, n, err = decodeOptionalUint64(dec); return },
+		func() (n int, err error) {
+			exists, n, err := codec.DecodeOption(dec, &scrypt)
+			if exists {
+				p.Scrypt = &scrypt
+			}
+			return n, err
+		},
+		func() (n int, err error) {
+			p.Checksum, n, err = codec.DecodeStringWithLimit(dec, checksumLength)
+			return
+		},
+	} {
+		n, err := decode()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	p.Version = int(version)
+	return total, nil
+}
+
+func encodeOptionalUint64(enc *codec.Encoder, value *uint64) (int, error) {
+	if value == nil {
+		return codec.EncodeOption(enc, nil)
+	}
+	return codec.EncodeOption(enc, codec.EncodeFunc(func(enc *codec.Encoder) (int, error) {
+		return codec.EncodeCompact64(enc, *value)
+	}))
+}
+
+func decodeOptionalUint64(dec *codec.Decoder) (*uint64, int, error) {
+	var value uint64
+	exists, n, err := codec.DecodeOption(dec, codec.DecodeFunc(func(dec *codec.Decoder) (n int, err error) {
+		value, n, err = codec.DecodeCompact64(dec)
+		return n, err
+	}))
+	if !exists || err != nil {
+		return nil, n, err
+	}
+	return &value, n, nil
+}
+
+// EncodePostMetadata returns the SCALE encoding of metadata, the binary counterpart of Marshal.
+func EncodePostMetadata(metadata *PostMetadata) ([]byte, error) {
+	return codec.Encode(metadata)
+}
+
+// DecodePostMetadata decodes metadata encoded by EncodePostMetadata.
+func DecodePostMetadata(data []byte) (*PostMetadata, error) {
+	var metadata PostMetadata
+	err := codec.Decode(data, &metadata)
+	return &metadata, err
 }
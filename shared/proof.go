@@ -1,10 +1,15 @@
 package shared
 
-import "github.com/trying2016/post-go/codec"
+import (
+	"fmt"
+	"github.com/trying2016/post-go/codec"
+)
+
+//go:generate go run github.com/trying2016/post-go/cmd/scalegen -types Proof,ProofMetadata,VRFNonceMetadata
 
 type Proof struct {
 	Nonce   uint32
-	Indices []byte
+	Indices []byte `scale:"max=8000"` // needs to hold K2*8 bytes at most
 	Pow     uint64
 }
 
@@ -18,66 +23,11 @@ func DecodeProof(data []byte) (*Proof, error) {
 	return &proof, err
 }
 
-// EncodeScale implements scale codec interface.
-func (p *Proof) EncodeScale(enc *codec.Encoder) (total int, err error) {
-	{
-		n, err := codec.EncodeCompact32(enc, uint32(p.Nonce))
-		if err != nil {
-			return total, err
-		}
-		total += n
-	}
-	{
-		n, err := codec.EncodeByteSliceWithLimit(enc, p.Indices, 8000) // needs to hold K2*8 bytes at most
-		if err != nil {
-			return total, err
-		}
-		total += n
-	}
-	{
-		n, err := codec.EncodeCompact64(enc, p.Pow)
-		if err != nil {
-			return total, err
-		}
-		total += n
-	}
-	return total, nil
-}
-
-// DecodeScale implements scale codec interface.
-func (p *Proof) DecodeScale(dec *codec.Decoder) (total int, err error) {
-	{
-		field, n, err := codec.DecodeCompact32(dec)
-		if err != nil {
-			return total, err
-		}
-		total += n
-		p.Nonce = field
-	}
-	{
-		field, n, err := codec.DecodeByteSliceWithLimit(dec, 8000) // needs to hold K2*8 bytes at most
-		if err != nil {
-			return total, err
-		}
-		total += n
-		p.Indices = field
-	}
-	{
-		field, n, err := codec.DecodeCompact64(dec)
-		if err != nil {
-			return total, err
-		}
-		total += n
-		p.Pow = field
-	}
-	return total, nil
-}
-
 type ProofMetadata struct {
-	NodeId          []byte
-	CommitmentAtxId []byte
+	NodeId          []byte `scale:"fixed=32"`
+	CommitmentAtxId []byte `scale:"fixed=32"`
 
-	Challenge     Challenge
+	Challenge     Challenge `scale:"fixed=32"`
 	NumUnits      uint32
 	LabelsPerUnit uint64
 }
@@ -85,9 +35,28 @@ type ProofMetadata struct {
 type VRFNonce uint64
 
 type VRFNonceMetadata struct {
-	NodeId          []byte
-	CommitmentAtxId []byte
+	NodeId          []byte `scale:"fixed=32"`
+	CommitmentAtxId []byte `scale:"fixed=32"`
 
 	NumUnits      uint32
 	LabelsPerUnit uint64
 }
+
+// NewProofMetadata returns the metadata of a proof of the PoST of metadata for challenge.
+func NewProofMetadata(metadata *PostMetadata, challenge Challenge) *ProofMetadata {
+	return &ProofMetadata{
+		NodeId:          metadata.NodeId,
+		CommitmentAtxId: metadata.CommitmentAtxId,
+		Challenge:       challenge,
+		NumUnits:        metadata.NumUnits,
+		LabelsPerUnit:   metadata.LabelsPerUnit,
+	}
+}
+
+// encodeHash32 encodes a 32 byte field as byte array.
+func encodeHash32(enc *codec.Encoder, name string, value []byte) (int, error) {
+	if len(value) != 32 {
+		return 0, fmt.Errorf("invalid %s length; expected: 32, given: %d", name, len(value))
+	}
+	return codec.EncodeByteArray(enc, value)
+}
@@ -0,0 +1,44 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// ProvingParams are the difficulties a proof is generated and verified with.
+type ProvingParams struct {
+	// Difficulty is the threshold an encrypted label has to be below to count for a nonce.
+	Difficulty uint64
+	// PoWDifficulty is the k2pow difficulty of a nonce group, scaled down by the number of units.
+	PoWDifficulty [32]byte
+}
+
+// NewProvingParams returns the params for numUnits units of labelsPerUnit labels.
+func NewProvingParams(numUnits uint32, labelsPerUnit uint64, powDifficulty []byte, k1 uint32) (*ProvingParams, error) {
+	if numUnits == 0 {
+		return nil, fmt.Errorf("number of units must be > 0")
+	}
+	numLabels := uint64(numUnits) * labelsPerUnit
+	difficulty, err := LabelDifficulty(k1, numLabels)
+	if err != nil {
+		return nil, err
+	}
+	params := &ProvingParams{Difficulty: difficulty}
+	scaled := new(big.Int).SetBytes(powDifficulty)
+	scaled.Div(scaled, new(big.Int).SetUint64(uint64(numUnits)))
+	scaled.FillBytes(params.PoWDifficulty[:])
+	return params, nil
+}
+
+// LabelDifficulty returns the difficulty for which on average k1 of numLabels labels qualify for a nonce.
+// Unlike ProvingDifficulty it is computed in floating point, the same way post-rs does.
+func LabelDifficulty(k1 uint32, numLabels uint64) (uint64, error) {
+	if numLabels == 0 {
+		return 0, fmt.Errorf("number of label blocks must be > 0")
+	}
+	if numLabels <= uint64(k1) {
+		return 0, fmt.Errorf("number of labels (%d) must be bigger than k1 (%d)", numLabels, k1)
+	}
+	return uint64(math.Pow(2, 64) * float64(k1) / float64(numLabels)), nil
+}
@@ -0,0 +1,195 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/trying2016/post-go/codec"
+)
+
+// signedProofDomain separates signatures of proofs from other signatures of the node key.
+var signedProofDomain = []byte("post-go/signed-proof/v1")
+
+var (
+	// ErrInvalidSignature is returned for a SignedProof whose signature is not by its NodeId.
+	ErrInvalidSignature = errors.New("invalid proof signature")
+	// ErrChallengeMismatch is returned for a SignedProof of another challenge.
+	ErrChallengeMismatch = errors.New("proof is for another challenge")
+)
+
+// SignedProof binds a proof to the identity and challenge it was generated for. It is signed by the
+// node key, the NodeId of the metadata.
+type SignedProof struct {
+	Proof    Proof
+	Metadata ProofMetadata
+	// PowCreator is the id the PoW of the proof was computed for, the creatorId of GenerateProof. It is
+	// at most 32 bytes and may be empty.
+	PowCreator []byte
+	// Signature is the ed25519 signature of SigningBytes.
+	Signature []byte
+}
+
+// NewSignedProof bundles proof with its metadata and signs it with signer, which has to hold the key
+// of metadata.NodeId.
+func NewSignedProof(proof *Proof, metadata *ProofMetadata, powCreator []byte, signer Signer) (*SignedProof, error) {
+	s := &SignedProof{Proof: *proof, Metadata: *metadata, PowCreator: powCreator}
+	if err := s.Sign(signer); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SigningBytes returns the signed message: a domain tag followed by the encoding of the proof without
+// the signature.
+func (s *SignedProof) SigningBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(signedProofDomain)
+	if _, err := s.encodeUnsigned(codec.NewEncoder(&buf)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign signs the proof with signer.
+func (s *SignedProof) Sign(signer Signer) error {
+	if nodeId := signer.NodeId(); !bytes.Equal(nodeId, s.Metadata.NodeId) {
+		return fmt.Errorf("signer of node %x cannot sign a proof of node %x", nodeId, s.Metadata.NodeId)
+	}
+	msg, err := s.SigningBytes()
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(msg)
+	if err != nil {
+		return err
+	}
+	s.Signature = signature
+	return nil
+}
+
+// Verify checks that the proof was signed by the key of its NodeId. It does not verify the proof itself.
+func (s *SignedProof) Verify() error {
+	if len(s.Metadata.NodeId) != ed25519.PublicKeySize || len(s.Signature) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	msg, err := s.SigningBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(s.Metadata.NodeId, msg, s.Signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// EncodeSignedProof returns the SCALE encoding of s.
+func EncodeSignedProof(s *SignedProof) ([]byte, error) {
+	return codec.Encode(s)
+}
+
+// DecodeSignedProof decodes a SignedProof without verifying it.
+func DecodeSignedProof(data []byte) (*SignedProof, error) {
+	var s SignedProof
+	err := codec.Decode(data, &s)
+	return &s, err
+}
+
+// VerifySignedProof decodes a SignedProof and checks its signature and, if challenge is not nil, that
+// it is a proof for challenge.
+func VerifySignedProof(data []byte, challenge []byte) (*SignedProof, error) {
+	s, err := DecodeSignedProof(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Verify(); err != nil {
+		return nil, err
+	}
+	if challenge != nil && !bytes.Equal(challenge, s.Metadata.Challenge) {
+		return nil, fmt.Errorf("%w; expected: %s, given: %s", ErrChallengeMismatch, hex.EncodeToString(challenge), hex.EncodeToString(s.Metadata.Challenge))
+	}
+	return s, nil
+}
+
+func (s *SignedProof) encodeUnsigned(enc *codec.Encoder) (total int, err error) {
+	{
+		n, err := s.Proof.EncodeScale(enc)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	{
+		n, err := s.Metadata.EncodeScale(enc)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	{
+		n, err := codec.EncodeByteSliceWithLimit(enc, s.PowCreator, 32)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// EncodeScale implements scale codec interface.
+func (s *SignedProof) EncodeScale(enc *codec.Encoder) (total int, err error) {
+	total, err = s.encodeUnsigned(enc)
+	if err != nil {
+		return total, err
+	}
+	if len(s.Signature) != ed25519.SignatureSize {
+		return total, fmt.Errorf("invalid Signature length; expected: %d, given: %d", ed25519.SignatureSize, len(s.Signature))
+	}
+	n, err := codec.EncodeByteArray(enc, s.Signature)
+	if err != nil {
+		return total, err
+	}
+	return total + n, nil
+}
+
+// DecodeScale implements scale codec interface.
+func (s *SignedProof) DecodeScale(dec *codec.Decoder) (total int, err error) {
+	{
+		n, err := s.Proof.DecodeScale(dec)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	{
+		n, err := s.Metadata.DecodeScale(dec)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	{
+		value, n, err := codec.DecodeByteSliceWithLimit(dec, 32)
+		s.PowCreator = value
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	{
+		s.Signature = make([]byte, ed25519.SignatureSize)
+		n, err := codec.DecodeByteArray(dec, s.Signature)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// decodeHash32 decodes a 32 byte field encoded by encodeHash32.
+func decodeHash32(dec *codec.Decoder, value *[]byte) (int, error) {
+	*value = make([]byte, 32)
+	return codec.DecodeByteArray(dec, *value)
+}
@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSignedProof(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := bytes.Repeat([]byte{7}, 32)
+	metadata := &PostMetadata{NodeId: id.NodeId(), CommitmentAtxId: bytes.Repeat([]byte{1}, 32), LabelsPerUnit: 4096, NumUnits: 4}
+	proof := &Proof{Nonce: 5, Indices: []byte{1, 2, 3, 4}, Pow: 1 << 40}
+
+	signed, err := NewSignedProof(proof, NewProofMetadata(metadata, challenge), id.NodeId(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeSignedProof(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := VerifySignedProof(data, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Proof.Nonce != 5 || decoded.Proof.Pow != 1<<40 || !bytes.Equal(decoded.Proof.Indices, proof.Indices) ||
+		decoded.Metadata.NumUnits != 4 || decoded.Metadata.LabelsPerUnit != 4096 ||
+		!bytes.Equal(decoded.Metadata.CommitmentAtxId, metadata.CommitmentAtxId) || !bytes.Equal(decoded.PowCreator, id.NodeId()) {
+		t.Fatalf("decoded proof differs: %+v", decoded)
+	}
+
+	if _, err := VerifySignedProof(data, bytes.Repeat([]byte{8}, 32)); !errors.Is(err, ErrChallengeMismatch) {
+		t.Fatalf("expected ErrChallengeMismatch, got %v", err)
+	}
+	// 改动任意签名内容都会使签名失效
+	decoded.Metadata.NumUnits = 5
+	if err := decoded.Verify(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	other, _ := GenerateIdentity()
+	if _, err := NewSignedProof(proof, NewProofMetadata(metadata, challenge), id.NodeId(), other); err == nil {
+		t.Fatal("signed with the key of another node")
+	}
+	if _, err := NewSignedProof(proof, NewProofMetadata(metadata, challenge[:16]), id.NodeId(), id); err == nil {
+		t.Fatal("short challenge accepted")
+	}
+
+	// 没有creatorId的proof也能编码
+	signed, err = NewSignedProof(proof, NewProofMetadata(metadata, challenge), nil, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = EncodeSignedProof(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := VerifySignedProof(data, challenge); err != nil || decoded.PowCreator != nil {
+		t.Fatalf("proof without PowCreator: %+v, %v", decoded, err)
+	}
+	signed.PowCreator = make([]byte, 33)
+	if _, err := EncodeSignedProof(signed); err == nil {
+		t.Fatal("PowCreator longer than 32 bytes encoded")
+	}
+}
@@ -3,8 +3,6 @@ package shared
 import (
 	"fmt"
 	"os"
-	"regexp"
-	"strconv"
 )
 
 func InitFileName(index int) string {
@@ -15,17 +13,8 @@ func IsInitFile(file os.FileInfo) bool {
 	if file.IsDir() {
 		return false
 	}
-
-	re := regexp.MustCompile("postdata_(.*).bin")
-	matches := re.FindStringSubmatch(file.Name())
-	if len(matches) != 2 {
-		return false
-	}
-	if _, err := strconv.Atoi(matches[1]); err != nil {
-		return false
-	}
-
-	return true
+	_, ok := ParseInitFileIndex(file.Name())
+	return ok
 }
 
 func InitFileTmpName(index int) string {
@@ -3,12 +3,9 @@ package shared
 import (
 	"crypto/ed25519"
 	"encoding/hex"
-	"encoding/json"
-	"github.com/trying2016/common-tools/utils"
 	"github.com/zeebo/blake3"
 	"os"
 	"path"
-	"path/filepath"
 )
 
 const (
@@ -18,7 +15,8 @@ const (
 )
 
 const (
-	metadataName = "postdata_metadata.json"
+	// MetadataName is the file the PostMetadata is stored in, next to the post data files.
+	MetadataName = "postdata_metadata.json"
 	KeyName      = "key.bin"
 	bitsPerLabel = 8 * 16
 	LabelLength  = 16
@@ -34,19 +32,6 @@ func NumLabelsToFileSize(numLabels int64) int64 {
 	return numLabels * 16
 }
 
-// ReadMetadata 读取metadata
-func ReadMetadata(dir string) (*PostMetadata, error) {
-	data, err := os.ReadFile(path.Join(dir, metadataName))
-	if err != nil {
-		return nil, err
-	}
-	var metadata PostMetadata
-	if err := json.Unmarshal(data, &metadata); err != nil {
-		return nil, err
-	}
-	return &metadata, nil
-}
-
 // ReadPrivateKey 读取私钥
 func ReadPrivateKey(dir string) ([]byte, error) {
 	data, err := os.ReadFile(path.Join(dir, KeyName))
@@ -61,6 +46,9 @@ func ReadPrivateKey(dir string) ([]byte, error) {
 
 // CheckPrivate 检测私钥是否正确
 func CheckPrivate(privateKey []byte, nodeId []byte) bool {
+	if len(privateKey) != ed25519.PrivateKeySize || len(nodeId) != ed25519.PublicKeySize {
+		return false
+	}
 	msg := []byte("h9-spacemesh")
 	sign := ed25519.Sign(privateKey, msg)
 	return ed25519.Verify(nodeId, msg, sign)
@@ -77,15 +65,15 @@ func CheckPlotComplete(dir string) bool {
 	return uint64(metadata.NumUnits)*metadata.LabelsPerUnit == uint64(FileSizeToNumLabels(filesize))
 }
 
-// PlotFilesize Plot的文件大小
+// PlotFilesize Plot的文件大小，包括manifest中放在其他目录的文件
 func PlotFilesize(dir string) int64 {
-	files, err := filepath.Glob(path.Join(dir, "postdata_*.bin"))
+	files, err := ListInitFiles(dir)
 	if err != nil {
 		return 0
 	}
 	size := int64(0)
-	for _, filename := range files {
-		size += utils.GetFileSize(filename)
+	for _, file := range files {
+		size += file.Size
 	}
 	return size
 }
@@ -0,0 +1,333 @@
+// Package sim predicts how proving behaves for given PoST parameters, analytically and by Monte Carlo
+// simulation, without touching real data. It is meant for sizing plots and tuning nonces.
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/trying2016/post-go/codec"
+	"github.com/trying2016/post-go/shared"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// noncesPerGroup is the number of nonces sharing one AES key and k2pow.
+const noncesPerGroup = 16
+
+// Params are the inputs of a simulation.
+type Params struct {
+	NumUnits      uint32
+	LabelsPerUnit uint64
+	// Nonces is the number of nonces scanned per pass, a multiple of 16.
+	Nonces     uint32
+	K1, K2, K3 uint32
+	// PowDifficulty is the k2pow difficulty of the network, before scaling by the number of units.
+	PowDifficulty []byte
+
+	// DiskBytesPerSec, AESBytesPerSec and Threads estimate the duration of a pass. AESBytesPerSec is the
+	// number of label bytes one thread checks against one nonce group per second. Zero throughputs leave
+	// the times of the result empty.
+	DiskBytesPerSec float64
+	AESBytesPerSec  float64
+	Threads         int
+}
+
+// MainnetParams returns the mainnet parameters for numUnits units and nonces nonces.
+func MainnetParams(numUnits, nonces uint32) Params {
+	cfg := shared.MainnetConfig()
+	return Params{
+		NumUnits:      numUnits,
+		LabelsPerUnit: cfg.LabelsPerUnit,
+		Nonces:        nonces,
+		K1:            cfg.K1,
+		K2:            cfg.K2,
+		K3:            cfg.K3,
+		PowDifficulty: cfg.PowDifficulty[:],
+		Threads:       1,
+	}
+}
+
+func (p Params) validate() error {
+	if p.NumUnits == 0 || p.LabelsPerUnit == 0 {
+		return fmt.Errorf("invalid size; expected: > 0, given: %d units of %d labels", p.NumUnits, p.LabelsPerUnit)
+	}
+	if p.Nonces == 0 || p.Nonces%noncesPerGroup != 0 {
+		return fmt.Errorf("invalid nonces; expected: multiple of %d, given: %d", noncesPerGroup, p.Nonces)
+	}
+	if p.K2 == 0 || p.K3 > p.K2 {
+		return fmt.Errorf("invalid k2/k3; expected: 0 < k3 <= k2, given: k2 %d k3 %d", p.K2, p.K3)
+	}
+	if bits.Len64(p.LabelsPerUnit)+bits.Len32(p.NumUnits) > 64 {
+		return fmt.Errorf("too many labels: %d units of %d labels", p.NumUnits, p.LabelsPerUnit)
+	}
+	return nil
+}
+
+// Result is the analytic prediction for Params.
+type Result struct {
+	NumLabels uint64
+	// Difficulty is the label difficulty of shared.NewProvingParams.
+	Difficulty uint64
+	// PoWDifficulty is the scaled k2pow difficulty of a nonce group.
+	PoWDifficulty [32]byte
+	// PowHashes is the expected number of RandomX hashes for the k2pow of one nonce group.
+	PowHashes float64
+	// InitPowDifficulty is the difficulty of the VRF nonce found during init, see shared.PowDifficulty.
+	InitPowDifficulty []byte
+
+	// Lambda is the expected number of qualifying labels per nonce in a full pass, about K1.
+	Lambda float64
+	// NonceProbability is the probability that a single nonce reaches K2 in one pass.
+	NonceProbability float64
+	// PassProbability is the probability that a pass over all labels finds a proof.
+	PassProbability float64
+	// ExpectedPasses is the expected number of passes until a proof is found.
+	ExpectedPasses float64
+
+	// PassTime is the duration of a full pass, ExpectedTime the expected duration until a proof is found,
+	// counting every pass as complete. Both are zero without throughputs.
+	PassTime     time.Duration
+	ExpectedTime time.Duration
+
+	// IndexBits is the number of bits per proven index, ProofSize the SCALE encoded size of the largest
+	// proof of the first pass and VerifiedLabels the number of labels a verifier checks (K3).
+	IndexBits      int
+	ProofSize      int
+	VerifiedLabels uint32
+}
+
+// MarshalJSON encodes an infinite PowHashes or ExpectedPasses, which JSON cannot represent, as null.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		result
+		PowHashes      *float64
+		ExpectedPasses *float64
+	}{result(r), finite(r.PowHashes), finite(r.ExpectedPasses)})
+}
+
+// finite returns nil for infinite or NaN values.
+func finite(v float64) *float64 {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+// Analyze computes the result for p in closed form. The number of qualifying labels per nonce is
+// binomial over the labels, nonces are treated as independent.
+func Analyze(p Params) (*Result, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	params, err := shared.NewProvingParams(p.NumUnits, p.LabelsPerUnit, p.PowDifficulty, p.K1)
+	if err != nil {
+		return nil, err
+	}
+	numLabels := uint64(p.NumUnits) * p.LabelsPerUnit
+	r := &Result{
+		NumLabels:         numLabels,
+		Difficulty:        params.Difficulty,
+		PoWDifficulty:     params.PoWDifficulty,
+		InitPowDifficulty: shared.PowDifficulty(numLabels),
+		VerifiedLabels:    p.K3,
+	}
+	r.PowHashes = powHashes(params.PoWDifficulty[:])
+	r.Lambda = labelProbability(params.Difficulty) * float64(numLabels)
+	r.NonceProbability = binomialTail(numLabels, labelProbability(params.Difficulty), p.K2)
+	r.PassProbability = 1 - math.Pow(1-r.NonceProbability, float64(p.Nonces))
+	if r.PassProbability > 0 {
+		r.ExpectedPasses = 1 / r.PassProbability
+	} else {
+		r.ExpectedPasses = math.Inf(1)
+	}
+	r.PassTime = passTime(p, numLabels)
+	if r.PassTime > 0 && !math.IsInf(r.ExpectedPasses, 1) {
+		r.ExpectedTime = time.Duration(float64(r.PassTime) * r.ExpectedPasses)
+	}
+	r.IndexBits = bits.Len64(numLabels - 1)
+	if r.ProofSize, err = proofSize(p.Nonces-1, p.K2, r.IndexBits); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// labelProbability is the chance of one encrypted label to be below difficulty.
+func labelProbability(difficulty uint64) float64 {
+	return float64(difficulty) / math.Pow(2, 64)
+}
+
+// powHashes returns the expected number of hashes to find a value below the 256 bit big endian target.
+func powHashes(target []byte) float64 {
+	t := new(big.Float).SetInt(new(big.Int).SetBytes(target))
+	if t.Sign() == 0 {
+		return math.Inf(1)
+	}
+	max := new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 256))
+	hashes, _ := new(big.Float).Quo(max, t).Float64()
+	return hashes
+}
+
+// passTime estimates a full pass: reading and encrypting overlap, the slower one wins.
+func passTime(p Params, numLabels uint64) time.Duration {
+	if p.DiskBytesPerSec <= 0 || p.AESBytesPerSec <= 0 {
+		return 0
+	}
+	threads := p.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	size := float64(numLabels) * 16
+	disk := size / p.DiskBytesPerSec
+	cpu := size * float64(p.Nonces/noncesPerGroup) / (p.AESBytesPerSec * float64(threads))
+	return time.Duration(math.Max(disk, cpu) * float64(time.Second))
+}
+
+// proofSize encodes a proof of k2 indices of indexBits each and returns its size.
+func proofSize(nonce, k2 uint32, indexBits int) (int, error) {
+	proof := shared.Proof{
+		Nonce:   nonce,
+		Indices: make([]byte, (int(k2)*indexBits+7)/8),
+		Pow:     math.MaxUint64,
+	}
+	var buf bytes.Buffer
+	return proof.EncodeScale(codec.NewEncoder(&buf))
+}
+
+// binomialTail returns P(X >= k) for X ~ Binomial(n, p).
+func binomialTail(n uint64, p float64, k uint32) float64 {
+	if uint64(k) > n {
+		return 0
+	}
+	cdf := 0.0
+	pmf := math.Exp(float64(n) * math.Log1p(-p))
+	for i := uint32(0); i < k; i++ {
+		cdf += pmf
+		pmf *= float64(n-uint64(i)) / float64(i+1) * p / (1 - p)
+	}
+	if cdf > 1 {
+		cdf = 1
+	}
+	return 1 - cdf
+}
+
+// Simulation is the outcome of a Monte Carlo run.
+type Simulation struct {
+	Trials int
+	// PassProbability is the share of passes that found a proof.
+	PassProbability float64
+	// MeanPasses is the average number of passes until a proof, counting the last one in full.
+	MeanPasses float64
+	// MeanFraction is the average share of the last pass read before the proof was complete.
+	MeanFraction float64
+	// MeanTime is the average time until a proof, zero without throughputs.
+	MeanTime time.Duration
+	// MaxPasses is the largest number of passes a trial needed.
+	MaxPasses int
+}
+
+// maxSimPasses bounds the passes of a single trial, parameters that rarely find proofs would never end.
+const maxSimPasses = 1000
+
+// Simulate runs trials proofs for p with the given seed. Every nonce of a pass draws its number of
+// qualifying labels from the binomial distribution, and the successful nonces their positions, so the
+// proof can complete early in the pass the way GenerateProof stops reading.
+func Simulate(p Params, trials int, seed int64) (*Simulation, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if trials <= 0 {
+		return nil, fmt.Errorf("invalid trials; expected: > 0, given: %d", trials)
+	}
+	params, err := shared.NewProvingParams(p.NumUnits, p.LabelsPerUnit, p.PowDifficulty, p.K1)
+	if err != nil {
+		return nil, err
+	}
+	numLabels := uint64(p.NumUnits) * p.LabelsPerUnit
+	sampler := newBinomial(numLabels, labelProbability(params.Difficulty))
+	rnd := rand.New(rand.NewSource(seed))
+
+	s := &Simulation{Trials: trials}
+	passes, successes := 0, 0
+	var sumPasses, sumFraction float64
+	for trial := 0; trial < trials; trial++ {
+		for pass := 1; ; pass++ {
+			passes++
+			fraction, found := simulatePass(rnd, sampler, p.Nonces, p.K2)
+			if found || pass == maxSimPasses {
+				if found {
+					successes++
+				}
+				sumPasses += float64(pass)
+				sumFraction += fraction
+				if pass > s.MaxPasses {
+					s.MaxPasses = pass
+				}
+				break
+			}
+		}
+	}
+	s.PassProbability = float64(successes) / float64(passes)
+	s.MeanPasses = sumPasses / float64(trials)
+	s.MeanFraction = sumFraction / float64(trials)
+	if pt := passTime(p, numLabels); pt > 0 {
+		// 最后一轮只读到找到证明的位置
+		s.MeanTime = time.Duration(float64(pt) * (s.MeanPasses - 1 + s.MeanFraction))
+	}
+	return s, nil
+}
+
+// simulatePass returns whether a pass finds a proof and the share of the data read until then.
+func simulatePass(rnd *rand.Rand, sampler *binomial, nonces, k2 uint32) (float64, bool) {
+	best := 2.0
+	for i := uint32(0); i < nonces; i++ {
+		count := sampler.sample(rnd)
+		if count < uint64(k2) {
+			continue
+		}
+		// 满足条件的label位置均匀分布，第k2个的位置即该nonce完成的时刻
+		positions := make([]float64, count)
+		for j := range positions {
+			positions[j] = rnd.Float64()
+		}
+		sort.Float64s(positions)
+		if pos := positions[k2-1]; pos < best {
+			best = pos
+		}
+	}
+	if best > 1 {
+		return 1, false
+	}
+	return best, true
+}
+
+// binomial samples Binomial(n, p) by inverting its CDF, which is cheap while n*p is small.
+type binomial struct {
+	n    uint64
+	p    float64
+	pmf0 float64
+}
+
+func newBinomial(n uint64, p float64) *binomial {
+	return &binomial{n: n, p: p, pmf0: math.Exp(float64(n) * math.Log1p(-p))}
+}
+
+func (b *binomial) sample(rnd *rand.Rand) uint64 {
+	u := rnd.Float64()
+	pmf, cdf := b.pmf0, b.pmf0
+	k := uint64(0)
+	for cdf < u && k < b.n {
+		pmf *= float64(b.n-k) / float64(k+1) * b.p / (1 - b.p)
+		k++
+		cdf += pmf
+		if pmf == 0 {
+			break
+		}
+	}
+	return k
+}
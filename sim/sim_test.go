@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	p := MainnetParams(4, 128)
+	r, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(r.Lambda-float64(p.K1)) > 1e-6 {
+		t.Fatalf("expected %d candidates per nonce, got %v", p.K1, r.Lambda)
+	}
+	// 2^34 个label，每个索引34位
+	if r.IndexBits != 34 {
+		t.Fatalf("expected 34 bits per index, got %d", r.IndexBits)
+	}
+	// nonce(2) + 长度(2) + 索引(158) + pow(9)
+	if r.ProofSize != 2+2+(37*34+7)/8+9 {
+		t.Fatalf("unexpected proof size %d", r.ProofSize)
+	}
+	if r.PassProbability <= r.NonceProbability || r.PassProbability >= 1 {
+		t.Fatalf("pass probability %v out of range (nonce %v)", r.PassProbability, r.NonceProbability)
+	}
+
+	wider := p
+	wider.Nonces = 256
+	rw, err := Analyze(wider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw.PassProbability <= r.PassProbability {
+		t.Fatalf("more nonces must not lower the pass probability: %v vs %v", rw.PassProbability, r.PassProbability)
+	}
+
+	if _, err := Analyze(MainnetParams(4, 100)); err == nil {
+		t.Fatal("expected an error for nonces not a multiple of 16")
+	}
+}
+
+func TestSimulateMatchesAnalyze(t *testing.T) {
+	p := MainnetParams(4, 32)
+	p.DiskBytesPerSec = 1 << 30
+	p.AESBytesPerSec = 1 << 30
+	r, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Simulate(p, 4000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4000次试验的标准差约为0.01
+	if math.Abs(s.PassProbability-r.PassProbability) > 0.04 {
+		t.Fatalf("simulated pass probability %v, analytic %v", s.PassProbability, r.PassProbability)
+	}
+	if s.MeanFraction <= 0 || s.MeanFraction >= 1 {
+		t.Fatalf("mean fraction of the last pass %v", s.MeanFraction)
+	}
+	if s.MeanTime <= 0 || s.MeanTime > r.ExpectedTime {
+		t.Fatalf("simulated time %v should be below the analytic %v that reads every pass in full", s.MeanTime, r.ExpectedTime)
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	r := &Result{PowHashes: math.Inf(1), ExpectedPasses: math.Inf(1), Lambda: 2}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := decoded["ExpectedPasses"]; !ok || v != nil {
+		t.Fatalf("infinite ExpectedPasses encoded as %v", v)
+	}
+	if v, ok := decoded["PowHashes"]; !ok || v != nil {
+		t.Fatalf("infinite PowHashes encoded as %v", v)
+	}
+	if decoded["Lambda"] != 2.0 {
+		t.Fatalf("Lambda encoded as %v", decoded["Lambda"])
+	}
+
+	r.ExpectedPasses = 3
+	data, _ = json.Marshal(r)
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded["ExpectedPasses"] != 3.0 {
+		t.Fatalf("finite ExpectedPasses encoded as %v: %v", decoded["ExpectedPasses"], err)
+	}
+}
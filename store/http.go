@@ -0,0 +1,157 @@
+package store
+
+import (
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTP is a LabelStore reading a post data dir served over HTTP, e.g. by http.FileServer or any
+// object storage supporting range requests. The files are expected at baseURL/postdata_N.bin and the
+// metadata at baseURL/postdata_metadata.json; since HTTP has no listing the layout is derived from the
+// metadata.
+type HTTP struct {
+	baseURL string
+	client  *http.Client
+
+	mu       sync.Mutex
+	metadata *shared.PostMetadata
+}
+
+// NewHTTP returns a store reading from baseURL with client, http.DefaultClient when nil.
+func NewHTTP(baseURL string, client *http.Client) *HTTP {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTP{baseURL: strings.TrimRight(baseURL, "/"), client: client}
+}
+
+// Metadata fetches the metadata once and returns a copy of it afterwards.
+func (h *HTTP) Metadata() (*shared.PostMetadata, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.metadata == nil {
+		resp, err := h.client.Get(h.baseURL + "/" + shared.MetadataName)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: %s", shared.MetadataName, resp.Status)
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := shared.ParseMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", shared.MetadataName, err)
+		}
+		h.metadata = metadata
+	}
+	metadata := *h.metadata
+	return &metadata, nil
+}
+
+func (h *HTTP) Files() ([]File, error) {
+	metadata, err := h.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	size, err := dataSize(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return splitFiles(size, metadata.MaxFileSize), nil
+}
+
+// ReadAt fetches the range with a single request.
+func (h *HTTP) ReadAt(index int, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	name := shared.InitFileName(index)
+	req, err := http.NewRequest(http.MethodGet, h.baseURL+"/"+name, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// 只接受从off开始、不超过请求长度的范围，较短的范围只能在文件末尾
+		last := off + int64(len(p)) - 1
+		start, end, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return 0, fmt.Errorf("reading %s: %w", name, err)
+		}
+		if start != off || end > last || (end < last && size >= 0 && end != size-1) {
+			return 0, fmt.Errorf("reading %s: invalid Content-Range; expected: bytes %d-%d, given: bytes %d-%d/%d", name, off, last, start, end, size)
+		}
+		p = p[:end-start+1]
+		n, err := io.ReadFull(resp.Body, p)
+		if err == nil && end < last {
+			err = io.EOF
+		}
+		return n, err
+	case http.StatusOK:
+		// 服务端忽略了Range，只能从头读
+		if off != 0 {
+			return 0, fmt.Errorf("reading %s: server does not support range requests", name)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("%w: %s", ErrNoFile, name)
+	default:
+		return 0, fmt.Errorf("reading %s: %s", name, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		// 文件末尾的短读
+		err = io.EOF
+	}
+	return n, err
+}
+
+// parseContentRange parses a Content-Range header of the form "bytes start-end/size", size is -1 when
+// given as "*".
+func parseContentRange(header string) (start, end, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range: %q", header)
+	spec := strings.TrimPrefix(header, "bytes ")
+	if spec == header {
+		return 0, 0, 0, invalid
+	}
+	slash := strings.IndexByte(spec, '/')
+	dash := strings.IndexByte(spec, '-')
+	if slash < 0 || dash < 0 || dash > slash {
+		return 0, 0, 0, invalid
+	}
+	if start, err = strconv.ParseInt(spec[:dash], 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if end, err = strconv.ParseInt(spec[dash+1:slash], 10, 64); err != nil || end < start {
+		return 0, 0, 0, invalid
+	}
+	size = -1
+	if spec[slash+1:] != "*" {
+		if size, err = strconv.ParseInt(spec[slash+1:], 10, 64); err != nil || size <= end {
+			return 0, 0, 0, invalid
+		}
+	}
+	return start, end, size, nil
+}
+
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"os"
+	"sync"
+)
+
+// Local is the LabelStore of a post data dir on a local file system.
+type Local struct {
+	dir string
+
+	mu    sync.Mutex
+	files map[int]*os.File
+}
+
+// NewLocal returns the store of the post data in dir.
+func NewLocal(dir string) (*Local, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &Local{dir: dir, files: make(map[int]*os.File)}, nil
+}
+
+// Dir returns the post data dir.
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+func (l *Local) Metadata() (*shared.PostMetadata, error) {
+	return shared.ReadMetadata(l.dir)
+}
+
+// Files lists the postdata_N.bin files of the dir and its manifest in numeric order of N.
+func (l *Local) Files() ([]File, error) {
+	entries, err := shared.ListInitFiles(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, File{Index: entry.Index, Size: entry.Size})
+	}
+	return files, nil
+}
+
+func (l *Local) ReadAt(index int, p []byte, off int64) (int, error) {
+	file, err := l.open(index)
+	if err != nil {
+		return 0, err
+	}
+	return file.ReadAt(p, off)
+}
+
+// open returns the opened file index, files stay open until Close.
+func (l *Local) open(index int) (*os.File, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if file, ok := l.files[index]; ok {
+		return file, nil
+	}
+	filename, err := shared.InitFilePath(l.dir, index)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNoFile, shared.InitFileName(index))
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.files[index] = file
+	return file, nil
+}
+
+func (l *Local) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var firstErr error
+	for index, file := range l.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(l.files, index)
+	}
+	return firstErr
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
+)
+
+// Memory is a LabelStore holding the post data in a buffer, e.g. for tests.
+type Memory struct {
+	metadata shared.PostMetadata
+	data     []byte
+	files    []File
+}
+
+// NewMemory returns a store serving data, cut into files of metadata.MaxFileSize. data must hold all
+// labels of metadata.
+func NewMemory(metadata *shared.PostMetadata, data []byte) (*Memory, error) {
+	size, err := dataSize(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("invalid data size; expected: %d, given: %d", size, len(data))
+	}
+	return &Memory{
+		metadata: *metadata,
+		data:     data,
+		files:    splitFiles(size, metadata.MaxFileSize),
+	}, nil
+}
+
+func (m *Memory) Metadata() (*shared.PostMetadata, error) {
+	metadata := m.metadata
+	return &metadata, nil
+}
+
+func (m *Memory) Files() ([]File, error) {
+	return append([]File(nil), m.files...), nil
+}
+
+func (m *Memory) ReadAt(index int, p []byte, off int64) (int, error) {
+	if index < 0 || index >= len(m.files) {
+		return 0, fmt.Errorf("%w: %s", ErrNoFile, shared.InitFileName(index))
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset; expected: >= 0, given: %d", off)
+	}
+	size := m.files[index].Size
+	if off >= size {
+		return 0, io.EOF
+	}
+	start := int64(index)*int64(m.metadata.MaxFileSize) + off
+	end := start + int64(len(p))
+	if limit := int64(index)*int64(m.metadata.MaxFileSize) + size; end > limit {
+		end = limit
+	}
+	n := copy(p, m.data[start:end])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package store abstracts where post data lives. A LabelStore serves the metadata and ranged reads of the
+// post data files, so proving and label lookups work the same on a local dir, an in-memory buffer or
+// storage behind HTTP.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/trying2016/post-go/shared"
+	"io"
+)
+
+// ErrNoFile is returned by ReadAt for a file index the store does not hold.
+var ErrNoFile = errors.New("no such post data file")
+
+// File describes one post data file of a store.
+type File struct {
+	// Index is the number N of postdata_N.bin, the file starts at label Index*MaxFileSize/16.
+	Index int
+	Size  int64
+}
+
+// LabelStore is a source of post data.
+type LabelStore interface {
+	// Metadata returns the metadata of the post data.
+	Metadata() (*shared.PostMetadata, error)
+	// Files returns the post data files ordered by index.
+	Files() ([]File, error)
+	// ReadAt reads len(p) bytes at off of the file with the given index. Like io.ReaderAt it returns
+	// io.EOF when fewer bytes are left.
+	ReadAt(index int, p []byte, off int64) (int, error)
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// Size returns the total size of the post data files of s.
+func Size(s LabelStore) (int64, error) {
+	files, err := s.Files()
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, file := range files {
+		size += file.Size
+	}
+	return size, nil
+}
+
+// ReadLabels reads count labels starting at label index, crossing file boundaries as needed.
+func ReadLabels(s LabelStore, index, count uint64) ([]byte, error) {
+	metadata, err := s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	if metadata.MaxFileSize == 0 || metadata.MaxFileSize%shared.LabelLength != 0 {
+		return nil, fmt.Errorf("invalid max file size; expected: multiple of %d, given: %d", shared.LabelLength, metadata.MaxFileSize)
+	}
+	numLabels := uint64(metadata.NumUnits) * metadata.LabelsPerUnit
+	if index+count > numLabels || index+count < index {
+		return nil, fmt.Errorf("labels %d..%d out of range; expected: < %d", index, index+count, numLabels)
+	}
+	labels := make([]byte, count*shared.LabelLength)
+	pos := index * shared.LabelLength
+	for done := 0; done < len(labels); {
+		file := int(pos / metadata.MaxFileSize)
+		off := pos % metadata.MaxFileSize
+		n := len(labels) - done
+		if left := metadata.MaxFileSize - off; uint64(n) > left {
+			n = int(left)
+		}
+		read, err := s.ReadAt(file, labels[done:done+n], int64(off))
+		if read < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("reading %s at %d: %w", shared.InitFileName(file), off, err)
+		}
+		done += n
+		pos += uint64(n)
+	}
+	return labels, nil
+}
+
+// ReadLabel reads the label at index.
+func ReadLabel(s LabelStore, index uint64) ([]byte, error) {
+	return ReadLabels(s, index, 1)
+}
+
+// splitFiles returns the files holding size bytes of post data cut into files of maxFileSize.
+func splitFiles(size, maxFileSize uint64) []File {
+	var files []File
+	for i := 0; uint64(i)*maxFileSize < size; i++ {
+		fileSize := size - uint64(i)*maxFileSize
+		if fileSize > maxFileSize {
+			fileSize = maxFileSize
+		}
+		files = append(files, File{Index: i, Size: int64(fileSize)})
+	}
+	return files
+}
+
+// dataSize is the size of the post data described by metadata.
+func dataSize(metadata *shared.PostMetadata) (uint64, error) {
+	if metadata.MaxFileSize == 0 {
+		return 0, errors.New("invalid max file size; expected: > 0, given: 0")
+	}
+	return uint64(metadata.NumUnits) * metadata.LabelsPerUnit * shared.LabelLength, nil
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"github.com/trying2016/post-go/shared"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDir writes metadata and labels to a temporary post data dir, cut into files of MaxFileSize.
+func writeDir(t *testing.T, metadata *shared.PostMetadata, labels []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, shared.MetadataName), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range splitFiles(uint64(len(labels)), metadata.MaxFileSize) {
+		start := uint64(file.Index) * metadata.MaxFileSize
+		if err := os.WriteFile(filepath.Join(dir, shared.InitFileName(file.Index)), labels[start:start+uint64(file.Size)], 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStores(t *testing.T) {
+	metadata := &shared.PostMetadata{
+		NodeId:          make([]byte, 32),
+		CommitmentAtxId: make([]byte, 32),
+		LabelsPerUnit:   1000,
+		NumUnits:        12,
+		MaxFileSize:     1600,
+	}
+	labels := make([]byte, 12*1000*16)
+	rand.Read(labels)
+	dir := writeDir(t, metadata, labels)
+
+	local, err := NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	memory, err := NewMemory(metadata, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+	remote := NewHTTP(server.URL+"/", server.Client())
+	defer remote.Close()
+
+	for name, s := range map[string]LabelStore{"local": local, "memory": memory, "http": remote} {
+		t.Run(name, func(t *testing.T) {
+			files, err := s.Files()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 文件按数字排序: postdata_2.bin 在 postdata_10.bin 之前
+			if len(files) != 120 || files[2].Index != 2 || files[10].Index != 10 {
+				t.Fatalf("files: %v", files[:11])
+			}
+			if size, err := Size(s); err != nil || size != int64(len(labels)) {
+				t.Fatalf("size %d, %v", size, err)
+			}
+			got, err := ReadLabels(s, 95, 20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, labels[95*16:115*16]) {
+				t.Fatal("labels across files differ")
+			}
+			if _, err := ReadLabels(s, 11995, 6); err == nil {
+				t.Fatal("read past the end")
+			}
+
+			last := files[len(files)-1]
+			buf := make([]byte, 32)
+			n, err := s.ReadAt(last.Index, buf, last.Size-16)
+			if n != 16 || err != io.EOF {
+				t.Fatalf("short read: %d, %v", n, err)
+			}
+			if _, err := s.ReadAt(1000, buf, 0); !errors.Is(err, ErrNoFile) {
+				t.Fatalf("missing file: %v", err)
+			}
+			m, err := s.Metadata()
+			if err != nil || m.MaxFileSize != metadata.MaxFileSize {
+				t.Fatalf("metadata %+v, %v", m, err)
+			}
+		})
+	}
+}
+
+func TestLocalManifest(t *testing.T) {
+	metadata := &shared.PostMetadata{
+		NodeId:          make([]byte, 32),
+		CommitmentAtxId: make([]byte, 32),
+		LabelsPerUnit:   100,
+		NumUnits:        2,
+		MaxFileSize:     1600,
+	}
+	labels := make([]byte, 2*100*16)
+	rand.Read(labels)
+	dir := writeDir(t, metadata, labels)
+	if err := shared.MoveInitFile(dir, 1, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	got, err := ReadLabels(local, 90, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, labels[90*16:110*16]) {
+		t.Fatal("labels of a moved file differ")
+	}
+}
+
+func TestHTTPContentRange(t *testing.T) {
+	var contentRange string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", contentRange)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, 16))
+	}))
+	defer server.Close()
+	remote := NewHTTP(server.URL, server.Client())
+	defer remote.Close()
+
+	buf := make([]byte, 16)
+	for _, cr := range []string{"bytes 0-15/3200", "bytes 32-47/3200", "bytes 16-47/3200", "bytes 16-23/3200", "16-31/3200", ""} {
+		contentRange = cr
+		if _, err := remote.ReadAt(0, buf, 16); err == nil {
+			t.Errorf("Content-Range %q accepted for bytes 16-31", cr)
+		}
+	}
+	contentRange = "bytes 16-31/3200"
+	if n, err := remote.ReadAt(0, buf, 16); n != 16 || err != nil {
+		t.Fatalf("matching range: %d, %v", n, err)
+	}
+	// 文件末尾的短范围
+	contentRange = "bytes 16-23/24"
+	if n, err := remote.ReadAt(0, buf, 16); n != 8 || err != io.EOF {
+		t.Fatalf("short range at the end: %d, %v", n, err)
+	}
+}